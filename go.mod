@@ -8,10 +8,14 @@ require (
 	github.com/klauspost/compress v1.16.3
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.40.0
 	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/metric v0.37.0
 	go.opentelemetry.io/otel/trace v1.14.0
 	go.seankhliao.com/earbug/v3 v3.0.0-20230320183431-ad90b64fd07a
 	go.seankhliao.com/gchat v0.0.0-20230226053514-3b0819415c5c
 	go.seankhliao.com/svcrunner v0.4.10
+	golang.org/x/oauth2 v0.6.0
+	golang.org/x/sync v0.1.0
+	google.golang.org/api v0.114.0
 	google.golang.org/protobuf v1.30.0
 )
 
@@ -51,17 +55,14 @@ require (
 	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.14.0 // indirect
 	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.14.0 // indirect
 	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0 // indirect
-	go.opentelemetry.io/otel/metric v0.37.0 // indirect
 	go.opentelemetry.io/otel/sdk v1.14.0 // indirect
 	go.opentelemetry.io/otel/sdk/metric v0.37.0 // indirect
 	go.opentelemetry.io/proto/otlp v0.19.0 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	golang.org/x/net v0.8.0 // indirect
-	golang.org/x/oauth2 v0.6.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
-	google.golang.org/api v0.114.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230320173215-1fe4d14fc725 // indirect
 	google.golang.org/grpc v1.53.0 // indirect
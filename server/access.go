@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type accessInfoKey struct{}
+
+// accessInfo collects details about a request from its handler.
+type accessInfo struct {
+	user string
+}
+
+// setAccessUser records the user a request was for in its access log.
+func setAccessUser(ctx context.Context, user string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.user = user
+	}
+}
+
+// accessLog logs every request once it completes.
+func (s *Server) accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &accessInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info))
+		aw := &accessWriter{ResponseWriter: rw}
+
+		h.ServeHTTP(aw, r)
+
+		if aw.code == 0 {
+			aw.code = http.StatusOK
+		}
+		s.log.WithName("access").Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"user", info.user,
+			"status", aw.code,
+			"bytes", aw.bytes,
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// accessWriter records the status and size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush passes flushes through for streamed responses.
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
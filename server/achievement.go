@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// Achievement is a rule celebrated when a summary meets it.
+type Achievement interface {
+	// Evaluate reports whether sum earned the achievement,
+	// and the line to show for it.
+	Evaluate(sum *Summary, data *earbugv3.Store) (bool, string)
+}
+
+// achievements are evaluated in order for every summary.
+var achievements = []Achievement{
+	everyDay{},
+	newArtists{min: 10},
+	artistMilestone{marks: []int{100, 500, 1000, 5000, 10000}},
+}
+
+// everyDay is earned by a week with plays on each of its days.
+type everyDay struct{}
+
+func (everyDay) Evaluate(sum *Summary, data *earbugv3.Store) (bool, string) {
+	if len(sum.Days) != 7 {
+		return false, ""
+	}
+	for _, d := range sum.Days {
+		if d.Plays == 0 {
+			return false, ""
+		}
+	}
+	return true, "🔥 listened every day this week"
+}
+
+// newArtists is earned by first hearing at least min artists in the window.
+type newArtists struct {
+	min int
+}
+
+func (a newArtists) Evaluate(sum *Summary, data *earbugv3.Store) (bool, string) {
+	var n int
+	for id, plays := range sum.artistPlays {
+		if sum.artistTotals[id] == plays {
+			n++
+		}
+	}
+	if n < a.min {
+		return false, ""
+	}
+	return true, fmt.Sprintf("🧭 discovered %v new artists", n)
+}
+
+// artistMilestone is earned by an artist's plays passing one of marks,
+// counting every play up to the end of the window.
+type artistMilestone struct {
+	marks []int
+}
+
+func (a artistMilestone) Evaluate(sum *Summary, data *earbugv3.Store) (bool, string) {
+	var parts []string
+	for _, id := range sortedKeys(sum.artistPlays) {
+		total := sum.artistTotals[id]
+		before := total - sum.artistPlays[id]
+		passed := 0
+		for _, m := range a.marks {
+			if before < m && m <= total {
+				passed = m
+			}
+		}
+		if passed > 0 {
+			parts = append(parts, fmt.Sprintf("%s play of %s", ordinal(passed), artistName(data, id)))
+		}
+	}
+	if len(parts) == 0 {
+		return false, ""
+	}
+	return true, "🎉 " + strings.Join(parts, ", ")
+}
+
+// ordinal formats n as 1st, 2nd, 3rd, 4th...
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return fmt.Sprintf("%v%s", n, suffix)
+}
+
+// artistName is the name of an artist on any track, in track id order,
+// falling back to the id.
+func artistName(data *earbugv3.Store, id string) string {
+	for _, tid := range sortedKeys(data.Tracks) {
+		for _, a := range data.Tracks[tid].GetArtists() {
+			if a.Id == id && a.Name != "" {
+				return a.Name
+			}
+		}
+	}
+	return id
+}
+
+// earnedAchievements evaluates every achievement for sum.
+func earnedAchievements(sum *Summary, data *earbugv3.Store) []string {
+	var earned []string
+	for _, a := range achievements {
+		if ok, msg := a.Evaluate(sum, data); ok {
+			earned = append(earned, msg)
+		}
+	}
+	return earned
+}
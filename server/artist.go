@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// artistSummary is a user's plays of a single artist over recent days.
+type artistSummary struct {
+	Artist string `json:"artist"`
+	Name   string `json:"name"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Plays  int    `json:"plays"`
+	// Tracks of the artist played, most played first
+	Tracks []TrackCount `json:"tracks"`
+	// NewTracks are the tracks played for the first time
+	NewTracks []TrackCount `json:"newTracks,omitempty"`
+}
+
+// summaryArtist summarizes the ?days= (default 7) up to and including yesterday
+// for a single ?artist= id.
+func (s *Server) summaryArtist(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("summary-artist")
+	ctx, span := s.trace.Start(r.Context(), "summary-artist")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	artist, days, err := func() (string, int, error) {
+		q := r.URL.Query()
+		artist := q.Get("artist")
+		if artist == "" {
+			return "", 0, errors.New("no artist provided")
+		}
+		days := 7
+		if v := q.Get("days"); v != "" {
+			var err error
+			days, err = strconv.Atoi(v)
+			if err != nil {
+				return "", 0, fmt.Errorf("parse days: %w", err)
+			}
+		}
+		if days < 1 || days > 366 {
+			return "", 0, invalidf("days %d out of range 1-366", days)
+		}
+		return artist, days, nil
+	}()
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("artist", artist, "days", days)
+	opts, err := s.summaryOptions(r.URL.Query())
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	dates := lastDays(s.clock(), s.loc, days)
+	res := s.artistSummary(data.Store, artist, dates[0], dates[len(dates)-1])
+	if wantsJSON(r) {
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(res)
+	} else {
+		rw.Write([]byte(res.render(opts) + "\n"))
+	}
+	log.Info("served artist summary", "plays", res.Plays, "ctx", ctx, "http_request", r)
+}
+
+func (s *Server) artistSummary(data *earbugv3.Store, artist, from, to string) artistSummary {
+	name, tracks := artistTracks(data, artist)
+	counted := s.countedPlay(data)
+	counts := make(map[string]int)
+	playedBefore := make(map[string]struct{})
+	for key, played := range data.Playbacks {
+		if _, ok := tracks[played.TrackId]; !ok || !counted(played) {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			continue
+		}
+		switch day := ts.In(s.loc).Format(dateLayout); {
+		case day < from:
+			playedBefore[played.TrackId] = struct{}{}
+		case day <= to:
+			counts[played.TrackId]++
+		}
+	}
+
+	res := artistSummary{
+		Artist: artist,
+		Name:   name,
+		From:   from,
+		To:     to,
+		Tracks: topTracks(data, counts, len(counts)),
+	}
+	newCounts := make(map[string]int)
+	for id, n := range counts {
+		res.Plays += n
+		if _, ok := playedBefore[id]; !ok {
+			newCounts[id] = n
+		}
+	}
+	if len(newCounts) > 0 {
+		res.NewTracks = topTracks(data, newCounts, len(newCounts))
+	}
+	return res
+}
+
+func (a artistSummary) render(opts summaryOptions) string {
+	header := fmt.Sprintf("%s %s..%s", a.Name, formatDate(a.From, opts.dateFormat), formatDate(a.To, opts.dateFormat))
+	if a.Plays == 0 {
+		return fmt.Sprintf(opts.label("artistNone"), header)
+	}
+	parts := []string{
+		fmt.Sprintf(opts.label("artist"), header, formatCount(a.Plays, opts.thousands), formatCount(len(a.Tracks), opts.thousands)),
+		fmt.Sprintf(opts.label("artistTracks"), formatTrackList(a.Tracks, opts)),
+	}
+	if len(a.NewTracks) > 0 {
+		names := make([]string, 0, len(a.NewTracks))
+		for _, t := range a.NewTracks {
+			names = append(names, t.Name)
+		}
+		parts = append(parts, fmt.Sprintf(opts.label("artistFirst"), strings.Join(names, ", ")))
+	}
+	return strings.Join(parts, "\n")
+}
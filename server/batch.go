@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// manifest lists the users summarized by /summary/all,
+// per user fields override the global config.
+type manifest struct {
+	Users []manifestUser `json:"users"`
+}
+
+type manifestUser struct {
+	User     string `json:"user"`
+	Timezone string `json:"timezone,omitempty"`
+	Webhook  string `json:"webhook,omitempty"`
+	// Sections to render when the request doesn't set fields
+	Sections []string `json:"sections,omitempty"`
+	// Lang of labels when the request doesn't set lang
+	Lang string `json:"lang,omitempty"`
+}
+
+// options applies the user's overrides to the request options.
+func (u manifestUser) options(opts summaryOptions) summaryOptions {
+	if len(opts.fields) == 0 && len(u.Sections) > 0 {
+		opts.fields = u.Sections
+	}
+	if opts.lang == "" {
+		opts.lang = u.Lang
+	}
+	return opts
+}
+
+// validSections checks every name is a known section.
+func validSections(names []string) error {
+	for _, name := range names {
+		if _, ok := sections[name]; !ok {
+			return fmt.Errorf("unknown section %q", name)
+		}
+	}
+	return nil
+}
+
+// listUsers returns the users to summarize in a batch run,
+// read from the manifest object if configured,
+// otherwise found by scanning the bucket for store objects.
+// The manifest is reread on every call so additions take effect.
+func (s *Server) listUsers(ctx context.Context) ([]manifestUser, error) {
+	ctx, span := s.trace.Start(ctx, "list-users")
+	defer span.End()
+
+	store, err := s.objects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.manifest != "" {
+		or, err := store.NewReader(ctx, s.manifest)
+		if err != nil {
+			s.checkCredentials(ctx, err)
+			return nil, fmt.Errorf("read manifest: %w", err)
+		}
+		defer or.Close()
+		b, err := io.ReadAll(or)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest: %w", err)
+		}
+		var m manifest
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal manifest: %w", err)
+		}
+		for i, u := range m.Users {
+			if u.User == "" {
+				return nil, fmt.Errorf("manifest entry %d: no user", i)
+			} else if err := validSections(u.Sections); err != nil {
+				return nil, fmt.Errorf("manifest entry %d: %w", i, err)
+			} else if err := validLang(u.Lang); err != nil {
+				return nil, fmt.Errorf("manifest entry %d: %w", i, err)
+			}
+		}
+		return m.Users, nil
+	}
+
+	var users []manifestUser
+	seen := make(map[string]bool)
+	for _, suffix := range []string{storeSuffix, gzipStoreSuffix} {
+		names, err := store.List(ctx, suffix)
+		if err != nil {
+			s.checkCredentials(ctx, err)
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+		for _, name := range names {
+			name = strings.TrimSuffix(name, suffix)
+			if name == "" || strings.Contains(name, "/") || seen[name] {
+				continue
+			}
+			seen[name] = true
+			users = append(users, manifestUser{User: name})
+		}
+	}
+	return users, nil
+}
+
+// bodyUsers returns the users given in the request body,
+// a json array of users or a single user object,
+// or nil for an empty body.
+func (s *Server) bodyUsers(r *http.Request) ([]manifestUser, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var users []manifestUser
+	switch b[0] {
+	case '[':
+		err = json.Unmarshal(b, &users)
+	case '{':
+		users = make([]manifestUser, 1)
+		err = json.Unmarshal(b, &users[0])
+	default:
+		err = errors.New("body is not a json array or object")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("no users in body")
+	} else if len(users) > s.batchMaxUsers {
+		return nil, fmt.Errorf("%d users in body, more than earbug.batch.maxusers %d", len(users), s.batchMaxUsers)
+	}
+	for i, u := range users {
+		if u.User == "" {
+			return nil, fmt.Errorf("body entry %d: no user", i)
+		} else if err := validSections(u.Sections); err != nil {
+			return nil, fmt.Errorf("body entry %d: %w", i, err)
+		} else if err := validLang(u.Lang); err != nil {
+			return nil, fmt.Errorf("body entry %d: %w", i, err)
+		} else if u.Webhook != "" && !s.webhookOverride {
+			return nil, fmt.Errorf("body entry %d: webhook override requested but not enabled", i)
+		}
+	}
+	return users, nil
+}
+
+type batchResult struct {
+	User   string `json:"user"`
+	Status int    `json:"status"`
+	// Message is the summary, or why there's none
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s *Server) summaryAll(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("summary-all")
+	ctx, span := s.trace.Start(r.Context(), "summary-all")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		msg := "invalid method"
+		http.Error(rw, msg, http.StatusMethodNotAllowed)
+		log.Error(errors.New("POST only"), msg, "method", r.Method, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	// ndjson is the format of the response, not of the posted messages
+	q := r.URL.Query()
+	ndjson := q.Get("format") == formatNDJSON
+	if ndjson {
+		q.Del("format")
+	}
+	opts, err := s.summaryOptions(q)
+	if len(opts.ignoredFields) > 0 {
+		log.Info("ignoring unknown fields", "fields", opts.ignoredFields)
+	}
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	users, err := s.bodyUsers(r)
+	if err != nil {
+		msg := "invalid users in body"
+		http.Error(rw, msg, http.StatusBadRequest)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	if users == nil {
+		users, err = s.listUsers(ctx)
+	}
+	if err != nil {
+		msg := "list users"
+		http.Error(rw, msg, http.StatusInternalServerError)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	var enc *json.Encoder
+	var flusher http.Flusher
+	if ndjson {
+		rw.Header().Set("content-type", "application/x-ndjson")
+		enc = json.NewEncoder(rw)
+		flusher, _ = rw.(http.Flusher)
+	}
+	status := http.StatusOK
+	results := make([]batchResult, 0, len(users))
+	for _, u := range users {
+		log := log.WithValues("user", u.User)
+		sum, msg, code, err := s.summarizeUser(ctx, u, opts)
+		res := batchResult{
+			User:    u.User,
+			Status:  code,
+			Message: msg,
+		}
+		if err != nil {
+			status = http.StatusInternalServerError
+			res.Error = err.Error()
+			log.Error(err, msg, "ctx", ctx)
+			s.notifyFailure(ctx, u.User, msg)
+		}
+		if ndjson {
+			if err != nil || sum == nil {
+				enc.Encode(res)
+			} else {
+				enc.Encode(sum)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			continue
+		}
+		results = append(results, res)
+	}
+	if ndjson {
+		log.Info("streamed summaries", "users", len(users), "ctx", ctx, "http_request", r)
+		return
+	}
+
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(results)
+	log.Info("posted summaries", "users", len(users), "ctx", ctx, "http_request", r)
+}
+
+// formatNDJSON streams a summary, or result if it failed, per line.
+const formatNDJSON = "ndjson"
+
+// summarizeUser posts the summary for a single batch user,
+// applying its overrides.
+// Formats that aren't posted, e.g. markdown, are only rendered.
+func (s *Server) summarizeUser(ctx context.Context, u manifestUser, opts summaryOptions) (*Summary, string, int, error) {
+	loc, client, msg, code, err := s.userTarget(ctx, u, postsToChat(opts.format))
+	if err != nil {
+		return nil, msg, code, err
+	}
+	if opts.loc != nil {
+		loc = opts.loc
+	}
+
+	win, _ := yesterday(nil, s.now(opts), loc)
+	timing := &serverTiming{}
+	data, err := s.readStore(ctx, u.User, timing)
+	if err != nil {
+		msg, code := stageStatus(err)
+		return nil, msg, code, err
+	}
+	return s.postSummary(ctx, client, loc, win, data, u.options(opts), timing)
+}
+
+// userTarget returns the time zone and notifier for a batch user,
+// a nil notifier without post.
+func (s *Server) userTarget(ctx context.Context, u manifestUser, post bool) (*time.Location, Notifier, string, int, error) {
+	loc := s.loc
+	if u.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(u.Timezone)
+		if err != nil {
+			return nil, nil, "load timezone", http.StatusInternalServerError, err
+		}
+	}
+	if !post {
+		return loc, nil, "", 0, nil
+	}
+	var endpoint string
+	if u.Webhook != "" {
+		var err error
+		endpoint, err = s.parseWebhook(u.Webhook)
+		if err != nil {
+			return nil, nil, "invalid webhook", http.StatusInternalServerError, err
+		}
+	}
+	client, err := s.notifierFor(ctx, endpoint)
+	if err != nil {
+		return nil, nil, "no webhook configured", http.StatusInternalServerError, err
+	}
+	return loc, client, "", 0, nil
+}
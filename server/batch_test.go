@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSummaryAllMarkdown(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &postRecorder{}
+	s := newTestServer(t, store, n, nil)
+
+	rw := serve(s, http.MethodPost, "/summary/all?format=markdown", "", nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rw.Code, rw.Body)
+	}
+	var results []batchResult
+	err := json.Unmarshal(rw.Body.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(results[0].Message, "# Listening summary for alice") {
+		t.Errorf("got results %+v, want alice's markdown", results)
+	}
+	if posts := n.texts(); len(posts) != 0 {
+		t.Errorf("posted %q, markdown isn't posted", posts)
+	}
+}
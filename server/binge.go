@@ -0,0 +1,64 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const bingeTopN = 3
+
+// Binge is a run of plays from one album in a single session.
+type Binge struct {
+	// Album is the album's context uri,
+	// the store has no album names
+	Album  string    `json:"album"`
+	Start  time.Time `json:"start"`
+	Tracks int       `json:"tracks"`
+}
+
+// albumContext returns the album a play was started from, if any.
+func albumContext(p playback) (string, bool) {
+	uri := p.played.GetContextUri()
+	if p.played.GetContextType() == "album" || strings.HasPrefix(uri, "spotify:album:") {
+		return uri, uri != ""
+	}
+	return "", false
+}
+
+// albumBinges finds runs of at least minTracks consecutive plays
+// from the same album context, with no gaps of gap or more between them,
+// longest first, then earliest.
+func albumBinges(plays []playback, gap time.Duration, minTracks int) []Binge {
+	var binges []Binge
+	var cur Binge
+	var end time.Time
+	flush := func() {
+		if cur.Tracks >= minTracks {
+			binges = append(binges, cur)
+		}
+		cur = Binge{}
+	}
+	for _, p := range plays {
+		album, ok := albumContext(p)
+		if !ok || album != cur.Album || p.ts.Sub(end) >= gap {
+			flush()
+		}
+		if ok {
+			if cur.Tracks == 0 {
+				cur = Binge{Album: album, Start: p.ts}
+			}
+			cur.Tracks++
+		}
+		end = p.ts.Add(p.dur)
+	}
+	flush()
+
+	sort.SliceStable(binges, func(i, j int) bool {
+		return binges[i].Tracks > binges[j].Tracks
+	})
+	if len(binges) > bingeTopN {
+		binges = binges[:bingeTopN]
+	}
+	return binges
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breaker fails bucket reads fast after consecutive failures,
+// letting a single probe through once the cooldown has passed.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a read may be attempted.
+func (b *breaker) allow(now time.Time, threshold int) bool {
+	if threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the result of an allowed read.
+// Missing objects don't count as failures, the bucket answered.
+func (b *breaker) done(err error, now time.Time, threshold int, cooldown time.Duration) {
+	if threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil || errors.Is(err, storage.ErrObjectNotExist) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+type breakerStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+}
+
+func (b *breaker) status(now time.Time, threshold int) breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := breakerStatus{
+		State:    breakerClosed,
+		Failures: b.failures,
+	}
+	if threshold > 0 && b.failures >= threshold {
+		st.State = breakerHalfOpen
+		if now.Before(b.openUntil) {
+			st.State = breakerOpen
+			until := b.openUntil
+			st.OpenUntil = &until
+		}
+	}
+	return st
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	chat "google.golang.org/api/chat/v1"
+)
+
+// maxTrackButtons keeps the button row short,
+// further tracks are listed as links in text below it.
+const maxTrackButtons = 5
+
+// spotifyURL is the web page for a spotify:track:ID uri.
+func spotifyURL(uri string) (string, bool) {
+	id := strings.TrimPrefix(uri, "spotify:track:")
+	if id == uri || id == "" {
+		return "", false
+	}
+	return "https://open.spotify.com/track/" + id, true
+}
+
+// trackButtons returns a card with buttons linking top tracks to spotify,
+// nil if none of them have a spotify uri.
+func trackButtons(data *earbugv3.Store, top []TrackCount) []*chat.CardWithId {
+	var buttons []*chat.GoogleAppsCardV1Button
+	var spill []string
+	for i, t := range top {
+		link, ok := spotifyURL(data.Tracks[t.ID].GetUri())
+		if !ok {
+			continue
+		}
+		if len(buttons) < maxTrackButtons {
+			buttons = append(buttons, &chat.GoogleAppsCardV1Button{
+				Text: t.Name,
+				OnClick: &chat.GoogleAppsCardV1OnClick{
+					OpenLink: &chat.GoogleAppsCardV1OpenLink{Url: link},
+				},
+			})
+			continue
+		}
+		spill = append(spill, fmt.Sprintf(`%v. <a href="%s">%s</a>`, i+1, html.EscapeString(link), html.EscapeString(t.Name)))
+	}
+	if len(buttons) == 0 {
+		return nil
+	}
+	widgets := []*chat.GoogleAppsCardV1Widget{{
+		ButtonList: &chat.GoogleAppsCardV1ButtonList{Buttons: buttons},
+	}}
+	if len(spill) > 0 {
+		widgets = append(widgets, &chat.GoogleAppsCardV1Widget{
+			TextParagraph: &chat.GoogleAppsCardV1TextParagraph{Text: strings.Join(spill, "<br>")},
+		})
+	}
+	return []*chat.CardWithId{{
+		CardId: "earbug-tracks",
+		Card: &chat.GoogleAppsCardV1Card{
+			Sections: []*chat.GoogleAppsCardV1Section{{
+				Header:  "Top tracks",
+				Widgets: widgets,
+			}},
+		},
+	}}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// sweepCaches evicts cache entries older than earbug.cache.maxage,
+// and ids unknown to the resolver older than earbug.resolver.unknown.maxage,
+// checking every quarter of earbug.cache.maxage, until ctx is done.
+func (s *Server) sweepCaches(ctx context.Context) {
+	log := s.log.WithName("cache-sweep")
+	ticker := time.NewTicker(s.cacheMaxAge / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cutoff := now.Add(-s.cacheMaxAge)
+			n := s.allTimeTop.evict(cutoff)
+			resolved := s.resolved.evict(cutoff, now.Add(-s.resolverUnknown))
+			cfg := s.reloaded.Load()
+			metadata := cfg.metadata.evict(cutoff)
+			for _, t := range cfg.tenants {
+				if t.metadata.evict(cutoff) {
+					metadata = true
+				}
+			}
+			if n > 0 || resolved > 0 || metadata {
+				log.V(1).Info("evicted old cache entries", "all_time", n, "resolved", resolved, "metadata", metadata)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const postedPrefix = "posted/"
+
+// postedMarker records the last day summarized for a user,
+// to find days missed while the service was down.
+type postedMarker struct {
+	Date string `json:"date"`
+}
+
+func (s *Server) writePosted(ctx context.Context, user, date string) error {
+	return s.writeJSON(ctx, postedPrefix+user+".json", postedMarker{date})
+}
+
+// readPosted returns the last day summarized for user, empty if unknown.
+func (s *Server) readPosted(ctx context.Context, user string) (string, error) {
+	var m postedMarker
+	_, err := s.readJSON(ctx, postedPrefix+user+".json", &m)
+	return m.Date, err
+}
+
+// missedDays returns the days after last up to and including yesterday,
+// at most max of the most recent ones.
+func missedDays(last string, now time.Time, loc *time.Location, max int) []string {
+	var missed []string
+	for _, d := range lastDays(now, loc, max) {
+		if d > last {
+			missed = append(missed, d)
+		}
+	}
+	return missed
+}
+
+// catchUp posts summaries for days missed since each batch user
+// was last summarized, users never summarized are skipped.
+func (s *Server) catchUp(ctx context.Context) {
+	log := s.log.WithName("catchup")
+	ctx, span := s.trace.Start(ctx, "catchup")
+	defer span.End()
+
+	users, err := s.waitReady(ctx, log)
+	if err != nil {
+		log.Error(err, "bucket not ready, skipping catch up", "waited", s.catchUpWait)
+		return
+	}
+	opts, _ := s.summaryOptions(nil)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	start := time.Now()
+	today := s.clock().UTC().Format(dateLayout)
+	for _, u := range users {
+		log := log.WithValues("user", u.User)
+		if s.scheduleJitter > 0 {
+			delay := rng.Int63n(int64(s.scheduleJitter))
+			if s.jitterFixed {
+				delay = userJitter(u.User, today, s.scheduleJitter)
+			}
+			// delays are from the start, not cumulative
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(start.Add(time.Duration(delay)))):
+			}
+		}
+		err := s.catchUpUser(ctx, u, opts)
+		if err != nil {
+			log.Error(err, "catch up")
+			s.notifyFailure(ctx, u.User, "catch up")
+		}
+	}
+}
+
+// userJitter is a delay below max that's the same for a user on a day.
+func userJitter(user, day string, max time.Duration) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(user + "/" + day))
+	return int64(h.Sum64() % uint64(max))
+}
+
+// waitReady lists users, retrying with backoff for up to earbug.catchup.wait
+// while the bucket isn't accessible, e.g. while permissions propagate after a deploy.
+func (s *Server) waitReady(ctx context.Context, log logr.Logger) ([]manifestUser, error) {
+	deadline := time.Now().Add(s.catchUpWait)
+	backoff := 5 * time.Second
+	for {
+		users, err := s.listUsers(ctx)
+		if err == nil {
+			return users, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		log.Info("bucket not ready, waiting before catch up", "err", err.Error(), "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+func (s *Server) catchUpUser(ctx context.Context, u manifestUser, opts summaryOptions) error {
+	last, err := s.readPosted(ctx, u.User)
+	if err != nil {
+		return fmt.Errorf("read posted date: %w", err)
+	} else if last == "" {
+		return nil
+	}
+	loc, client, msg, _, err := s.userTarget(ctx, u, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	missed := missedDays(last, s.clock(), loc, s.catchUpDays)
+	if len(missed) == 0 {
+		return nil
+	}
+	s.log.Info("catching up on missed days", "user", u.User, "last", last, "days", missed)
+	data, err := s.readStore(ctx, u.User, &serverTiming{})
+	if err != nil {
+		return err
+	}
+	_, err = s.postDays(ctx, client, loc, missed, data, u.options(opts), &serverTiming{})
+	return err
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitReadyCanceled(t *testing.T) {
+	// the manifest never appears, so listing users keeps failing
+	s := newTestServer(t, &memStore{}, nil, map[string]string{
+		"earbug.posting.enabled": "false",
+		"earbug.manifest":        "manifest.json",
+		"earbug.catchup.wait":    "1h",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.waitReady(ctx, s.log)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("returned after %v, want soon after the context ended", waited)
+	}
+}
+
+func TestUserJitter(t *testing.T) {
+	max := 5 * time.Minute
+	a := userJitter("alice", "2024-03-14", max)
+	if a < 0 || a >= int64(max) {
+		t.Errorf("got %v, want below %v", time.Duration(a), max)
+	}
+	if again := userJitter("alice", "2024-03-14", max); again != a {
+		t.Errorf("got %v then %v for the same user and day", time.Duration(a), time.Duration(again))
+	}
+	if userJitter("bob", "2024-03-14", max) == a && userJitter("alice", "2024-03-15", max) == a {
+		t.Errorf("got %v for every user and day", time.Duration(a))
+	}
+}
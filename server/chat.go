@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"go.seankhliao.com/gchat"
+	chat "google.golang.org/api/chat/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	gchatModeWebhook = "webhook"
+	gchatModeAPI     = "api"
+)
+
+// Notifier posts rendered summaries to a chat space.
+type Notifier interface {
+	Post(ctx context.Context, msg gchat.WebhookPayload) error
+}
+
+// threadPoster is implemented by notifiers that can post into a named thread,
+// starting it if it doesn't exist.
+type threadPoster interface {
+	PostThread(ctx context.Context, msg gchat.WebhookPayload, threadKey string) error
+}
+
+// cardPoster is implemented by notifiers that can attach cards to a message,
+// posted into the named thread unless threadKey is empty.
+type cardPoster interface {
+	PostCards(ctx context.Context, msg gchat.WebhookPayload, cards []*chat.CardWithId, threadKey string) error
+}
+
+const replyOrNewThread = "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD"
+
+// sender is the display identity for posted messages.
+// Chat always shows the webhook's or app's own name,
+// so a set sender is shown as a card header on each message.
+type sender struct {
+	name   string
+	avatar string
+}
+
+// cards returns the header card for the sender, nil if unset.
+func (sn sender) cards() []*chat.CardWithId {
+	if sn.name == "" {
+		return nil
+	}
+	header := &chat.GoogleAppsCardV1CardHeader{
+		Title:    sn.name,
+		ImageUrl: sn.avatar,
+	}
+	if sn.avatar != "" {
+		header.ImageType = "CIRCLE"
+	}
+	return []*chat.CardWithId{{
+		CardId: "earbug-sender",
+		Card:   &chat.GoogleAppsCardV1Card{Header: header},
+	}}
+}
+
+// validSender checks the avatar is an absolute https url
+// and only set with a name.
+func validSender(sn sender) error {
+	if sn.avatar == "" {
+		return nil
+	}
+	if sn.name == "" {
+		return errors.New("earbug.gchat.avatar requires earbug.gchat.sendername")
+	}
+	u, err := url.Parse(sn.avatar)
+	if err != nil {
+		return fmt.Errorf("parse earbug.gchat.avatar: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("earbug.gchat.avatar must be an absolute https url")
+	}
+	return nil
+}
+
+// webhookClient is a gchat webhook client that can also post into threads.
+type webhookClient struct {
+	gchat.WebhookClient
+	sender sender
+}
+
+func (c *webhookClient) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	return c.post(ctx, c.Endpoint, msg, nil)
+}
+
+func (c *webhookClient) PostThread(ctx context.Context, msg gchat.WebhookPayload, threadKey string) error {
+	endpoint, err := c.threadEndpoint(threadKey)
+	if err != nil {
+		return err
+	}
+	return c.post(ctx, endpoint, msg, nil)
+}
+
+func (c *webhookClient) PostCards(ctx context.Context, msg gchat.WebhookPayload, cards []*chat.CardWithId, threadKey string) error {
+	endpoint := c.Endpoint
+	if threadKey != "" {
+		var err error
+		endpoint, err = c.threadEndpoint(threadKey)
+		if err != nil {
+			return err
+		}
+	}
+	return c.post(ctx, endpoint, msg, cards)
+}
+
+func (c *webhookClient) threadEndpoint(threadKey string) (string, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("threadKey", threadKey)
+	q.Set("messageReplyOption", replyOrNewThread)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// post sends msg to endpoint,
+// as a full chat message with the sender card and extra cards if any.
+func (c *webhookClient) post(ctx context.Context, endpoint string, msg gchat.WebhookPayload, extra []*chat.CardWithId) error {
+	cards := append(c.sender.cards(), extra...)
+	if len(cards) == 0 {
+		wc := c.WebhookClient
+		wc.Endpoint = endpoint
+		return wc.Post(ctx, msg)
+	}
+	b, err := json.Marshal(&chat.Message{
+		Text:    msg.Text,
+		CardsV2: cards,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json; charset=utf-8")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("post webhook: %s: %s", res.Status, body)
+	}
+	return nil
+}
+
+// chatAPIClient posts as a Chat app through the Chat REST API,
+// authenticated with the default service account credentials.
+type chatAPIClient struct {
+	svc    *chat.Service
+	space  string
+	sender sender
+}
+
+func newChatAPIClient(ctx context.Context, space string, sn sender) (*chatAPIClient, error) {
+	if space == "" {
+		return nil, errors.New("no space configured for api mode, set earbug.gchat.space")
+	}
+	svc, err := chat.NewService(ctx, option.WithScopes(chat.ChatBotScope))
+	if err != nil {
+		return nil, fmt.Errorf("create chat service: %w", err)
+	}
+	return &chatAPIClient{
+		svc:    svc,
+		space:  space,
+		sender: sn,
+	}, nil
+}
+
+func (c *chatAPIClient) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	_, err := c.svc.Spaces.Messages.Create(c.space, &chat.Message{
+		Text:    msg.Text,
+		CardsV2: c.sender.cards(),
+	}).Context(ctx).Do()
+	return err
+}
+
+func (c *chatAPIClient) PostThread(ctx context.Context, msg gchat.WebhookPayload, threadKey string) error {
+	_, err := c.svc.Spaces.Messages.Create(c.space, &chat.Message{
+		Text:    msg.Text,
+		CardsV2: c.sender.cards(),
+		Thread:  &chat.Thread{ThreadKey: threadKey},
+	}).MessageReplyOption(replyOrNewThread).Context(ctx).Do()
+	return err
+}
+
+func (c *chatAPIClient) PostCards(ctx context.Context, msg gchat.WebhookPayload, cards []*chat.CardWithId, threadKey string) error {
+	m := &chat.Message{
+		Text:    msg.Text,
+		CardsV2: append(c.sender.cards(), cards...),
+	}
+	call := c.svc.Spaces.Messages.Create(c.space, m)
+	if threadKey != "" {
+		m.Thread = &chat.Thread{ThreadKey: threadKey}
+		call = call.MessageReplyOption(replyOrNewThread)
+	}
+	_, err := call.Context(ctx).Do()
+	return err
+}
+
+// SetNotifier replaces the default notifier,
+// used when no per user or per request webhook applies.
+func (s *Server) SetNotifier(n Notifier) {
+	s.notifier = n
+}
+
+// notifyFailure posts a short notice of a failed summary
+// to the errors webhook, or the default space if unset.
+// Failures to post the notice are only logged, never notified.
+// Notices aren't written to the file sink,
+// and for tenants go to the tenant's webhook.
+func (s *Server) notifyFailure(ctx context.Context, user, stage string) {
+	if !s.postErrors {
+		return
+	}
+	endpoint := s.errorsWebhook
+	if tenantFrom(ctx) != nil {
+		endpoint = ""
+	}
+	client, err := s.chatNotifierFor(ctx, endpoint)
+	if err != nil || client == nil {
+		return
+	}
+	err = client.Post(ctx, gchat.WebhookPayload{
+		Text: s.decorate(fmt.Sprintf("⚠️ summary failed for %s: %s", user, stage)),
+	})
+	if err != nil {
+		s.log.Error(err, "post failure notice", "user", user, "stage", stage)
+	}
+}
+
+// decorate adds earbug.post.prefix and earbug.post.suffix to a message.
+func (s *Server) decorate(text string) string {
+	if s.postPrefix != "" {
+		text = s.postPrefix + " " + text
+	}
+	if s.postSuffix != "" {
+		text += "\n" + s.postSuffix
+	}
+	return text
+}
+
+// notifierFor returns a notifier posting to the webhook endpoint,
+// or the configured default when endpoint is empty.
+// It returns nil if posting is disabled.
+func (s *Server) notifierFor(ctx context.Context, endpoint string) (Notifier, error) {
+	n, err := s.chatNotifierFor(ctx, endpoint)
+	if err != nil || s.sink == nil {
+		return n, err
+	} else if n == nil {
+		return s.sink, nil
+	}
+	return teeNotifier{n, s.sink, s.log.WithName("sink")}, nil
+}
+
+// chatNotifierFor is notifierFor without the file sink.
+// A request's tenant replaces the configured default with its webhook.
+func (s *Server) chatNotifierFor(ctx context.Context, endpoint string) (Notifier, error) {
+	if !s.posting {
+		return nil, nil
+	}
+	if t := tenantFrom(ctx); t != nil && endpoint == "" {
+		if t.Webhook == "" {
+			return nil, fmt.Errorf("no webhook for user and none for tenant %s", t.Name)
+		}
+		endpoint = t.Webhook
+	}
+	if endpoint == "" && s.notifier != nil {
+		return s.notifier, nil
+	}
+	if endpoint == "" && s.gchatMode == gchatModeAPI {
+		return s.chatAPI, nil
+	}
+	if endpoint == "" {
+		endpoint = s.gchat.Endpoint
+	}
+	if endpoint == "" {
+		return nil, errors.New("no webhook for user and no earbug.gchat default")
+	}
+	return &webhookClient{
+		WebhookClient: gchat.WebhookClient{
+			Client:   s.gchat.Client,
+			Endpoint: endpoint,
+		},
+		sender: s.sender,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// reportConfig checks the result of setup, bucket access,
+// and optionally webhook reachability, writing a report to w.
+// It returns the process exit code.
+func (s *Server) reportConfig(ctx context.Context, w io.Writer, setupErr error) int {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	type check struct {
+		name string
+		run  func() (string, error)
+	}
+	checks := []check{
+		{"config", func() (string, error) { return "", setupErr }},
+		{"bucket " + s.bucket, func() (string, error) {
+			if setupErr != nil {
+				return "", errors.New("skipped, config invalid")
+			}
+			store, err := s.objects(ctx)
+			if err != nil {
+				return "", err
+			}
+			names, err := store.List(ctx, storeSuffix)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%v store objects", len(names)), nil
+		}},
+	}
+	if s.checkConfigPing {
+		checks = append(checks, check{"webhook", func() (string, error) {
+			if setupErr != nil {
+				return "", errors.New("skipped, config invalid")
+			} else if s.gchat.Endpoint == "" {
+				return "", errors.New("skipped, no default webhook")
+			}
+			// any response means the endpoint is reachable,
+			// a HEAD doesn't post a message
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.gchat.Endpoint, nil)
+			if err != nil {
+				return "", err
+			}
+			res, err := s.gchat.Client.Do(req)
+			if err != nil {
+				return "", err
+			}
+			res.Body.Close()
+			return res.Status, nil
+		}})
+	}
+
+	code := 0
+	for _, c := range checks {
+		detail, err := c.run()
+		if err != nil {
+			code = 1
+			fmt.Fprintf(w, "FAIL %s: %v\n", c.name, err)
+			continue
+		}
+		if detail != "" {
+			detail = ": " + detail
+		}
+		fmt.Fprintf(w, "ok   %s%s\n", c.name, detail)
+	}
+	return code
+}
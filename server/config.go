@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.seankhliao.com/svcrunner/envflag"
+)
+
+// flagRegistry registers flags with envflag, remembering each one
+// so earbug.config can set the ones not given on the command line or in the environment.
+type flagRegistry struct {
+	c    *envflag.Config
+	vars map[string]flagVar
+	// args and lookupEnv are where envflag reads flags from
+	args      []string
+	lookupEnv func(string) (string, bool)
+}
+
+type flagVar struct {
+	set func(string) error
+}
+
+// wrap returns a registry adding flags to c.
+// A nil c only sets the flags to their defaults, as tests do.
+func (f *flagRegistry) wrap(c *envflag.Config) *flagRegistry {
+	f.c = c
+	f.vars = make(map[string]flagVar)
+	f.args = os.Args[1:]
+	f.lookupEnv = os.LookupEnv
+	return f
+}
+
+// explicit reports whether name was set as a -name or --name argument,
+// or as its environment variable, e.g. EARBUG_BUCKET for earbug.bucket.
+func (f *flagRegistry) explicit(name string) bool {
+	for _, arg := range f.args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			// a flag's value, or an argument
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	_, ok := f.lookupEnv(strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name)))
+	return ok
+}
+
+func (f *flagRegistry) StringVar(p *string, name, value, usage string) {
+	*p = value
+	if f.c != nil {
+		f.c.StringVar(p, name, value, usage)
+	}
+	f.vars[name] = flagVar{
+		set: func(v string) error { *p = v; return nil },
+	}
+}
+
+func (f *flagRegistry) BoolVar(p *bool, name string, value bool, usage string) {
+	*p = value
+	if f.c != nil {
+		f.c.BoolVar(p, name, value, usage)
+	}
+	f.vars[name] = flagVar{
+		set: func(v string) (err error) { *p, err = strconv.ParseBool(v); return err },
+	}
+}
+
+func (f *flagRegistry) IntVar(p *int, name string, value int, usage string) {
+	*p = value
+	if f.c != nil {
+		f.c.IntVar(p, name, value, usage)
+	}
+	f.vars[name] = flagVar{
+		set: func(v string) (err error) { *p, err = strconv.Atoi(v); return err },
+	}
+}
+
+func (f *flagRegistry) Float64Var(p *float64, name string, value float64, usage string) {
+	*p = value
+	if f.c != nil {
+		f.c.Float64Var(p, name, value, usage)
+	}
+	f.vars[name] = flagVar{
+		set: func(v string) (err error) { *p, err = strconv.ParseFloat(v, 64); return err },
+	}
+}
+
+func (f *flagRegistry) DurationVar(p *time.Duration, name string, value time.Duration, usage string) {
+	*p = value
+	if f.c != nil {
+		f.c.DurationVar(p, name, value, usage)
+	}
+	f.vars[name] = flagVar{
+		set: func(v string) (err error) { *p, err = time.ParseDuration(v); return err },
+	}
+}
+
+// fileConfig is the content of earbug.config.
+type fileConfig struct {
+	// flags by name, from dotted keys or nested objects under "earbug"
+	flags map[string]string
+	// summary holds default query parameters for summary options
+	summary url.Values
+	// unknown keys, sorted
+	unknown []string
+}
+
+// parseConfig reads a json object of flags, e.g.
+//
+//	{
+//	  "earbug": {"numbers": "period", "cache": {"maxage": "1h"}},
+//	  "earbug.sections.separator": "line",
+//	  "summary": {"format": "markdown", "fields": ["plays", "top"], "postIfPlaysGte": 10}
+//	}
+//
+// Arrays are joined with commas, as comma separated flags and parameters take them.
+func parseConfig(b []byte, known map[string]flagVar) (fileConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw map[string]any
+	err := dec.Decode(&raw)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("decode: %w", err)
+	}
+	cfg := fileConfig{
+		flags:   make(map[string]string),
+		summary: make(url.Values),
+	}
+	for key, v := range raw {
+		switch {
+		case key == "summary":
+			params, ok := v.(map[string]any)
+			if !ok {
+				return fileConfig{}, errors.New("summary: expected an object of query parameters")
+			}
+			for name, v := range params {
+				s, err := configValue(v)
+				if err != nil {
+					return fileConfig{}, fmt.Errorf("summary.%s: %w", name, err)
+				}
+				if _, ok := summaryParams[name]; !ok && !isThresholdParam(name) {
+					cfg.unknown = append(cfg.unknown, "summary."+name)
+					continue
+				}
+				cfg.summary.Set(name, s)
+			}
+		case key == "earbug" || strings.HasPrefix(key, "earbug."):
+			err := flattenConfig(key, v, cfg.flags)
+			if err != nil {
+				return fileConfig{}, err
+			}
+		default:
+			cfg.unknown = append(cfg.unknown, key)
+		}
+	}
+	for name := range cfg.flags {
+		if _, ok := known[name]; !ok || name == "earbug.config" || name == "earbug.config.strict" {
+			cfg.unknown = append(cfg.unknown, name)
+			delete(cfg.flags, name)
+		}
+	}
+	sort.Strings(cfg.unknown)
+
+	// fail on bad values at load, not on the first request
+	_, err = parseSummaryOptions(cfg.summary)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("summary: %w", err)
+	}
+	return cfg, nil
+}
+
+// flattenConfig adds the values under key to flags by their dotted names.
+func flattenConfig(key string, v any, flags map[string]string) error {
+	if obj, ok := v.(map[string]any); ok {
+		for k, v := range obj {
+			err := flattenConfig(key+"."+k, v, flags)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := configValue(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if _, ok := flags[key]; ok {
+		return fmt.Errorf("%s: set more than once", key)
+	}
+	flags[key] = s
+	return nil
+}
+
+// configValue is the flag or query parameter form of a json value.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			if _, ok := e.([]any); ok {
+				return "", errors.New("nested arrays not supported")
+			}
+			s, err := configValue(e)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case nil:
+		return "", errors.New("null not supported, leave the key out")
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// summaryParams are the query parameters parseSummaryOptions reads,
+// besides the postIf thresholds.
+var summaryParams = map[string]struct{}{
+	"artistSep": {}, "artists": {}, "asOf": {}, "attachJSON": {}, "barChar": {},
+	"barWidth": {}, "buttons": {}, "channel": {}, "compare": {}, "context": {},
+	"days": {}, "durationPrecision": {}, "durationUnit": {}, "emptyStatus": {},
+	"excludeToday": {}, "explain": {}, "fields": {}, "format": {}, "glanceDate": {},
+	"groupBy": {}, "includeHash": {}, "includeNewTracks": {}, "lang": {}, "lastN": {},
+	"maxChars": {}, "maxList": {}, "minPlays": {}, "overwrite": {}, "perDay": {}, "persist": {},
+	"priorRank": {}, "rankBy": {}, "requireFresh": {}, "schema": {}, "threadedDaily": {}, "thresholdMode": {},
+	"tz": {}, "warnings": {},
+}
+
+func isThresholdParam(name string) bool {
+	if !strings.HasPrefix(name, "postIf") || !strings.HasSuffix(name, "Gte") {
+		return false
+	}
+	_, ok := thresholdMetrics[strings.TrimSuffix(strings.TrimPrefix(name, "postIf"), "Gte")]
+	return ok
+}
+
+// readConfig reads earbug.config.
+// Unknown keys fail with earbug.config.strict, and are logged otherwise.
+func (s *Server) readConfig() (fileConfig, error) {
+	b, err := os.ReadFile(s.configFile)
+	if err != nil {
+		return fileConfig{}, err
+	}
+	cfg, err := parseConfig(b, s.flags.vars)
+	if err != nil {
+		return fileConfig{}, err
+	}
+	if len(cfg.unknown) > 0 {
+		if s.configStrict {
+			return fileConfig{}, fmt.Errorf("unknown keys %s", strings.Join(cfg.unknown, ", "))
+		}
+		s.log.Info("ignoring unknown keys in earbug.config", "keys", cfg.unknown)
+	}
+	return cfg, nil
+}
+
+// loadConfig applies earbug.config: flags not given as arguments
+// or in the environment take its values, the others keep theirs.
+// It returns the default summary parameters.
+func (s *Server) loadConfig() (url.Values, error) {
+	cfg, err := s.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(cfg.flags))
+	for name := range cfg.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var applied int
+	for _, name := range names {
+		if s.flags.explicit(name) {
+			// even when set to its default
+			continue
+		}
+		err := s.flags.vars[name].set(cfg.flags[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		applied++
+	}
+	s.log.Info("loaded config", "path", s.configFile, "flags", applied, "overridden", len(names)-applied, "summary_params", len(cfg.summary))
+	return cfg.summary, nil
+}
+
+// withDefaults fills query parameters missing from q from earbug.config.
+func (s *Server) withDefaults(q url.Values) url.Values {
+	defaults := s.reloaded.Load().summaryDefaults
+	if len(defaults) == 0 {
+		return q
+	}
+	merged := make(url.Values, len(q)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range q {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFlagExplicit(t *testing.T) {
+	f := &flagRegistry{
+		args: []string{"-earbug.bucket", "b", "--earbug.timezone=UTC", "-earbug.posting.enabled", "--", "-earbug.manifest"},
+		lookupEnv: func(name string) (string, bool) {
+			return "", name == "EARBUG_SESSION_GAP" || name == "EARBUG_CHECKCONFIG_PING"
+		},
+	}
+	for name, want := range map[string]bool{
+		"earbug.bucket":           true,
+		"earbug.timezone":         true,
+		"earbug.posting.enabled":  true,
+		"earbug.session.gap":      true,
+		"earbug.checkconfig.ping": true,
+		"earbug.posting":          false,
+		"earbug.manifest":         false,
+		"earbug.sink.file":        false,
+	} {
+		if got := f.explicit(name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigExplicit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"earbug": {"session.gap": "1h", "binge.mintracks": 6, "skip.threshold": 0.8}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, &memStore{}, nil, map[string]string{"earbug.posting.enabled": "false"})
+	s.configFile = path
+	// set to their defaults, but set
+	s.flags.args = []string{"-earbug.session.gap=20m"}
+	s.flags.lookupEnv = func(name string) (string, bool) {
+		return "0.5", name == "EARBUG_SKIP_THRESHOLD"
+	}
+	_, err = s.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.sessionGap != 20*time.Minute || s.skipThreshold != 0.5 || s.bingeTracks != 6 {
+		t.Errorf("got session gap %v, skip threshold %v, binge tracks %v, want 20m, 0.5, 6", s.sessionGap, s.skipThreshold, s.bingeTracks)
+	}
+}
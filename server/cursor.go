@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const cursorPrefix = "cursors/"
+
+// channelCursor is the latest play summarized for a user in a channel,
+// for summaries of only what's new since the last post.
+type channelCursor struct {
+	After time.Time `json:"after"`
+}
+
+// generationWriter is implemented by object stores that can replace an object
+// only if it's still at a generation, 0 for one that doesn't exist yet,
+// failing on Close otherwise.
+type generationWriter interface {
+	NewGenerationWriter(ctx context.Context, name, contentType string, generation int64) io.WriteCloser
+}
+
+// errCursorMoved is returned when another run advanced a cursor first.
+var errCursorMoved = errors.New("cursor advanced by another run")
+
+// validChannel checks a channel name is safe in an object name.
+func validChannel(channel string) error {
+	if channel == "" || len(channel) > 64 {
+		return fmt.Errorf("channel %q must be 1-64 characters", channel)
+	}
+	for _, r := range channel {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("channel %q may only contain a-z, 0-9, - and _", channel)
+		}
+	}
+	return nil
+}
+
+func cursorName(channel, user string) string {
+	return cursorPrefix + channel + "/" + user + ".json"
+}
+
+// readCursor returns the cursor for user in channel and its object generation,
+// zero values if there is none.
+// It's read from the replica writeCursor writes to, generations differ across replicas.
+func (s *Server) readCursor(ctx context.Context, channel, user string) (time.Time, int64, error) {
+	store, err := s.objects(ctx)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	or, err := writable(store).NewReader(ctx, cursorName(channel, user))
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return time.Time{}, 0, nil
+	} else if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer or.Close()
+	var c channelCursor
+	err = json.NewDecoder(or).Decode(&c)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("decode cursor: %w", err)
+	}
+	var gen int64
+	if gr, ok := or.(generationReader); ok {
+		gen = gr.Generation()
+	}
+	return c.After, gen, nil
+}
+
+// writeCursor moves the cursor for user in channel to after,
+// failing with errCursorMoved if it's no longer at generation.
+// A negative generation, or a store without conditional writes,
+// replaces it unconditionally.
+func (s *Server) writeCursor(ctx context.Context, channel, user string, after time.Time, generation int64) error {
+	b, err := json.Marshal(channelCursor{after})
+	if err != nil {
+		return err
+	}
+	store, err := s.objects(ctx)
+	if err != nil {
+		return err
+	}
+	name := cursorName(channel, user)
+	var ow io.WriteCloser
+	if gw, ok := store.(generationWriter); ok && generation >= 0 {
+		ow = gw.NewGenerationWriter(ctx, name, "application/json", generation)
+	} else {
+		ow = store.NewWriter(ctx, name, "application/json")
+	}
+	_, err = ow.Write(b)
+	if cerr := ow.Close(); err == nil {
+		err = cerr
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return errCursorMoved
+	}
+	return err
+}
+
+// cursorClaim is a channel's cursor as read for a summary.
+type cursorClaim struct {
+	channel    string
+	generation int64
+}
+
+// claimCursor moves the cursor in c for user to the latest play in data summarized in win,
+// before the summary posts, so a concurrent run fails its claim
+// instead of posting the same plays.
+// The returned release moves it back to the start of win for a post that failed.
+// A nil c claims nothing.
+func (s *Server) claimCursor(ctx context.Context, c *cursorClaim, user string, data *loadedStore, win window) (func(), string, int, error) {
+	noop := func() {}
+	if c == nil {
+		return noop, "", 0, nil
+	}
+	if !data.latest.After(win.after) {
+		return noop, "", 0, nil
+	}
+	err := s.writeCursor(ctx, c.channel, user, data.latest, c.generation)
+	if errors.Is(err, errCursorMoved) {
+		return noop, "cursor claimed by a concurrent run", http.StatusConflict, err
+	} else if err != nil {
+		return noop, "advance cursor", http.StatusInternalServerError, err
+	}
+	release := func() {
+		// unconditional, a run claiming after this one would have posted plays from here on
+		err := s.writeCursor(ctx, c.channel, user, win.after, -1)
+		if err != nil {
+			s.log.Error(err, "release cursor", "channel", c.channel, "user", user)
+		}
+	}
+	return release, "", 0, nil
+}
+
+// cursorWindow covers the plays after the cursor up to now,
+// or the last day if there's no cursor yet.
+func cursorWindow(after, now time.Time, loc *time.Location) window {
+	if after.IsZero() {
+		after = now.Add(-24 * time.Hour)
+	}
+	return window{
+		label:   "since " + after.In(loc).Format("2006-01-02 15:04"),
+		from:    after.In(loc).Format(dateLayout),
+		to:      now.In(loc).Format(dateLayout),
+		after:   after,
+		partial: true,
+	}
+}
+
+// cursor shows (GET) or resets (DELETE) the cursor of a user in a ?channel=.
+func (s *Server) cursor(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("cursor")
+	ctx, span := s.trace.Start(r.Context(), "cursor")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	channel := r.URL.Query().Get("channel")
+	log = log.WithValues("user", user, "channel", channel)
+	if err := validChannel(channel); err != nil {
+		msg := "invalid channel"
+		http.Error(rw, msg, http.StatusBadRequest)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		after, _, err := s.readCursor(ctx, channel, user)
+		if err != nil {
+			msg := "read cursor"
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(channelCursor{after})
+	case http.MethodDelete:
+		// unconditional, a reset wins over runs in flight
+		err := s.writeCursor(ctx, channel, user, time.Time{}, -1)
+		if err != nil {
+			msg := "reset cursor"
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		log.Info("reset cursor", "ctx", ctx, "http_request", r)
+	default:
+		msg := "invalid method"
+		http.Error(rw, msg, http.StatusMethodNotAllowed)
+		log.Error(errors.New("GET or DELETE only"), msg, "ctx", ctx, "http_request", r)
+	}
+}
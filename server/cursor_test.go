@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.seankhliao.com/gchat"
+)
+
+// cursorPeek is a Notifier noting where a cursor is when a summary posts.
+type cursorPeek struct {
+	postRecorder
+	s       *Server
+	channel string
+	// at is the cursor at the last post
+	at time.Time
+}
+
+func (c *cursorPeek) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	c.at, _, _ = c.s.readCursor(ctx, c.channel, "alice")
+	return c.postRecorder.Post(ctx, msg)
+}
+
+func TestCursorClaimedBeforePost(t *testing.T) {
+	latest := time.Date(2024, time.March, 14, 13, 0, 0, 0, time.UTC)
+	store := &genStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &cursorPeek{channel: "c"}
+	s := newTestServer(t, store, n, nil)
+	n.s = s
+
+	rw := serve(s, http.MethodPost, "/summary?user=alice&channel=c", "", nil)
+	if rw.Code != http.StatusOK || len(n.texts()) != 1 {
+		t.Fatalf("got %d %q, %d posts, want 200 and a post", rw.Code, rw.Body.String(), len(n.texts()))
+	}
+	if !n.at.Equal(latest) {
+		t.Errorf("cursor at %v while posting, want %v", n.at, latest)
+	}
+
+	// a concurrent run read the cursor before this one claimed it
+	opts, _ := s.summaryOptions(nil)
+	opts.channel = "c"
+	opts.cursor = &cursorClaim{"c", 0}
+	store.putStore(t, "alice", testStore(map[string][]string{"t1": {"2024-03-15T08:00:00Z"}}))
+	data, err := s.readStore(context.Background(), "alice", &serverTiming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, code, err := s.postSummary(context.Background(), n, time.UTC, cursorWindow(time.Time{}, testNow, time.UTC), data, opts, &serverTiming{})
+	if code != http.StatusConflict || !errors.Is(err, errCursorMoved) || len(n.texts()) != 1 {
+		t.Errorf("stale claim: got %d %v, %d posts, want 409 without a post", code, err, len(n.texts()))
+	}
+}
+
+func TestCursorReleasedOnFailedPost(t *testing.T) {
+	store := &genStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &postRecorder{err: errors.New("webhook down")}
+	s := newTestServer(t, store, n, nil)
+
+	rw := serve(s, http.MethodPost, "/summary?user=alice&channel=c", "", nil)
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %q, want 500", rw.Code, rw.Body.String())
+	}
+	// back to the start of the window, an unset cursor covers the last day
+	after, _, err := s.readCursor(context.Background(), "c", "alice")
+	if want := testNow.Add(-24 * time.Hour); err != nil || !after.Equal(want) {
+		t.Errorf("cursor at %v, %v, want %v", after, err, want)
+	}
+}
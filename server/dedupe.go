@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// recentPosts remembers the content of the latest post for each user and date,
+// to suppress identical posts from overlapping triggers.
+type recentPosts struct {
+	mu    sync.Mutex
+	posts map[string]recentPost
+}
+
+type recentPost struct {
+	hash [sha256.Size]byte
+	at   time.Time
+}
+
+// claim records a post of text for key at now, returning false
+// if the prior post for key had the same text and was within window.
+func (p *recentPosts) claim(key, text string, now time.Time, window time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.posts == nil {
+		p.posts = make(map[string]recentPost)
+	}
+	for k, prior := range p.posts {
+		if now.Sub(prior.at) >= window {
+			delete(p.posts, k)
+		}
+	}
+	hash := sha256.Sum256([]byte(text))
+	if prior, ok := p.posts[key]; ok && prior.hash == hash {
+		return false
+	}
+	p.posts[key] = recentPost{hash, now}
+	return true
+}
+
+// release forgets a claim for a post that failed, so a retry isn't suppressed.
+func (p *recentPosts) release(key, text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if prior, ok := p.posts[key]; ok && prior.hash == sha256.Sum256([]byte(text)) {
+		delete(p.posts, key)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// dayDiff compares the summaries of two days, deltas are B minus A.
+type dayDiff struct {
+	A         string        `json:"a"`
+	B         string        `json:"b"`
+	Plays     int           `json:"plays"`
+	Tracks    int           `json:"tracks"`
+	NewTracks int           `json:"newTracks"`
+	Listened  time.Duration `json:"listened"`
+	// tracks and artists played on only one of the days, by name
+	OnlyA        []string `json:"onlyA,omitempty"`
+	OnlyB        []string `json:"onlyB,omitempty"`
+	ArtistsOnlyA []string `json:"artistsOnlyA,omitempty"`
+	ArtistsOnlyB []string `json:"artistsOnlyB,omitempty"`
+}
+
+// diff compares two days of a single user, given as ?a= and ?b= dates.
+func (s *Server) diff(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("diff")
+	ctx, span := s.trace.Start(r.Context(), "diff")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	for _, date := range []string{a, b} {
+		_, err := time.Parse(dateLayout, date)
+		if err != nil {
+			msg := "invalid options"
+			http.Error(rw, msg, optionsStatus(err))
+			log.Error(fmt.Errorf("parse date %q: %w", date, err), msg, "ctx", ctx, "http_request", r)
+			return
+		}
+	}
+	log = log.WithValues("a", a, "b", b)
+	opts, err := s.summaryOptions(r.URL.Query())
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	res := s.diffDays(data.Store, user, a, b)
+	if wantsJSON(r) {
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(res)
+	} else {
+		rw.Write([]byte(res.render(opts) + "\n"))
+	}
+	log.Info("served diff", "ctx", ctx, "http_request", r)
+}
+
+func (s *Server) diffDays(data *earbugv3.Store, user, a, b string) dayDiff {
+	cfg := s.summaryConfig(s.loc)
+	sumA := aggregate(data, user, dayWindow(a), cfg)
+	sumB := aggregate(data, user, dayWindow(b), cfg)
+	tracksA, artistsA := s.playedOn(data, a)
+	tracksB, artistsB := s.playedOn(data, b)
+	return dayDiff{
+		A:            a,
+		B:            b,
+		Plays:        sumB.Plays - sumA.Plays,
+		Tracks:       sumB.Tracks - sumA.Tracks,
+		NewTracks:    sumB.NewTracks - sumA.NewTracks,
+		Listened:     sumB.Listened - sumA.Listened,
+		OnlyA:        onlyIn(tracksA, tracksB),
+		OnlyB:        onlyIn(tracksB, tracksA),
+		ArtistsOnlyA: onlyIn(artistsA, artistsB),
+		ArtistsOnlyB: onlyIn(artistsB, artistsA),
+	}
+}
+
+// playedOn returns the names of tracks and artists played on date, by id.
+func (s *Server) playedOn(data *earbugv3.Store, date string) (tracks, artists map[string]string) {
+	tracks, artists = make(map[string]string), make(map[string]string)
+	counted := s.countedPlay(data)
+	for key, played := range data.Playbacks {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil || ts.In(s.loc).Format(dateLayout) != date || !counted(played) {
+			continue
+		}
+		tracks[played.TrackId] = trackName(data, played.TrackId)
+		for _, artist := range data.Tracks[played.TrackId].GetArtists() {
+			name := artist.Name
+			if name == "" {
+				name = artist.Id
+			}
+			artists[artist.Id] = name
+		}
+	}
+	return tracks, artists
+}
+
+// onlyIn returns the names in a with ids missing from b, in id order.
+func onlyIn(a, b map[string]string) []string {
+	var names []string
+	for _, id := range sortedKeys(a) {
+		if _, ok := b[id]; !ok {
+			names = append(names, a[id])
+		}
+	}
+	return names
+}
+
+func (d dayDiff) render(opts summaryOptions) string {
+	listened := "+" + formatDuration(d.Listened, opts.durationPrecision)
+	if d.Listened < 0 {
+		listened = "-" + formatDuration(-d.Listened, opts.durationPrecision)
+	}
+	parts := []string{
+		fmt.Sprintf(opts.label("diff"), d.B, d.A,
+			formatDelta(d.Plays, opts.thousands), formatDelta(d.Tracks, opts.thousands), formatDelta(d.NewTracks, opts.thousands), listened),
+	}
+	for _, only := range []struct {
+		label string
+		date  string
+		names []string
+	}{
+		{"diffOnly", d.A, d.OnlyA},
+		{"diffOnly", d.B, d.OnlyB},
+		{"diffArtistsOnly", d.A, d.ArtistsOnlyA},
+		{"diffArtistsOnly", d.B, d.ArtistsOnlyB},
+	} {
+		if len(only.names) > 0 {
+			parts = append(parts, fmt.Sprintf(opts.label(only.label), only.date, strings.Join(only.names, ", ")))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
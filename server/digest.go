@@ -0,0 +1,33 @@
+package server
+
+const schemaDigestV1 = "digest.v1"
+
+// DigestV1 is the ?schema=digest.v1 response, a minimal summary
+// for integrations that need a stable contract.
+//
+// Its fields, their json names, and their meaning are frozen:
+// they don't change as summaries gain features or options,
+// and no fields are added or removed. Anything more goes in a new
+// digest.v2 schema, with digest.v1 still served as it is.
+type DigestV1 struct {
+	// SchemaVersion is always digest.v1
+	SchemaVersion string `json:"schemaVersion"`
+	// Date labels the window, a date for single days
+	Date string `json:"date"`
+	// Plays counted in the window
+	Plays int `json:"plays"`
+	// UniqueTracks is the number of distinct tracks played
+	UniqueTracks int `json:"uniqueTracks"`
+	// NewTracks is the number of tracks played for the first time
+	NewTracks int `json:"newTracks"`
+}
+
+func digestV1(sum *Summary) DigestV1 {
+	return DigestV1{
+		SchemaVersion: schemaDigestV1,
+		Date:          sum.Date,
+		Plays:         sum.Plays,
+		UniqueTracks:  sum.Tracks,
+		NewTracks:     sum.NewTracks,
+	}
+}
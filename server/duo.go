@@ -0,0 +1,65 @@
+package server
+
+import (
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// Duo is a pair of artists heard together on tracks.
+type Duo struct {
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Plays int    `json:"plays"`
+}
+
+type artistPair struct {
+	a, b string
+}
+
+// topDuo returns the pair of artists appearing together on the most plays,
+// breaking ties by artist ids.
+// Only the first maxArtists artists of each track are paired.
+func topDuo(data *earbugv3.Store, plays []playback, maxArtists int) *Duo {
+	counts := make(map[artistPair]int)
+	names := make(map[string]string)
+	for _, p := range plays {
+		artists := data.Tracks[p.trackID].GetArtists()
+		if len(artists) > maxArtists {
+			artists = artists[:maxArtists]
+		}
+		for i, a := range artists {
+			names[a.Id] = a.Name
+			for _, b := range artists[i+1:] {
+				pair := artistPair{a.Id, b.Id}
+				if pair.a > pair.b {
+					pair.a, pair.b = pair.b, pair.a
+				}
+				if pair.a != pair.b {
+					counts[pair]++
+				}
+			}
+		}
+	}
+
+	var top artistPair
+	var topPlays int
+	for pair, n := range counts {
+		if n > topPlays || n == topPlays && (pair.a < top.a || pair.a == top.a && pair.b < top.b) {
+			top, topPlays = pair, n
+		}
+	}
+	if topPlays == 0 {
+		return nil
+	}
+	duo := &Duo{
+		A:     names[top.a],
+		B:     names[top.b],
+		Plays: topPlays,
+	}
+	if duo.A == "" {
+		duo.A = top.a
+	}
+	if duo.B == "" {
+		duo.B = top.b
+	}
+	return duo
+}
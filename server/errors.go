@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// readStage is the step of reading a store that failed,
+// worded to be shown as the response message.
+type readStage string
+
+const (
+	stageUnavailable readStage = "storage unavailable"
+	stageAbandoned   readStage = "store read abandoned"
+	stageBucket      readStage = "get bucket"
+	stageNotFound    readStage = "no data for user"
+	stageOpen        readStage = "create object reader"
+	stageRead        readStage = "read object"
+	stageTruncated   readStage = "truncated store object"
+	stageDecode      readStage = "unmarshal as proto"
+	stageMetadata    readStage = "read shared metadata"
+)
+
+// Sentinels for the failure kinds callers tell apart,
+// matched by a *readError of the corresponding stage with errors.Is.
+var (
+	errNotFound    = errors.New("no data for user")
+	errUnavailable = errors.New("storage unavailable")
+	errTruncated   = errors.New("truncated store object")
+)
+
+// readError is a failure to read a store,
+// with the status code it should be answered with.
+type readError struct {
+	stage readStage
+	code  int
+	err   error
+}
+
+func stageError(stage readStage, code int, err error) *readError {
+	return &readError{stage: stage, code: code, err: err}
+}
+
+func (e *readError) Error() string { return string(e.stage) + ": " + e.err.Error() }
+func (e *readError) Unwrap() error { return e.err }
+
+func (e *readError) Is(target error) bool {
+	switch target {
+	case errNotFound:
+		return e.stage == stageNotFound
+	case errUnavailable:
+		return e.stage == stageUnavailable
+	case errTruncated:
+		return e.stage == stageTruncated
+	}
+	return false
+}
+
+// stageStatus is the response message and status code for err.
+func stageStatus(err error) (string, int) {
+	var se *readError
+	if !errors.As(err, &se) {
+		return "internal error", http.StatusInternalServerError
+	}
+	return string(se.stage), se.code
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestReadStoreStageErrors(t *testing.T) {
+	store := &memStore{}
+	store.put("garbled"+storeSuffix, zstdBytes(t, []byte{0xff, 0xff, 0xff}))
+	store.put("broken"+storeSuffix, []byte("not zstd"))
+	s := newTestServer(t, store, &postRecorder{}, nil)
+	tests := []struct {
+		user  string
+		stage readStage
+		code  int
+		is    []error
+	}{
+		{"missing", stageNotFound, http.StatusNotFound, []error{errNotFound, storage.ErrObjectNotExist}},
+		{"garbled", stageDecode, http.StatusInternalServerError, nil},
+		{"broken", stageRead, http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			_, err := s.readStore(context.Background(), tt.user, &serverTiming{})
+			var se *readError
+			if !errors.As(err, &se) {
+				t.Fatalf("got %v, want a *readError", err)
+			}
+			if se.stage != tt.stage || se.code != tt.code {
+				t.Errorf("got stage %q code %d, want %q %d", se.stage, se.code, tt.stage, tt.code)
+			}
+			for _, target := range tt.is {
+				if !errors.Is(err, target) {
+					t.Errorf("errors.Is(%v, %v) = false", err, target)
+				}
+			}
+			if errors.Is(err, errUnavailable) || errors.Is(err, errTruncated) {
+				t.Errorf("%v matches another failure kind", err)
+			}
+			msg, code := stageStatus(err)
+			if msg != string(tt.stage) || code != tt.code {
+				t.Errorf("stageStatus = %q %d", msg, code)
+			}
+		})
+	}
+}
+
+func TestReadStoreBreakerOpen(t *testing.T) {
+	s := newTestServer(t, &memStore{}, &postRecorder{}, map[string]string{"earbug.breaker.failures": "1"})
+	s.breaker.done(errors.New("boom"), time.Now(), s.breakerFailures, s.breakerCooldown)
+	_, err := s.readStore(context.Background(), "user", &serverTiming{})
+	if !errors.Is(err, errUnavailable) {
+		t.Fatalf("got %v, want errUnavailable", err)
+	}
+	if _, code := stageStatus(err); code != http.StatusServiceUnavailable {
+		t.Errorf("got code %d", code)
+	}
+}
+
+func TestStageStatusOther(t *testing.T) {
+	msg, code := stageStatus(errors.New("boom"))
+	if msg != "internal error" || code != http.StatusInternalServerError {
+		t.Errorf("got %q %d", msg, code)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// exportMetadata streams the track metadata of a user's store as json,
+// {"tracks": [...], "artists": [...]}, tracks in their protojson form
+// by id, and the artists credited on them once each by id.
+// Playbacks are left to /history.
+func (s *Server) exportMetadata(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("export-metadata")
+	ctx, span := s.trace.Start(r.Context(), "export-metadata")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	ids := make([]string, 0, len(data.Tracks))
+	for id := range data.Tracks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	artists := make(map[string]*earbugv3.Artist)
+
+	rw.Header().Set("content-type", "application/json")
+	w := bufio.NewWriter(rw)
+	w.WriteString(`{"tracks":[`)
+	for i, id := range ids {
+		t := data.Tracks[id]
+		for _, a := range t.GetArtists() {
+			if _, ok := artists[a.Id]; !ok && a.Id != "" {
+				artists[a.Id] = a
+			}
+		}
+		b, err := protojson.Marshal(t)
+		if err != nil {
+			// the response has started, all that's left is to cut it short
+			log.Error(err, "marshal track", "track", id, "ctx", ctx, "http_request", r)
+			return
+		}
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(b)
+	}
+	w.WriteString(`],"artists":[`)
+	ids = ids[:0]
+	for id := range artists {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for i, id := range ids {
+		b, err := protojson.Marshal(artists[id])
+		if err != nil {
+			log.Error(err, "marshal artist", "artist", id, "ctx", ctx, "http_request", r)
+			return
+		}
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(b)
+	}
+	w.WriteString("]}\n")
+	err = w.Flush()
+	if err != nil {
+		log.Error(err, "write export", "ctx", ctx, "http_request", r)
+		return
+	}
+	log.Info("exported metadata", "tracks", len(data.Tracks), "artists", len(artists), "ctx", ctx, "http_request", r)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// glanceMetrics are the counts in a glance summary, in order.
+var glanceMetrics = []string{"plays", "tracks", "new"}
+
+const defaultGlanceEmoji = "plays=▶️,tracks=🎵,new=✨"
+
+// parseGlanceEmoji parses earbug.glance.emoji, metric=emoji pairs,
+// metrics left out keep their default.
+func parseGlanceEmoji(v string) (map[string]string, error) {
+	emoji := make(map[string]string)
+	for _, s := range []string{defaultGlanceEmoji, v} {
+		for _, pair := range strings.Split(s, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			metric, symbol, ok := strings.Cut(pair, "=")
+			if !ok || symbol == "" {
+				return nil, fmt.Errorf("glance emoji %q not in metric=emoji form", pair)
+			}
+			if _, known := emoji[metric]; !known && s != defaultGlanceEmoji {
+				return nil, fmt.Errorf("unknown glance metric %q, expected one of %s", metric, strings.Join(glanceMetrics, ", "))
+			}
+			emoji[metric] = symbol
+		}
+	}
+	return emoji, nil
+}
+
+// renderGlance renders sum as emoji prefixed counts, e.g. ▶️24 🎵18 ✨3,
+// optionally after the date.
+func renderGlance(sum *Summary, opts summaryOptions) string {
+	counts := map[string]int{
+		"plays":  sum.Plays,
+		"tracks": sum.Tracks,
+		"new":    sum.NewTracks,
+	}
+	var parts []string
+	if opts.glanceDate {
+		parts = append(parts, formatDate(sum.Date, opts.dateFormat))
+	}
+	for _, m := range glanceMetrics {
+		parts = append(parts, opts.glanceEmoji[m]+formatCount(counts[m], opts.thousands))
+	}
+	return strings.Join(parts, " ")
+}
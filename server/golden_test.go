@@ -0,0 +1,88 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+// tiedStore has tracks with equal play counts, first plays and durations,
+// so any ordering left to map iteration shows as differing output.
+func tiedStore() *earbugv3.Store {
+	data := &earbugv3.Store{
+		Playbacks: make(map[string]*earbugv3.Playback),
+		Tracks:    make(map[string]*earbugv3.Track),
+	}
+	artists := []string{"Ann", "Bob", "Cat", "Dee"}
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("t%d", i)
+		data.Tracks[id] = testTrack(id, "Track "+string(rune('A'+i)), 3*time.Minute, artists[i%4], artists[(i+1)%4])
+	}
+	start := time.Date(2024, time.February, 26, 8, 0, 0, 0, time.UTC)
+	for day := 0; day < 18; day++ {
+		for i := 0; i < 8; i++ {
+			if (day+i)%3 == 0 {
+				continue
+			}
+			ts := start.AddDate(0, 0, day).Add(time.Duration(i) * 10 * time.Minute)
+			data.Playbacks[ts.Format(time.RFC3339)] = &earbugv3.Playback{TrackId: fmt.Sprintf("t%d", i%8)}
+		}
+	}
+	return data
+}
+
+func TestSummaryGolden(t *testing.T) {
+	fields := "plays,tracks,time,days,bars,daytops,session,peakhour,skips,anomaly,record,achievements,onthisday,discovery,goal,consistency,busiest,milestone,binge,rising,duo,quarters,podcasts,top,alltime"
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"lastweek", "period=lastweek&fields=" + fields},
+		{"yesterday", "fields=" + fields + "&maxList=10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outs []string
+			for i := 0; i < 2; i++ {
+				// fresh servers, nothing cached between runs
+				store := &memStore{}
+				store.putStore(t, "alice", tiedStore())
+				s := newTestServer(t, store, nil, map[string]string{
+					"earbug.posting.enabled": "false",
+					"earbug.alltime.enabled": "true",
+				})
+				rw := serve(s, http.MethodPost, "/summary?user=alice&"+tt.query, "", nil)
+				if rw.Code != http.StatusOK {
+					t.Fatalf("got %d: %s", rw.Code, rw.Body)
+				}
+				outs = append(outs, rw.Body.String())
+			}
+			if outs[0] != outs[1] {
+				t.Fatalf("output differs between runs:\n%s\n---\n%s", outs[0], outs[1])
+			}
+
+			golden := filepath.Join("testdata", "summary-"+tt.name+".golden")
+			if *update {
+				err := os.WriteFile(golden, []byte(outs[0]), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outs[0] != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", outs[0], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+const groupTopN = 5
+
+// GroupCount is the number of plays sharing a metadata value.
+type GroupCount struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Plays int    `json:"plays"`
+}
+
+type groupKey struct {
+	key  string
+	name string
+}
+
+// grouper returns the groups a play belongs to,
+// none if the metadata isn't available.
+type grouper func(played *earbugv3.Playback, track *earbugv3.Track) []groupKey
+
+var groupers = map[string]grouper{
+	"track": func(played *earbugv3.Playback, track *earbugv3.Track) []groupKey {
+		name := track.GetName()
+		if name == "" {
+			name = played.TrackId
+		}
+		return []groupKey{{played.TrackId, name}}
+	},
+	"artist": func(played *earbugv3.Playback, track *earbugv3.Track) []groupKey {
+		var keys []groupKey
+		for _, a := range track.GetArtists() {
+			if a.Id == "" {
+				continue
+			}
+			name := a.Name
+			if name == "" {
+				name = a.Id
+			}
+			keys = append(keys, groupKey{a.Id, name})
+		}
+		return keys
+	},
+	"context": func(played *earbugv3.Playback, track *earbugv3.Track) []groupKey {
+		if played.ContextUri == "" {
+			return nil
+		}
+		return []groupKey{{played.ContextUri, played.ContextUri}}
+	},
+	"type": func(played *earbugv3.Playback, track *earbugv3.Track) []groupKey {
+		if track.GetType() == "" {
+			return nil
+		}
+		return []groupKey{{track.GetType(), track.GetType()}}
+	},
+}
+
+func validGroupBy(name string) error {
+	if _, ok := groupers[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("unsupported groupBy %q, expected one of %s", name, strings.Join(sortedKeys(groupers), ", "))
+}
+
+// groupCounts accumulates plays per group.
+type groupCounts struct {
+	plays map[string]int
+	names map[string]string
+}
+
+func (g *groupCounts) add(keys []groupKey) {
+	if g.plays == nil {
+		g.plays = make(map[string]int)
+		g.names = make(map[string]string)
+	}
+	for _, k := range keys {
+		g.plays[k.key]++
+		g.names[k.key] = k.name
+	}
+}
+
+// groupsWithPlays returns the ranked groups with at least min plays.
+func groupsWithPlays(ranked []GroupCount, min int) []GroupCount {
+	for i, g := range ranked {
+		if g.Plays < min {
+			return ranked[:i]
+		}
+	}
+	return ranked
+}
+
+// top ranks groups by plays, breaking ties by key, keeping at most n.
+func (g *groupCounts) top(n int) []GroupCount {
+	ranked := make([]GroupCount, 0, len(g.plays))
+	for key, plays := range g.plays {
+		ranked = append(ranked, GroupCount{
+			Key:   key,
+			Name:  g.names[key],
+			Plays: plays,
+		})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Plays != ranked[j].Plays {
+			return ranked[i].Plays > ranked[j].Plays
+		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+type trackHeatmap struct {
+	Track string `json:"track"`
+	Name  string `json:"name"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Plays int    `json:"plays"`
+	// Hours counts plays by weekday, earbug.weekstart first, and hour of day
+	Hours [7][24]int `json:"hours"`
+	Note  string     `json:"note,omitempty"`
+}
+
+// heatmap reports when a single track was played,
+// by weekday and hour over the days up to and including yesterday.
+func (s *Server) heatmap(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("heatmap")
+	ctx, span := s.trace.Start(r.Context(), "heatmap")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	track, days, err := func() (string, int, error) {
+		q := r.URL.Query()
+		track := q.Get("track")
+		if track == "" {
+			return "", 0, errors.New("no track provided")
+		}
+		days := 90
+		if v := q.Get("days"); v != "" {
+			var err error
+			days, err = strconv.Atoi(v)
+			if err != nil {
+				return "", 0, fmt.Errorf("parse days: %w", err)
+			}
+		}
+		if days < 1 || days > 366 {
+			return "", 0, invalidf("days %d out of range 1-366", days)
+		}
+		return track, days, nil
+	}()
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("track", track, "days", days)
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	dates := lastDays(s.clock(), s.loc, days)
+	res := trackHeatmap{
+		Track: track,
+		Name:  trackName(data.Store, track),
+		From:  dates[0],
+		To:    dates[len(dates)-1],
+	}
+	res.Hours, res.Plays = hourlyPlays(data.Store, s.loc, window{from: res.From, to: res.To}, track, s.weekStart)
+	if res.Plays == 0 {
+		res.Note = "no plays of this track in the window"
+	}
+
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(res)
+	log.Info("served heatmap", "plays", res.Plays, "ctx", ctx, "http_request", r)
+}
+
+// hourlyPlays counts plays of track within the window
+// by weekday starting with first, and hour of day in loc.
+func hourlyPlays(data *earbugv3.Store, loc *time.Location, win window, track string, first time.Weekday) ([7][24]int, int) {
+	var hours [7][24]int
+	var total int
+	for key, played := range data.Playbacks {
+		if played.TrackId != track {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			continue
+		}
+		ts = ts.In(loc)
+		if !win.contains(ts.Format(dateLayout)) {
+			continue
+		}
+		hours[weekdayIndex(ts.Weekday(), first)][ts.Hour()]++
+		total++
+	}
+	return hours, total
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+const (
+	historyLimit    = 50
+	historyMaxLimit = 500
+)
+
+type historyItem struct {
+	Time    time.Time `json:"time"`
+	TrackID string    `json:"trackId"`
+	Name    string    `json:"name"`
+	Artists []string  `json:"artists,omitempty"`
+	Context string    `json:"context,omitempty"`
+}
+
+type historyPage struct {
+	Items []historyItem `json:"items"`
+	// NextPageToken continues after the last item, empty on the last page
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// historyCursor orders plays by time, then by key for plays at the same time.
+type historyCursor struct {
+	ts  time.Time
+	key string
+}
+
+func (c historyCursor) before(o historyCursor) bool {
+	if !c.ts.Equal(o.ts) {
+		return c.ts.Before(o.ts)
+	}
+	return c.key < o.key
+}
+
+func (c historyCursor) token() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.ts.Format(time.RFC3339Nano) + " " + c.key))
+}
+
+func parsePageToken(token string) (historyCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("decode pageToken: %w", err)
+	}
+	ts, key, ok := strings.Cut(string(b), " ")
+	if !ok {
+		return historyCursor{}, errors.New("malformed pageToken")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("malformed pageToken: %w", err)
+	}
+	return historyCursor{t, key}, nil
+}
+
+// history returns a page of a user's plays, oldest first,
+// from ?from= (a date or RFC 3339 time, the first play if unset)
+// or continuing after ?pageToken=, up to ?limit= plays.
+func (s *Server) history(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("history")
+	ctx, span := s.trace.Start(r.Context(), "history")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	after, limit, err := func() (*historyCursor, int, error) {
+		q := r.URL.Query()
+		limit := historyLimit
+		if v := q.Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("parse limit: %w", err)
+			}
+			if limit < 1 || limit > historyMaxLimit {
+				return nil, 0, invalidf("limit %d out of range 1-%d", limit, historyMaxLimit)
+			}
+		}
+		if v := q.Get("pageToken"); v != "" {
+			c, err := parsePageToken(v)
+			return &c, limit, err
+		}
+		if v := q.Get("from"); v != "" {
+			from, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				from, err = time.ParseInLocation(dateLayout, v, s.loc)
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("parse from %q: expected a date or RFC 3339 time", v)
+			}
+			// just before from, so plays at from are included
+			return &historyCursor{ts: from.Add(-time.Nanosecond)}, limit, nil
+		}
+		return nil, limit, nil
+	}()
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	page := s.historyPage(data.Store, after, limit)
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(page)
+	log.V(1).Info("served history", "items", len(page.Items), "ctx", ctx, "http_request", r)
+}
+
+// historyPage returns up to limit counted plays after the cursor, all if nil.
+func (s *Server) historyPage(data *earbugv3.Store, after *historyCursor, limit int) historyPage {
+	counted := s.countedPlay(data)
+	var plays []historyCursor
+	for key, played := range data.Playbacks {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil || !counted(played) {
+			continue
+		}
+		c := historyCursor{ts, key}
+		if after != nil && !after.before(c) {
+			continue
+		}
+		plays = append(plays, c)
+	}
+	sort.Slice(plays, func(i, j int) bool {
+		return plays[i].before(plays[j])
+	})
+
+	page := historyPage{Items: []historyItem{}}
+	if len(plays) > limit {
+		plays = plays[:limit]
+		page.NextPageToken = plays[limit-1].token()
+	}
+	for _, c := range plays {
+		played := data.Playbacks[c.key]
+		item := historyItem{
+			Time:    c.ts,
+			TrackID: played.TrackId,
+			Name:    trackName(data, played.TrackId),
+			Context: played.ContextUri,
+		}
+		for _, a := range data.Tracks[played.TrackId].GetArtists() {
+			name := a.Name
+			if name == "" {
+				name = a.Id
+			}
+			item.Artists = append(item.Artists, name)
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// parseUserGroups parses earbug.groups,
+// comma separated name=user+user entries.
+func parseUserGroups(v string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, members, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || members == "" {
+			return nil, fmt.Errorf("group %q not in name=user+user form", entry)
+		} else if _, dup := groups[name]; dup {
+			return nil, fmt.Errorf("group %q defined twice", name)
+		}
+		for _, m := range strings.Split(members, "+") {
+			if m = strings.TrimSpace(m); m != "" {
+				groups[name] = append(groups[name], m)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// MemberShare is a group member's part of the group's plays.
+type MemberShare struct {
+	User  string `json:"user"`
+	Plays int    `json:"plays"`
+	// Percent of the group's plays, before removing shared plays
+	Percent int `json:"percent"`
+	// Missing is set if the member's store couldn't be read
+	Missing bool `json:"missing,omitempty"`
+}
+
+// summaryGroup posts a combined summary of yesterday
+// for the members of ?group=.
+func (s *Server) summaryGroup(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("summary-group")
+	ctx, span := s.trace.Start(r.Context(), "summary-group")
+	defer span.End()
+	timing := &serverTiming{}
+
+	if r.Method != http.MethodPost {
+		msg := "invalid method"
+		http.Error(rw, msg, http.StatusMethodNotAllowed)
+		log.Error(errors.New("POST only"), msg, "method", r.Method, "ctx", ctx, "http_request", r)
+		return
+	}
+	name := r.URL.Query().Get("group")
+	members, ok := s.userGroups[name]
+	if !ok {
+		msg := "unknown group"
+		http.Error(rw, msg, http.StatusNotFound)
+		log.Error(fmt.Errorf("group %q not in earbug.groups", name), msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("group", name)
+
+	opts, err := s.summaryOptions(r.URL.Query())
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	if opts.groupBy == "" {
+		opts.groupBy = "artist"
+	}
+	client, err := s.notifierFor(ctx, "")
+	if err != nil {
+		msg := "no webhook configured"
+		http.Error(rw, msg, http.StatusInternalServerError)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	win, _ := yesterday(r, s.now(opts), s.loc)
+	data := s.readGroup(ctx, name, members, win, timing)
+	sum, msg, code, err := s.postSummary(ctx, client, s.loc, win, data, opts, timing)
+	if sum != nil {
+		log = log.WithValues(sum.logValues()...)
+	}
+	s.setTiming(rw, timing)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	switch code {
+	case http.StatusNoContent:
+		rw.WriteHeader(code)
+		log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+		return
+	case http.StatusNotFound:
+		http.Error(rw, msg, code)
+		log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+		return
+	}
+	rw.Write([]byte(msg))
+	log.Info("posted group summary", "ctx", ctx, "http_request", r)
+}
+
+// readGroup reads the members' stores concurrently and merges them,
+// noting members whose store couldn't be read.
+// With earbug.groups.dedupe, a track played at the same instant
+// by several members counts once.
+func (s *Server) readGroup(ctx context.Context, name string, members []string, win window, timing *serverTiming) *loadedStore {
+	start := time.Now()
+	stores := make([]*loadedStore, len(members))
+	var wg sync.WaitGroup
+	for i, u := range members {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			data, err := s.readStore(ctx, u, &serverTiming{})
+			if err != nil {
+				s.log.Error(err, "read store", "group", name, "user", u)
+				return
+			}
+			stores[i] = data
+		}(i, u)
+	}
+	wg.Wait()
+	timing.add("read-group", start)
+
+	merged := &earbugv3.Store{
+		Playbacks: make(map[string]*earbugv3.Playback),
+		Tracks:    make(map[string]*earbugv3.Track),
+	}
+	shares := make([]MemberShare, len(members))
+	var total int
+	var latest time.Time
+	for i, data := range stores {
+		shares[i].User = members[i]
+		if data == nil {
+			shares[i].Missing = true
+			continue
+		}
+		if data.latest.After(latest) {
+			latest = data.latest
+		}
+		for _, n := range dailyPlays(data.Store, s.loc, win.days(), s.countedPlay(data.Store)) {
+			shares[i].Plays += n
+		}
+		total += shares[i].Plays
+		for id, track := range data.Tracks {
+			if _, ok := merged.Tracks[id]; !ok {
+				merged.Tracks[id] = track
+			}
+		}
+		for key, played := range data.Playbacks {
+			mergePlayback(merged.Playbacks, key, played, s.groupDedupe)
+		}
+	}
+	for i := range shares {
+		if total > 0 {
+			shares[i].Percent = shares[i].Plays * 100 / total
+		}
+	}
+	return &loadedStore{
+		Store:   merged,
+		user:    "group:" + name,
+		members: shares,
+		latest:  latest,
+	}
+}
+
+// countedPlay matches the plays counted as music in summaries.
+func (s *Server) countedPlay(data *earbugv3.Store) func(*earbugv3.Playback) bool {
+	return func(played *earbugv3.Playback) bool {
+		podcast, _ := isPodcast(played, data.Tracks[played.TrackId])
+		return !podcast || s.podcasts == podcastsInclude
+	}
+}
+
+// mergePlayback adds played to playbacks under key.
+// Colliding keys from other members are moved a nanosecond later
+// until free, unless dedupe drops plays of the same track.
+func mergePlayback(playbacks map[string]*earbugv3.Playback, key string, played *earbugv3.Playback, dedupe bool) {
+	ts, err := time.Parse(time.RFC3339, key)
+	for {
+		prior, ok := playbacks[key]
+		if !ok {
+			playbacks[key] = proto.Clone(played).(*earbugv3.Playback)
+			return
+		} else if err != nil || dedupe && prior.TrackId == played.TrackId {
+			return
+		}
+		ts = ts.Add(time.Nanosecond)
+		key = ts.Format(time.RFC3339Nano)
+	}
+}
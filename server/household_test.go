@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+func TestMergePlayback(t *testing.T) {
+	// both members played t1 at 08:00, at 09:00 they played different tracks
+	members := []map[string]*earbugv3.Playback{{
+		"2024-03-14T08:00:00Z": {TrackId: "t1"},
+		"2024-03-14T09:00:00Z": {TrackId: "t1"},
+	}, {
+		"2024-03-14T08:00:00Z": {TrackId: "t1"},
+		"2024-03-14T09:00:00Z": {TrackId: "t2"},
+		"2024-03-14T10:00:00Z": {TrackId: "t3"},
+	}}
+	tests := []struct {
+		dedupe bool
+		want   []string
+	}{{
+		dedupe: true,
+		want: []string{
+			"2024-03-14T08:00:00Z t1",
+			"2024-03-14T09:00:00.000000001Z t2",
+			"2024-03-14T09:00:00Z t1",
+			"2024-03-14T10:00:00Z t3",
+		},
+	}, {
+		dedupe: false,
+		want: []string{
+			"2024-03-14T08:00:00.000000001Z t1",
+			"2024-03-14T08:00:00Z t1",
+			"2024-03-14T09:00:00.000000001Z t2",
+			"2024-03-14T09:00:00Z t1",
+			"2024-03-14T10:00:00Z t3",
+		},
+	}}
+	for _, tt := range tests {
+		merged := make(map[string]*earbugv3.Playback)
+		for _, playbacks := range members {
+			for key, played := range playbacks {
+				mergePlayback(merged, key, played, tt.dedupe)
+			}
+		}
+		var got []string
+		for key, played := range merged {
+			got = append(got, key+" "+played.TrackId)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("dedupe %v: got\n%s\nwant\n%s", tt.dedupe, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+
+	// merging copies, later members don't change earlier ones' plays
+	for _, played := range members[0] {
+		if played.TrackId != "t1" {
+			t.Errorf("member store modified: %v", played)
+		}
+	}
+}
+
+func TestReadGroupOverlapping(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	// bob listened along to the first two of alice's plays, then alone
+	store.putStore(t, "bob", testStore(map[string][]string{
+		"t1": {"2024-03-14T08:00:00Z", "2024-03-14T09:00:00Z"},
+		"t2": {"2024-03-14T20:00:00Z"},
+	}))
+	for _, tt := range []struct {
+		dedupe string
+		plays  int
+	}{
+		{"true", 8},
+		{"false", 10},
+	} {
+		s := newTestServer(t, store, nil, map[string]string{
+			"earbug.posting.enabled": "false",
+			"earbug.groups.dedupe":   tt.dedupe,
+		})
+		win := dayWindow("2024-03-14")
+		data := s.readGroup(context.Background(), "home", []string{"alice", "bob"}, win, &serverTiming{})
+		// all plays merge, alice's on 2024-03-13 included
+		if got := len(data.Playbacks); got != tt.plays {
+			t.Errorf("dedupe %s: got %d plays, want %d", tt.dedupe, got, tt.plays)
+		}
+		if data.members[0].Plays != 6 || data.members[1].Plays != 3 {
+			t.Errorf("dedupe %s: got member plays %+v, want 6 and 3", tt.dedupe, data.members)
+		}
+		if want := time.Date(2024, time.March, 14, 20, 0, 0, 0, time.UTC); !data.latest.Equal(want) {
+			t.Errorf("dedupe %s: latest play %v, want %v", tt.dedupe, data.latest, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// english holds the format strings for labels in text summaries,
+// the fallback for keys missing from a translation.
+var english = map[string]string{
+	"plays":             "%s plays",
+	"vsPrior":           " (%s vs prior day)",
+	"tracks":            "%s tracks (%s%s new, %s one-offs)",
+	"listened":          "%s listened",
+	"albums":            "≈ %s albums",
+	"session":           "longest session %s (%s tracks from %s)",
+	"peakhour":          "peak hour %02d:00 (%v%% of plays)",
+	"skips":             "skip rate %v%%",
+	"record":            "🏆 new record: %s plays (previous best %s on %s)",
+	"onthisday":         "on this day %s: %s",
+	"onthisdayYear":     "in %s",
+	"onthisdayLastYear": "last year",
+	"discovery":         "first discovery at %s: %s",
+	"goal":              "%s/%s new tracks this week (%v%%)",
+	"consistency":       "avg %s/day",
+	"streak":            "%s day streak",
+	"busiest":           "busiest day: %s (avg %s plays)",
+	"sunday":            "Sunday",
+	"monday":            "Monday",
+	"tuesday":           "Tuesday",
+	"wednesday":         "Wednesday",
+	"thursday":          "Thursday",
+	"friday":            "Friday",
+	"saturday":          "Saturday",
+	"sundayShort":       "Sun",
+	"mondayShort":       "Mon",
+	"tuesdayShort":      "Tue",
+	"wednesdayShort":    "Wed",
+	"thursdayShort":     "Thu",
+	"fridayShort":       "Fri",
+	"saturdayShort":     "Sat",
+	"anomaly":           "⚠️ %.1fx plays vs %s (%s)",
+	"milestone":         "~%s more plays to %s",
+	"milestonePace":     " (at current pace, ~%s days)",
+	"binge":             "album binge: %s",
+	"bingeAlbum":        "%s (%s tracks)",
+	"rising":            "rising: %s",
+	"risingTrack":       "%s (%s→%s weekly plays)",
+	"duo":               "most heard duo: %s × %s (%s plays)",
+	"quarters":          "top artist by quarter: %s",
+	"podcasts":          "podcasts %s plays, %s",
+	"top":               "top: %s",
+	"completion":        ", %s%% avg",
+	"topNone":           "top: no tracks with %v+ plays",
+	"alltime":           "all time: %s",
+	"alltimeNone":       "all time: no tracks with %v+ plays",
+	"members":           "members: %s",
+	"memberMissing":     "%s no data",
+	"groups":            "by %s: %s",
+	"groupsNone":        "by %s: none with %v+ plays",
+	"context":           "from %s",
+	"contextUnknown":    "no plays in this window recorded a context to filter on",
+	"warnMetadata":      "track metadata unavailable",
+	"warnTruncated":     "store object truncated, only its complete records summarized",
+	"warnCapped":        "only the latest %v playbacks summarized, %v older ones dropped",
+	"warnStale":         "no plays recorded since %s",
+	"warnMalformed":     "%v playbacks with malformed timestamps skipped",
+	"warnUnresolved":    "%v plays of tracks with no metadata",
+	"warnNoDuration":    "%v plays of tracks with no duration",
+	"warnUntyped":       "%v plays with no type counted as music",
+	"diff":              "%s vs %s: %s plays, %s tracks, %s new, %s listened",
+	"diffOnly":          "only on %s: %s",
+	"diffArtistsOnly":   "artists only on %s: %s",
+	"artist":            "%s: %s plays of %s tracks",
+	"artistNone":        "%s: no plays for this artist",
+	"artistTracks":      "tracks: %s",
+	"artistFirst":       "first time: %s",
+}
+
+// translations of english by ?lang= code.
+// Translated format strings keep the verbs of the english one in order.
+var translations = map[string]map[string]string{
+	"en": {},
+	"es": {
+		"plays":             "%s reproducciones",
+		"vsPrior":           " (%s vs el día anterior)",
+		"tracks":            "%s canciones (%s%s nuevas, %s escuchadas una vez)",
+		"listened":          "%s escuchado",
+		"albums":            "≈ %s álbumes",
+		"session":           "sesión más larga %s (%s canciones desde las %s)",
+		"peakhour":          "hora punta %02d:00 (%v%% de reproducciones)",
+		"skips":             "canciones saltadas %v%%",
+		"record":            "🏆 nuevo récord: %s reproducciones (mejor anterior %s el %s)",
+		"onthisday":         "tal día como hoy %s: %s",
+		"onthisdayYear":     "en %s",
+		"onthisdayLastYear": "el año pasado",
+		"discovery":         "primer descubrimiento a las %s: %s",
+		"goal":              "%s/%s canciones nuevas esta semana (%v%%)",
+		"consistency":       "media %s/día",
+		"streak":            "racha de %s días",
+		"busiest":           "día con más escuchas: %s (media %s reproducciones)",
+		"sunday":            "domingo",
+		"monday":            "lunes",
+		"tuesday":           "martes",
+		"wednesday":         "miércoles",
+		"thursday":          "jueves",
+		"friday":            "viernes",
+		"saturday":          "sábado",
+		"sundayShort":       "dom",
+		"mondayShort":       "lun",
+		"tuesdayShort":      "mar",
+		"wednesdayShort":    "mié",
+		"thursdayShort":     "jue",
+		"fridayShort":       "vie",
+		"saturdayShort":     "sáb",
+		"anomaly":           "⚠️ %.1fx reproducciones vs el %s (%s)",
+		"milestone":         "~%s reproducciones más para llegar a %s",
+		"milestonePace":     " (a este ritmo, ~%s días)",
+		"binge":             "álbum completo: %s",
+		"bingeAlbum":        "%s (%s canciones)",
+		"rising":            "en alza: %s",
+		"risingTrack":       "%s (%s→%s reproducciones semanales)",
+		"duo":               "dúo más escuchado: %s × %s (%s reproducciones)",
+		"quarters":          "artista principal por trimestre: %s",
+		"podcasts":          "podcasts %s reproducciones, %s",
+		"top":               "más escuchadas: %s",
+		"completion":        ", %s%% de media",
+		"topNone":           "más escuchadas: ninguna con %v+ reproducciones",
+		"alltime":           "de siempre: %s",
+		"alltimeNone":       "de siempre: ninguna con %v+ reproducciones",
+		"members":           "miembros: %s",
+		"memberMissing":     "%s sin datos",
+		"groups":            "por %s: %s",
+		"groupsNone":        "por %s: ninguno con %v+ reproducciones",
+		"context":           "de %s",
+		"contextUnknown":    "ninguna reproducción de este periodo registró un contexto por el que filtrar",
+		"warnMetadata":      "metadatos de canciones no disponibles",
+		"warnTruncated":     "objeto del almacén truncado, solo se resumen sus registros completos",
+		"warnCapped":        "solo se resumen las últimas %v reproducciones, se descartaron %v más antiguas",
+		"warnStale":         "sin reproducciones registradas desde %s",
+		"warnMalformed":     "%v reproducciones con marcas de tiempo mal formadas omitidas",
+		"warnUnresolved":    "%v reproducciones de canciones sin metadatos",
+		"warnNoDuration":    "%v reproducciones de canciones sin duración",
+		"warnUntyped":       "%v reproducciones sin tipo contadas como música",
+		"diff":              "%s vs %s: %s reproducciones, %s canciones, %s nuevas, %s escuchado",
+		"diffOnly":          "solo el %s: %s",
+		"diffArtistsOnly":   "artistas solo el %s: %s",
+		"artist":            "%s: %s reproducciones de %s canciones",
+		"artistNone":        "%s: ninguna reproducción de este artista",
+		"artistTracks":      "canciones: %s",
+		"artistFirst":       "primera vez: %s",
+	},
+	"de": {
+		"plays":             "%s Wiedergaben",
+		"vsPrior":           " (%s ggü. Vortag)",
+		"tracks":            "%s Titel (%s%s neu, %s einmalig)",
+		"listened":          "%s gehört",
+		"albums":            "≈ %s Alben",
+		"session":           "längste Session %s (%s Titel ab %s)",
+		"peakhour":          "Spitzenstunde %02d:00 (%v%% der Wiedergaben)",
+		"skips":             "Überspringrate %v%%",
+		"record":            "🏆 neuer Rekord: %s Wiedergaben (bisher %s am %s)",
+		"onthisday":         "an diesem Tag %s: %s",
+		"onthisdayYear":     "im Jahr %s",
+		"onthisdayLastYear": "letztes Jahr",
+		"discovery":         "erste Entdeckung um %s: %s",
+		"goal":              "%s/%s neue Titel diese Woche (%v%%)",
+		"consistency":       "Ø %s/Tag",
+		"streak":            "%s Tage in Folge",
+		"busiest":           "aktivster Tag: %s (Ø %s Wiedergaben)",
+		"sunday":            "Sonntag",
+		"monday":            "Montag",
+		"tuesday":           "Dienstag",
+		"wednesday":         "Mittwoch",
+		"thursday":          "Donnerstag",
+		"friday":            "Freitag",
+		"saturday":          "Samstag",
+		"sundayShort":       "So",
+		"mondayShort":       "Mo",
+		"tuesdayShort":      "Di",
+		"wednesdayShort":    "Mi",
+		"thursdayShort":     "Do",
+		"fridayShort":       "Fr",
+		"saturdayShort":     "Sa",
+		"anomaly":           "⚠️ %.1fx Wiedergaben ggü. %s (%s)",
+		"milestone":         "noch ~%s Wiedergaben bis %s",
+		"milestonePace":     " (bei diesem Tempo ~%s Tage)",
+		"binge":             "Album am Stück: %s",
+		"bingeAlbum":        "%s (%s Titel)",
+		"rising":            "im Kommen: %s",
+		"risingTrack":       "%s (%s→%s Wiedergaben pro Woche)",
+		"duo":               "meistgehörtes Duo: %s × %s (%s Wiedergaben)",
+		"quarters":          "Top-Künstler pro Quartal: %s",
+		"podcasts":          "Podcasts %s Wiedergaben, %s",
+		"top":               "Top: %s",
+		"completion":        ", Ø %s%%",
+		"topNone":           "Top: keine Titel mit %v+ Wiedergaben",
+		"alltime":           "aller Zeiten: %s",
+		"alltimeNone":       "aller Zeiten: keine Titel mit %v+ Wiedergaben",
+		"members":           "Mitglieder: %s",
+		"memberMissing":     "%s keine Daten",
+		"groups":            "nach %s: %s",
+		"groupsNone":        "nach %s: keine mit %v+ Wiedergaben",
+		"context":           "aus %s",
+		"contextUnknown":    "keine Wiedergabe in diesem Zeitraum hat einen Kontext zum Filtern",
+		"warnMetadata":      "Titel-Metadaten nicht verfügbar",
+		"warnTruncated":     "Speicherobjekt abgeschnitten, nur vollständige Einträge zusammengefasst",
+		"warnCapped":        "nur die letzten %v Wiedergaben zusammengefasst, %v ältere verworfen",
+		"warnStale":         "keine Wiedergaben seit %s aufgezeichnet",
+		"warnMalformed":     "%v Wiedergaben mit fehlerhaften Zeitstempeln übersprungen",
+		"warnUnresolved":    "%v Wiedergaben von Titeln ohne Metadaten",
+		"warnNoDuration":    "%v Wiedergaben von Titeln ohne Dauer",
+		"warnUntyped":       "%v Wiedergaben ohne Typ als Musik gezählt",
+		"diff":              "%s ggü. %s: %s Wiedergaben, %s Titel, %s neu, %s gehört",
+		"diffOnly":          "nur am %s: %s",
+		"diffArtistsOnly":   "Künstler nur am %s: %s",
+		"artist":            "%s: %s Wiedergaben von %s Titeln",
+		"artistNone":        "%s: keine Wiedergaben dieses Künstlers",
+		"artistTracks":      "Titel: %s",
+		"artistFirst":       "zum ersten Mal: %s",
+	},
+}
+
+// validLang checks lang has translations, empty is english.
+func validLang(lang string) error {
+	if _, ok := translations[lang]; !ok && lang != "" {
+		return fmt.Errorf("unknown lang %q", lang)
+	}
+	return nil
+}
+
+// label returns the format string for key in the requested language,
+// falling back to english.
+func (o summaryOptions) label(key string) string {
+	if t, ok := translations[o.lang][key]; ok {
+		return t
+	}
+	return english[key]
+}
+
+// weekday is the abbreviated name of day's weekday in the requested language.
+func (o summaryOptions) weekday(day time.Time) string {
+	return o.label(strings.ToLower(day.Weekday().String()) + "Short")
+}
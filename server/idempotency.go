@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyCache remembers the responses of requests by Idempotency-Key
+// for earbug.idempotency.ttl after they complete.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	done    bool
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// replayedHeaders are the response headers repeated with a prior response.
+var replayedHeaders = []string{"Content-Type", "X-Earbug-Bucket", "X-Earbug-Data-Hash"}
+
+// begin claims key, or returns the entry already holding it.
+func (c *idempotencyCache) begin(key string, now time.Time) (*idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotencyEntry)
+	}
+	for k, e := range c.entries {
+		if e.done && now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	c.entries[key] = &idempotencyEntry{}
+	return nil, true
+}
+
+// finish records the response for key,
+// only successful responses are kept, others release the key for retries.
+func (c *idempotencyCache) finish(key string, code int, header http.Header, body []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if code != http.StatusOK {
+		delete(c.entries, key)
+		return
+	}
+	kept := make(http.Header)
+	for _, name := range replayedHeaders {
+		if v := header.Values(name); len(v) > 0 {
+			kept[name] = append([]string(nil), v...)
+		}
+	}
+	c.entries[key] = &idempotencyEntry{
+		done:    true,
+		header:  kept,
+		body:    body,
+		expires: expires,
+	}
+}
+
+// release frees key if its request never finished.
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !e.done {
+		delete(c.entries, key)
+	}
+}
+
+// idempotent deduplicates requests with the same Idempotency-Key for a path,
+// a repeat of a completed request gets the prior response without running h.
+func (s *Server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || s.idempotencyTTL <= 0 {
+			h(rw, r)
+			return
+		}
+		key = tenantScoped(r.Context(), r.URL.Path+" "+key)
+
+		prior, ok := s.idempotency.begin(key, time.Now())
+		if !ok {
+			if !prior.done {
+				http.Error(rw, "request with this Idempotency-Key in progress", http.StatusConflict)
+				return
+			}
+			s.log.V(1).Info("repeated idempotency key, returning prior response", "http_request", r)
+			for name, v := range prior.header {
+				rw.Header()[name] = v
+			}
+			rw.Header().Set("Idempotent-Replayed", "true")
+			rw.Write(prior.body)
+			return
+		}
+
+		// a panicking h leaves a partial response, retries run it again
+		defer s.idempotency.release(key)
+		rec := &responseRecorder{ResponseWriter: rw, code: http.StatusOK}
+		h(rec, r)
+		s.idempotency.finish(key, rec.code, rw.Header(), rec.body.Bytes(), time.Now().Add(s.idempotencyTTL))
+	}
+}
+
+// responseRecorder passes through a response while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdempotentReplayHeaders(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	s := newTestServer(t, store, nil, map[string]string{"earbug.posting.enabled": "false"})
+	header := http.Header{
+		"Accept":          {"application/json"},
+		"Idempotency-Key": {"k1"},
+	}
+
+	first := serve(s, http.MethodPost, "/summary?user=alice&includeHash=true", "", header)
+	if first.Code != http.StatusOK || first.Header().Get("X-Earbug-Data-Hash") == "" {
+		t.Fatalf("got %d %q, headers %v", first.Code, first.Body.String(), first.Header())
+	}
+	reads := store.reads
+	again := serve(s, http.MethodPost, "/summary?user=alice&includeHash=true", "", header)
+	if store.reads != reads || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("repeat wasn't replayed, headers %v", again.Header())
+	}
+	for _, name := range []string{"Content-Type", "X-Earbug-Data-Hash"} {
+		if got, want := again.Header().Get(name), first.Header().Get(name); got != want {
+			t.Errorf("replayed %s: got %q, want %q", name, got, want)
+		}
+	}
+	if again.Body.String() != first.Body.String() {
+		t.Errorf("replayed body: got %q, want %q", again.Body.String(), first.Body.String())
+	}
+}
+
+func TestIdempotentPanic(t *testing.T) {
+	s := newTestServer(t, &memStore{}, nil, map[string]string{"earbug.posting.enabled": "false"})
+	var runs int
+	h := s.idempotent(func(rw http.ResponseWriter, r *http.Request) {
+		runs++
+		rw.Write([]byte("partial"))
+		if runs == 1 {
+			panic("handler failed")
+		}
+	})
+	call := func() (rw *httptest.ResponseRecorder) {
+		r := httptest.NewRequest(http.MethodPost, "/summary", nil)
+		r.Header.Set("Idempotency-Key", "k1")
+		rw = httptest.NewRecorder()
+		defer func() { recover() }()
+		h(rw, r)
+		return rw
+	}
+
+	call()
+	rw := call()
+	if runs != 2 || rw.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d runs, replayed %q, want a panicked request run again", runs, rw.Header().Get("Idempotent-Replayed"))
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// lastPost is the most recent summary posted for a user.
+type lastPost struct {
+	PostedAt time.Time `json:"postedAt"`
+	// Text is the message as posted
+	Text    string   `json:"text"`
+	Summary *Summary `json:"summary"`
+}
+
+// lastPosts holds the most recent summary posted for each user since startup.
+type lastPosts struct {
+	mu    sync.Mutex
+	posts map[string]lastPost
+}
+
+func (l *lastPosts) put(user string, p lastPost) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.posts == nil {
+		l.posts = make(map[string]lastPost)
+	}
+	l.posts[user] = p
+}
+
+func (l *lastPosts) get(user string) (lastPost, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.posts[user]
+	return p, ok
+}
+
+// last returns the most recent summary posted for the requested user.
+func (s *Server) last(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("last")
+	ctx, span := s.trace.Start(r.Context(), "last")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		msg := "invalid method"
+		http.Error(rw, msg, http.StatusMethodNotAllowed)
+		log.Error(errors.New("GET only"), msg, "method", r.Method, "ctx", ctx, "http_request", r)
+		return
+	}
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	p, ok := s.lastPosted.get(tenantScoped(ctx, user))
+	if !ok {
+		http.Error(rw, "no summary posted since startup", http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(p)
+}
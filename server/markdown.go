@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	formatText     = "text"
+	formatMarkdown = "markdown"
+	formatGlance   = "glance"
+	// formatProtobuf and formatJSON are chosen by the Accept header, not ?format=
+	formatProtobuf = "protobuf"
+	formatJSON     = "json"
+	// formatDigestV1 is chosen by ?schema=digest.v1
+	formatDigestV1 = schemaDigestV1
+)
+
+// postsToChat reports whether summaries in format are posted,
+// other formats are for other integrations and only returned.
+func postsToChat(format string) bool {
+	return format == formatText || format == formatGlance
+}
+
+// renderMarkdown renders sum as a markdown document.
+func renderMarkdown(sum *Summary, opts summaryOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Listening summary for %s\n\n", markdownEscape(sum.User))
+	fmt.Fprintf(&b, "## %s\n\n", markdownEscape(formatDate(sum.Date, opts.dateFormat)))
+	if sum.Context != "" {
+		fmt.Fprintf(&b, "Plays from %s.\n\n", markdownEscape(sum.Context))
+	}
+
+	fmt.Fprintf(&b, "- **Plays:** %s\n", formatCount(sum.Plays, opts.thousands))
+	fmt.Fprintf(&b, "- **Tracks:** %s (%s new, %s one-offs)\n", formatCount(sum.Tracks, opts.thousands), formatCount(sum.NewTracks, opts.thousands), formatCount(sum.OneOffs, opts.thousands))
+	fmt.Fprintf(&b, "- **Listened:** %s\n", formatListened(sum.Listened, opts))
+	if ls := sum.LongestSession; ls != nil {
+		fmt.Fprintf(&b, "- **Longest session:** %s (%s tracks from %s)\n", formatDuration(ls.Duration, opts.durationPrecision), formatCount(ls.Tracks, opts.thousands), ls.Start.Format("15:04"))
+	}
+	if ph := sum.PeakHour; ph != nil {
+		fmt.Fprintf(&b, "- **Peak hour:** %02d:00 (%s%% of plays)\n", ph.Hour, formatPercent(ph.Plays, sum.Plays, opts.percentPrecision))
+	}
+	if pc := sum.Podcasts; pc != nil && pc.Plays > 0 {
+		fmt.Fprintf(&b, "- **Podcasts:** %s plays, %s\n", formatCount(pc.Plays, opts.thousands), formatDuration(pc.Listened, opts.durationPrecision))
+	}
+	if a := sum.Anomaly; a != nil {
+		fmt.Fprintf(&b, "- **Anomaly:** %.1fx plays vs %s (%s)\n", a.Change, formatDate(a.PriorDate, opts.dateFormat), formatCount(a.PriorPlays, opts.thousands))
+	}
+
+	if len(sum.Days) > 0 {
+		b.WriteString("\n### Days\n\n| Day | Plays |\n| --- | ---: |\n")
+		for _, d := range sum.Days {
+			day, err := time.Parse(dateLayout, d.Date)
+			if err != nil {
+				continue
+			}
+			label := formatDate(d.Date, opts.dateFormat)
+			if !strings.Contains(opts.dateFormat, "Mon") {
+				label = day.Format("Mon") + " " + label
+			}
+			fmt.Fprintf(&b, "| %s | %s |\n", markdownEscape(label), formatCount(d.Plays, opts.thousands))
+		}
+	}
+	markdownTracks(&b, "Top tracks", sum.Top, opts)
+	markdownTracks(&b, "All time top tracks", sum.AllTime, opts)
+	if len(sum.Groups) > 0 {
+		fmt.Fprintf(&b, "\n### By %s\n\n| # | %s | Plays |\n| ---: | --- | ---: |\n", sum.GroupBy, sum.GroupBy)
+		for i, g := range sum.Groups {
+			fmt.Fprintf(&b, "| %v | %s | %s |\n", i+1, markdownEscape(g.Name), formatCount(g.Plays, opts.thousands))
+		}
+	}
+	if opts.warnings && len(sum.Warnings) > 0 {
+		b.WriteString("\n### Warnings\n\n")
+		for _, w := range sum.warningTexts(opts) {
+			fmt.Fprintf(&b, "- %s\n", markdownEscape(w))
+		}
+	}
+	return b.String()
+}
+
+func markdownTracks(b *strings.Builder, heading string, tracks []TrackCount, opts summaryOptions) {
+	if len(tracks) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n### %s\n\n| # | Track | Artists | Plays |\n| ---: | --- | --- | ---: |\n", heading)
+	for i, t := range tracks {
+		fmt.Fprintf(b, "| %v | %s | %s | %s |\n", i+1, markdownEscape(t.Name), markdownEscape(formatArtists(t.Artists, opts)), formatCount(t.Plays, opts.thousands))
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_",
+	"{", "\\{", "}", "\\}", "[", "\\[", "]", "\\]",
+	"<", "\\<", ">", "\\>", "(", "\\(", ")", "\\)",
+	"#", "\\#", "+", "\\+", "-", "\\-", ".", "\\.",
+	"!", "\\!", "|", "\\|", "\r", "", "\n", " ",
+)
+
+// markdownEscape escapes s for inline use, including in table cells.
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
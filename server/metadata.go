@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// metadataCache holds track metadata from the shared metadata object.
+type metadataCache struct {
+	mu      sync.Mutex
+	tracks  map[string]*earbugv3.Track
+	fetched time.Time
+}
+
+// sharedTracks returns the tracks from the shared metadata object,
+// rereading it at most every earbug.metadata.refresh.
+// If a reread fails, the previous copy continues to be used
+// until it's older than earbug.cache.maxage.
+func (s *Server) sharedTracks(ctx context.Context) (map[string]*earbugv3.Track, error) {
+	c := s.metadataFor(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tracks != nil && time.Since(c.fetched) < s.metadataRefresh {
+		return c.tracks, nil
+	}
+
+	tracks, err := s.readSharedTracks(ctx)
+	if err != nil {
+		if c.tracks != nil && (s.cacheMaxAge <= 0 || time.Since(c.fetched) < s.cacheMaxAge) {
+			s.log.Error(err, "refresh shared metadata, using previous copy", "fetched", c.fetched)
+			return c.tracks, nil
+		}
+		return nil, err
+	}
+	c.tracks, c.fetched = tracks, time.Now()
+	return c.tracks, nil
+}
+
+// reset drops the tracks, so they're read again on next use.
+func (c *metadataCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tracks = nil
+}
+
+// evict drops the tracks if fetched before cutoff, reporting whether it did.
+func (c *metadataCache) evict(cutoff time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tracks == nil || !c.fetched.Before(cutoff) {
+		return false
+	}
+	c.tracks = nil
+	return true
+}
+
+func (s *Server) readSharedTracks(ctx context.Context) (map[string]*earbugv3.Track, error) {
+	ctx, span := s.trace.Start(ctx, "read-metadata")
+	defer span.End()
+
+	store, err := s.objects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	or, err := store.NewReader(ctx, s.metadataObject)
+	if err != nil {
+		s.checkCredentials(ctx, err)
+		return nil, fmt.Errorf("read %s: %w", s.metadataObject, err)
+	}
+	defer or.Close()
+	b, err := readObject(s.metadataObject, or, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", s.metadataObject, err)
+	}
+	var data earbugv3.Store
+	err = proto.Unmarshal(b, &data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", s.metadataObject, err)
+	}
+	return data.Tracks, nil
+}
+
+// mergeTracks returns the user's tracks with the shared tracks added,
+// the user's own metadata taking precedence.
+// Neither input map is modified.
+func mergeTracks(own, shared map[string]*earbugv3.Track) map[string]*earbugv3.Track {
+	merged := make(map[string]*earbugv3.Track, len(own)+len(shared))
+	for id, t := range shared {
+		merged[id] = t
+	}
+	for id, t := range own {
+		merged[id] = t
+	}
+	return merged
+}
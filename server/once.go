@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// runOnce writes yesterday's summary for earbug.once.user to w,
+// posting it too with earbug.once.post.
+// It returns the process exit code.
+func (s *Server) runOnce(ctx context.Context, w, errw io.Writer, setupErr error) int {
+	fail := func(msg string, err error) int {
+		fmt.Fprintf(errw, "%s: %v\n", msg, err)
+		return 1
+	}
+	if setupErr != nil {
+		return fail("invalid config", setupErr)
+	}
+	q, err := url.ParseQuery(s.onceQuery)
+	if err != nil {
+		return fail("parse earbug.once.query", err)
+	}
+	opts, err := s.summaryOptions(q)
+	if err != nil {
+		return fail("invalid options", err)
+	}
+	var client Notifier
+	if s.oncePost {
+		client, err = s.notifierFor(ctx, "")
+		if err != nil {
+			return fail("no webhook configured", err)
+		}
+	}
+
+	win, _ := yesterday(nil, s.now(opts), s.loc)
+	timing := &serverTiming{}
+	data, err := s.readStore(ctx, s.onceUser, timing)
+	if err != nil {
+		msg, _ := stageStatus(err)
+		return fail(msg, err)
+	}
+	// render the message regardless of posting,
+	// leaving side effects to the post
+	render := opts
+	render.persist = render.persist && client == nil
+	sum, msg, _, err := s.postSummary(ctx, nil, s.loc, win, data, render, timing)
+	if err != nil {
+		return fail(msg, err)
+	}
+	fmt.Fprintln(w, msg)
+	if client != nil {
+		_, msg, _, err = s.postSummary(ctx, client, s.loc, win, data, opts, timing)
+		if err != nil {
+			return fail(msg, err)
+		}
+	}
+	s.log.Info("summarized once", append(sum.logValues(), "user", s.onceUser, "posted", client != nil)...)
+	return 0
+}
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// summaryOptions are per request options for rendering a summary,
+// parsed from the query parameters.
+type summaryOptions struct {
+	durationPrecision string
+	// durationUnit renders listening time as durationUnitAlbums of albumLength,
+	// as a duration if empty.
+	durationUnit string
+	albumLength  time.Duration
+	// fields are the sections to render in order,
+	// defaultSections if empty.
+	fields []string
+	// ignoredFields are unknown requested fields.
+	ignoredFields []string
+	// groupBy is the grouper to rank plays by, none if empty.
+	groupBy string
+	// rankBy orders the top tracks, rankPlays or rankAffinity.
+	rankBy string
+	// maxChars is the most characters in text summaries,
+	// sections past it are left out, 0 for no limit.
+	maxChars int
+	// context limits the summary to plays from a context uri, all if empty.
+	context string
+	// excludeToday ends windows before the current, partial, day.
+	excludeToday bool
+	// format is formatText, formatMarkdown, formatGlance, formatProtobuf, formatJSON,
+	// or formatDigestV1.
+	format string
+	// barWidth is the length of the bar for the day with the most plays.
+	barWidth int
+	// barChar is repeated to draw bars.
+	barChar string
+	// maxList caps every ranked list, earbug.maxlist if unset,
+	// each list's own length if 0.
+	maxList int
+	// lastN summarizes the most recent plays instead of the picked window.
+	lastN int
+	// sectionSep joins sections in text summaries.
+	sectionSep string
+	// thousands separates groups of digits in counts.
+	thousands string
+	// percentPrecision is the decimals in rendered percentages.
+	percentPrecision int
+	// dateFormat is the layout for rendered dates.
+	dateFormat string
+	// perDay posts a separate summary for each of the previous days.
+	perDay int
+	// compare shows changes from the prior day in single day summaries.
+	compare bool
+	// explain returns the picked window instead of a summary.
+	explain bool
+	// minPlays is the fewest plays for a track or group to be ranked.
+	minPlays int
+	// artists is artistsAll or artistsPrimary.
+	artists string
+	// artistSep joins artists.
+	artistSep string
+	// attachJSON follows the posted summary with its json in a thread reply.
+	attachJSON bool
+	// threadedDaily posts a multi day summary as a card
+	// with a reply card for each day in its thread.
+	threadedDaily bool
+	// requireFresh refuses to post if the latest play is older than
+	// earbug.fresh.threshold.
+	requireFresh bool
+	// glanceEmoji maps glance metrics to their symbol.
+	glanceEmoji map[string]string
+	// glanceDate prefixes glance summaries with the date.
+	glanceDate bool
+	// warnings renders data quality notes at the end of messages.
+	warnings bool
+	// includeHash returns a hash of the decompressed store in a header.
+	includeHash bool
+	// buttons attaches a card linking the top tracks to spotify.
+	buttons bool
+	// persist stores the summary as json in the bucket.
+	persist bool
+	// overwrite replaces an existing persisted summary.
+	overwrite bool
+	// thresholds gate posting, combined by thresholdMode (thresholdAll or thresholdAny).
+	thresholds    []threshold
+	thresholdMode string
+	// priorRank shows where top tracks ranked in the previous window.
+	priorRank bool
+	// includeNewTracks lists the new tracks in json summaries.
+	includeNewTracks bool
+	// loc overrides the summary time zone, earbug.timezone if nil.
+	loc *time.Location
+	// channel summarizes only plays since the last summary for the channel.
+	channel string
+	// cursor is the channel's cursor to claim before posting,
+	// set by serveSummary, nil elsewhere.
+	cursor *cursorClaim
+	// lang picks the translation of labels, english if empty.
+	lang string
+	// asOf picks windows as if it were this moment, the current time if zero.
+	asOf time.Time
+	// emptyStatus is returned for windows without plays, one of emptyStatuses.
+	// Other than 200, nothing is posted.
+	emptyStatus int
+}
+
+// invalidError marks well formed parameters with values that can't be used,
+// e.g. unknown names, out of range numbers, or an unknown time zone.
+// They're answered with 422, malformed requests with 400.
+type invalidError struct {
+	error
+}
+
+func (e invalidError) Unwrap() error { return e.error }
+
+func invalidf(format string, args ...any) error {
+	return invalidError{fmt.Errorf(format, args...)}
+}
+
+// optionsStatus is the status for a request rejected with err:
+// 422 Unprocessable Entity for an invalidError, 400 Bad Request otherwise.
+func optionsStatus(err error) int {
+	var ie invalidError
+	if errors.As(err, &ie) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+// emptyStatuses are the allowed statuses for windows without plays.
+var emptyStatuses = map[int]bool{
+	http.StatusOK:        true,
+	http.StatusNoContent: true,
+	http.StatusNotFound:  true,
+}
+
+func validEmptyStatus(code int) error {
+	if !emptyStatuses[code] {
+		return fmt.Errorf("empty status %d must be 200, 204, or 404", code)
+	}
+	return nil
+}
+
+func parseSummaryOptions(q url.Values) (summaryOptions, error) {
+	opts := summaryOptions{
+		durationPrecision: precisionMinute,
+		format:            formatText,
+		barWidth:          8,
+		barChar:           "█",
+		artists:           artistsAll,
+		artistSep:         ", ",
+		glanceDate:        true,
+		warnings:          true,
+		sectionSep:        sectionSeparators[separatorPipe],
+		thresholdMode:     thresholdAll,
+	}
+
+	if v := q.Get("artists"); v != "" {
+		switch v {
+		case artistsAll, artistsPrimary:
+			opts.artists = v
+		default:
+			return opts, invalidf("unknown artists %q", v)
+		}
+	}
+	if q.Has("artistSep") {
+		opts.artistSep = q.Get("artistSep")
+	}
+
+	if v := q.Get("barWidth"); v != "" {
+		w, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse barWidth: %w", err)
+		}
+		if w < 1 || w > 50 {
+			return opts, invalidf("barWidth %d out of range 1-50", w)
+		}
+		opts.barWidth = w
+	}
+	if v := q.Get("lastN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse lastN: %w", err)
+		}
+		if n < 1 {
+			return opts, invalidf("lastN %d must be positive", n)
+		}
+		opts.lastN = n
+	}
+	var err error
+	opts.thresholds, err = parseThresholds(q)
+	if err != nil {
+		return opts, err
+	}
+	if v := q.Get("thresholdMode"); v != "" {
+		switch v {
+		case thresholdAll, thresholdAny:
+			opts.thresholdMode = v
+		default:
+			return opts, invalidf("unknown thresholdMode %q, expected %s or %s", v, thresholdAll, thresholdAny)
+		}
+	}
+	if v := q.Get("lang"); v != "" {
+		if err := validLang(v); err != nil {
+			return opts, invalidError{err}
+		}
+		opts.lang = v
+	}
+	if v := q.Get("channel"); v != "" {
+		if err := validChannel(v); err != nil {
+			return opts, invalidError{err}
+		}
+		opts.channel = v
+	}
+	if v := q.Get("tz"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return opts, invalidf("load tz: %w", err)
+		}
+		opts.loc = loc
+	}
+	if v := q.Get("priorRank"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse priorRank: %w", err)
+		}
+		opts.priorRank = b
+	}
+	if v := q.Get("emptyStatus"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse emptyStatus: %w", err)
+		}
+		if err := validEmptyStatus(code); err != nil {
+			return opts, invalidError{err}
+		}
+		opts.emptyStatus = code
+	}
+	if v := q.Get("includeNewTracks"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse includeNewTracks: %w", err)
+		}
+		opts.includeNewTracks = b
+	}
+	if v := q.Get("maxList"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse maxList: %w", err)
+		}
+		if n < 1 || n > 50 {
+			return opts, invalidf("maxList %d out of range 1-50", n)
+		}
+		opts.maxList = n
+	}
+	if v := q.Get("minPlays"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse minPlays: %w", err)
+		}
+		if n < 1 {
+			return opts, invalidf("minPlays %d must be positive", n)
+		}
+		opts.minPlays = n
+	}
+	if v := q.Get("attachJSON"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse attachJSON: %w", err)
+		}
+		opts.attachJSON = b
+	}
+	if v := q.Get("threadedDaily"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse threadedDaily: %w", err)
+		}
+		opts.threadedDaily = b
+	}
+	if v := q.Get("requireFresh"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse requireFresh: %w", err)
+		}
+		opts.requireFresh = b
+	}
+	if v := q.Get("warnings"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse warnings: %w", err)
+		}
+		opts.warnings = b
+	}
+	if v := q.Get("includeHash"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse includeHash: %w", err)
+		}
+		opts.includeHash = b
+	}
+	if v := q.Get("buttons"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse buttons: %w", err)
+		}
+		opts.buttons = b
+	}
+	if v := q.Get("persist"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse persist: %w", err)
+		}
+		opts.persist = b
+	}
+	if v := q.Get("overwrite"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse overwrite: %w", err)
+		}
+		opts.overwrite = b
+	}
+	if v := q.Get("asOf"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, fmt.Errorf("parse asOf: %w", err)
+		}
+		opts.asOf = t
+	}
+	if v := q.Get("glanceDate"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse glanceDate: %w", err)
+		}
+		opts.glanceDate = b
+	}
+	if v := q.Get("explain"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse explain: %w", err)
+		}
+		opts.explain = b
+	}
+	if v := q.Get("compare"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse compare: %w", err)
+		}
+		opts.compare = b
+	}
+	if v := q.Get("perDay"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse perDay: %w", err)
+		}
+		if b {
+			opts.perDay = 1
+			if v := q.Get("days"); v != "" {
+				opts.perDay, err = strconv.Atoi(v)
+				if err != nil {
+					return opts, fmt.Errorf("parse days: %w", err)
+				}
+			}
+			if opts.perDay < 1 || opts.perDay > 31 {
+				return opts, invalidf("days %d out of range 1-31", opts.perDay)
+			}
+			if opts.threadedDaily {
+				return opts, invalidf("perDay and threadedDaily are exclusive")
+			}
+		}
+	}
+	if v := q.Get("barChar"); v != "" {
+		opts.barChar = v
+	}
+
+	if v := q.Get("format"); v != "" {
+		switch v {
+		case formatText, formatMarkdown, formatGlance:
+			opts.format = v
+		default:
+			return opts, invalidf("unknown format %q", v)
+		}
+	}
+	if v := q.Get("schema"); v != "" {
+		if v != schemaDigestV1 {
+			return opts, invalidf("unknown schema %q, expected %s", v, schemaDigestV1)
+		}
+		if q.Get("format") != "" {
+			return opts, invalidf("schema and format are exclusive")
+		}
+		opts.format = formatDigestV1
+	}
+
+	if v := q.Get("durationUnit"); v != "" {
+		if v != durationUnitAlbums {
+			return opts, invalidf("unknown durationUnit %q, expected %s", v, durationUnitAlbums)
+		}
+		opts.durationUnit = v
+	}
+
+	if v := q.Get("durationPrecision"); v != "" {
+		switch v {
+		case precisionPrecise, precisionMinute, precisionQuarter:
+			opts.durationPrecision = v
+		default:
+			return opts, invalidf("unknown durationPrecision %q", v)
+		}
+	}
+
+	if v := q.Get("fields"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := sections[name]; !ok {
+				opts.ignoredFields = append(opts.ignoredFields, name)
+				continue
+			}
+			opts.fields = append(opts.fields, name)
+		}
+	}
+
+	if v := q.Get("groupBy"); v != "" {
+		if err := validGroupBy(v); err != nil {
+			return opts, invalidError{err}
+		}
+		opts.groupBy = v
+	}
+
+	if v := q.Get("maxChars"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse maxChars: %w", err)
+		}
+		if n < minMaxChars || n > maxMaxChars {
+			return opts, invalidf("maxChars %d out of range %d-%d", n, minMaxChars, maxMaxChars)
+		}
+		opts.maxChars = n
+	}
+	if v := q.Get("rankBy"); v != "" {
+		switch v {
+		case rankPlays, rankAffinity:
+			opts.rankBy = v
+		default:
+			return opts, invalidf("unknown rankBy %q, expected %s or %s", v, rankPlays, rankAffinity)
+		}
+	}
+
+	if v := q.Get("excludeToday"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("parse excludeToday: %w", err)
+		}
+		opts.excludeToday = b
+	}
+
+	if v := q.Get("context"); v != "" {
+		if err := validContext(v); err != nil {
+			return opts, invalidError{err}
+		}
+		opts.context = v
+	}
+
+	return opts, nil
+}
+
+// validContext checks uri looks like a spotify context,
+// e.g. spotify:playlist:ID or spotify:user:NAME:collection.
+func validContext(uri string) error {
+	parts := strings.Split(uri, ":")
+	if len(parts) < 3 || parts[0] != "spotify" {
+		return fmt.Errorf("context %q is not a spotify uri", uri)
+	}
+	for _, p := range parts[1:] {
+		if p == "" {
+			return fmt.Errorf("context %q has an empty segment", uri)
+		}
+	}
+	return nil
+}
+
+// summaryOptions parses the options for a request,
+// with defaults from the server config.
+func (s *Server) summaryOptions(q url.Values) (summaryOptions, error) {
+	opts, err := parseSummaryOptions(s.withDefaults(q))
+	opts.thousands = thousandsSeparators[s.numbers]
+	opts.percentPrecision = s.percentPrecision
+	opts.albumLength = s.albumLength
+	opts.sectionSep = sectionSeparators[s.separator]
+	opts.dateFormat = s.dateFormat
+	opts.glanceEmoji = s.glanceEmoji
+	if opts.emptyStatus == 0 {
+		opts.emptyStatus = s.emptyStatus
+	}
+	if opts.maxList == 0 {
+		opts.maxList = s.maxList
+	}
+	if opts.maxChars > 0 {
+		// posts carry earbug.post.prefix and suffix within the same limit
+		opts.maxChars -= utf8.RuneCountInString(s.decorate(""))
+		if opts.maxChars < 1 {
+			opts.maxChars = 1
+		}
+	}
+	return opts, err
+}
+
+// hasField reports whether the named section was explicitly requested.
+func (o summaryOptions) hasField(name string) bool {
+	for _, f := range o.fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// QuarterArtist is the most played artist in a quarter of the year.
+type QuarterArtist struct {
+	Quarter string `json:"quarter"`
+	// Artist is empty for quarters without plays in the window
+	Artist string `json:"artist,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Plays  int    `json:"plays,omitempty"`
+}
+
+// quarterOf returns the year and quarter, 1-4, of a date, zeros if invalid.
+func quarterOf(day string) (int, int) {
+	t, err := time.Parse(dateLayout, day)
+	if err != nil {
+		return 0, 0
+	}
+	return t.Year(), (int(t.Month())-1)/3 + 1
+}
+
+// artistShift ranks artists in each quarter of the year the window ends in,
+// for windows spanning more than one quarter.
+func artistShift(data *earbugv3.Store, plays []playback, win window) []QuarterArtist {
+	fromYear, fromQuarter := quarterOf(win.from)
+	year, toQuarter := quarterOf(win.to)
+	if year == 0 || fromYear == year && fromQuarter == toQuarter {
+		return nil
+	}
+	var counts [4]groupCounts
+	artists := groupers["artist"]
+	for _, p := range plays {
+		y, q := quarterOf(p.ts.Format(dateLayout))
+		if y != year {
+			continue
+		}
+		counts[q-1].add(artists(p.played, data.Tracks[p.trackID]))
+	}
+	shift := make([]QuarterArtist, 4)
+	for i := range shift {
+		shift[i].Quarter = fmt.Sprintf("Q%d", i+1)
+		if top := counts[i].top(1); len(top) > 0 {
+			shift[i].Artist, shift[i].Name, shift[i].Plays = top[0].Key, top[0].Name, top[0].Plays
+		}
+	}
+	return shift
+}
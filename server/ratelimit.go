@@ -0,0 +1,97 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the client's bucket,
+// refilled at rate tokens per second up to burst.
+// If none are available, it returns how long until one is.
+func (l *rateLimiter) allow(client string, now time.Time, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	if now.Sub(l.pruned) > full {
+		// buckets untouched for long enough are full again,
+		// the same as a new bucket
+		for c, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, c)
+			}
+		}
+		l.pruned = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit rejects requests from clients over the configured rate
+// with 429 Too Many Requests.
+func (s *Server) rateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if s.rateLimitRate <= 0 {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		client := s.clientIP(r)
+		ok, wait := s.limiter.allow(client, time.Now(), s.rateLimitRate, s.rateLimitBurst)
+		if !ok {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(rw, "rate limited", http.StatusTooManyRequests)
+			s.log.V(1).Info("rate limited", "client", client, "http_request", r)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// clientIP identifies the client of r.
+// Behind a trusted proxy, it is the last address in X-Forwarded-For,
+// the one appended by the proxy, earlier entries can be set by the client.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			addrs := strings.Split(xff[len(xff)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// reloadResult describes what a reload changed.
+type reloadResult struct {
+	// Tracks added, changed, and removed in the shared metadata object
+	TracksAdded   int `json:"tracksAdded"`
+	TracksChanged int `json:"tracksChanged"`
+	TracksRemoved int `json:"tracksRemoved"`
+	// Users listed for batch runs
+	Users int `json:"users"`
+	// Tenants in earbug.tenants
+	Tenants int `json:"tenants,omitempty"`
+	// SummaryParams are the default summary parameters in earbug.config
+	SummaryParams int `json:"summaryParams,omitempty"`
+}
+
+// reloadable is the config /reload replaces.
+// It's published whole and not modified after,
+// so requests see the tenants, metadata and defaults of one reload.
+type reloadable struct {
+	// tenants by name from earbug.tenants
+	tenants map[string]*tenant
+	// metadata caches the shared metadata object of the default setup,
+	// tenants have their own
+	metadata *metadataCache
+	// summaryDefaults are query parameters from earbug.config
+	summaryDefaults url.Values
+}
+
+// reload rereads the shared metadata object ahead of earbug.metadata.refresh,
+// earbug.tenants, and the summary parameters in earbug.config,
+// and checks the users for batch runs can still be listed.
+// Nothing is replaced if any of them fails to read.
+// Flags, including those set in earbug.config, need a restart.
+func (s *Server) reload(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("reload")
+	ctx, span := s.trace.Start(r.Context(), "reload")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		msg := "invalid method"
+		http.Error(rw, msg, http.StatusMethodNotAllowed)
+		log.Error(errors.New("POST only"), msg, "method", r.Method, "ctx", ctx, "http_request", r)
+		return
+	}
+	if s.reloadToken == "" {
+		msg := "reload disabled"
+		http.Error(rw, msg, http.StatusNotFound)
+		log.Error(errors.New("no earbug.reload.token"), msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("authorization")), []byte("Bearer "+s.reloadToken)) != 1 {
+		msg := "unauthorized"
+		http.Error(rw, msg, http.StatusUnauthorized)
+		log.Error(errors.New("bad or missing bearer token"), msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	cur := s.reloaded.Load()
+	next := *cur
+
+	var res reloadResult
+	if s.tenantsFile != "" {
+		byName, err := s.loadTenants()
+		if err != nil {
+			msg := "read tenants"
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		keepStores(byName, cur.tenants)
+		next.tenants = byName
+		res.Tenants = len(byName)
+	}
+	if s.configFile != "" {
+		cfg, err := s.readConfig()
+		if err != nil {
+			msg := "read config"
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		next.summaryDefaults = cfg.summary
+		res.SummaryParams = len(cfg.summary)
+	}
+	if s.metadataObject != "" {
+		tracks, err := s.readSharedTracks(ctx)
+		if err != nil {
+			msg := "read shared metadata"
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		c := s.metadataFor(ctx)
+		c.mu.Lock()
+		for id, t := range tracks {
+			if old, ok := c.tracks[id]; !ok {
+				res.TracksAdded++
+			} else if !proto.Equal(old, t) {
+				res.TracksChanged++
+			}
+		}
+		for id := range c.tracks {
+			if _, ok := tracks[id]; !ok {
+				res.TracksRemoved++
+			}
+		}
+		c.mu.Unlock()
+		// other setups reread their own metadata objects on next use,
+		// reloaded tenants start with empty caches
+		next.metadata = &metadataCache{}
+		fresh := next.metadata
+		if t := tenantFrom(ctx); t != nil {
+			fresh = nil
+			if nt, ok := next.tenants[t.Name]; ok && nt.Bucket == t.Bucket {
+				fresh = &nt.metadata
+			}
+		}
+		if fresh != nil {
+			fresh.tracks, fresh.fetched = tracks, time.Now()
+		}
+	}
+	s.reloaded.Store(&next)
+
+	users, err := s.listUsers(ctx)
+	if err != nil {
+		msg := "list users"
+		http.Error(rw, msg, http.StatusInternalServerError)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	res.Users = len(users)
+
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(res)
+	log.Info("reloaded", "tracks_added", res.TracksAdded, "tracks_changed", res.TracksChanged, "tracks_removed", res.TracksRemoved, "users", res.Users, "tenants", res.Tenants, "summary_params", res.SummaryParams, "ctx", ctx, "http_request", r)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(config string) {
+		err := os.WriteFile(path, []byte(config), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"summary": {"maxList": 3}}`)
+	s := newTestServer(t, &memStore{}, nil, map[string]string{
+		"earbug.posting.enabled": "false",
+		"earbug.config":          path,
+		"earbug.reload.token":    "secret",
+	})
+	header := http.Header{"Authorization": {"Bearer secret"}}
+
+	write(`{"summary": {"maxList": 4, "format": "markdown"}}`)
+	rw := serve(s, http.MethodPost, "/reload", "", header)
+	var res reloadResult
+	json.Unmarshal(rw.Body.Bytes(), &res)
+	if rw.Code != http.StatusOK || res.SummaryParams != 2 {
+		t.Fatalf("got %d %q, want 200 and 2 summary params", rw.Code, rw.Body.String())
+	}
+	if got := s.withDefaults(nil).Get("maxList"); got != "4" {
+		t.Errorf("reloaded maxList %q, want 4", got)
+	}
+
+	// an invalid config keeps the current one
+	write(`{"summary": {"maxList": "x"}}`)
+	if rw := serve(s, http.MethodPost, "/reload", "", header); rw.Code != http.StatusInternalServerError {
+		t.Errorf("invalid config: got %d, want 500", rw.Code)
+	}
+	if got := s.withDefaults(nil).Get("maxList"); got != "4" {
+		t.Errorf("maxList after invalid reload %q, want 4", got)
+	}
+}
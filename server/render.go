@@ -0,0 +1,687 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// section renders one part of a summary,
+// an empty string omits it from the message.
+type section func(sum *Summary, opts summaryOptions) string
+
+var sections = map[string]section{
+	"plays": func(sum *Summary, opts summaryOptions) string {
+		out := fmt.Sprintf(opts.label("plays"), formatCount(sum.Plays, opts.thousands))
+		if sum.Prior != nil {
+			out += fmt.Sprintf(opts.label("vsPrior"), formatDelta(sum.Plays-sum.Prior.Plays, opts.thousands))
+		}
+		return out
+	},
+	"tracks": func(sum *Summary, opts summaryOptions) string {
+		var delta string
+		if sum.Prior != nil {
+			delta = formatDelta(sum.Tracks-sum.Prior.Tracks, opts.thousands) + ", "
+		}
+		return fmt.Sprintf(opts.label("tracks"), formatCount(sum.Tracks, opts.thousands), delta, formatCount(sum.NewTracks, opts.thousands), formatCount(sum.OneOffs, opts.thousands))
+	},
+	"time": func(sum *Summary, opts summaryOptions) string {
+		out := fmt.Sprintf(opts.label("listened"), formatListened(sum.Listened, opts))
+		if sum.Prior != nil {
+			d := sum.Listened - sum.Prior.Listened
+			sign := "+"
+			if d < 0 {
+				sign, d = "-", -d
+			}
+			out += " (" + sign + formatListened(d, opts) + ")"
+		}
+		return out
+	},
+	"days": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Days) == 0 {
+			return ""
+		}
+		parts := make([]string, 0, len(sum.Days))
+		for _, d := range sum.Days {
+			day, err := time.Parse(dateLayout, d.Date)
+			if err != nil {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s %s", opts.weekday(day), formatCount(d.Plays, opts.thousands)))
+		}
+		return strings.Join(parts, ", ")
+	},
+	"bars": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Days) == 0 {
+			return ""
+		}
+		vals := make([]int, len(sum.Days))
+		for i, d := range sum.Days {
+			vals[i] = d.Plays
+		}
+		parts := make([]string, 0, len(sum.Days))
+		for i, n := range barLengths(vals, opts.barWidth) {
+			day, err := time.Parse(dateLayout, sum.Days[i].Date)
+			if err != nil {
+				continue
+			}
+			bar := opts.weekday(day) + " "
+			if n > 0 {
+				bar += strings.Repeat(opts.barChar, n) + " "
+			}
+			parts = append(parts, bar+formatCount(vals[i], opts.thousands))
+		}
+		return strings.Join(parts, " ")
+	},
+	"daytops": func(sum *Summary, opts summaryOptions) string {
+		var parts []string
+		for _, d := range sum.Days {
+			day, err := time.Parse(dateLayout, d.Date)
+			if err != nil || d.Top == nil {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s: %s (%sx)", opts.weekday(day), d.Top.Name, formatCount(d.Top.Plays, opts.thousands)))
+		}
+		return strings.Join(parts, ", ")
+	},
+	"session": func(sum *Summary, opts summaryOptions) string {
+		ls := sum.LongestSession
+		if ls == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("session"), formatDuration(ls.Duration, opts.durationPrecision), formatCount(ls.Tracks, opts.thousands), ls.Start.Format("15:04"))
+	},
+	"peakhour": func(sum *Summary, opts summaryOptions) string {
+		ph := sum.PeakHour
+		if ph == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("peakhour"), ph.Hour, formatPercent(ph.Plays, sum.Plays, opts.percentPrecision))
+	},
+	"skips": func(sum *Summary, opts summaryOptions) string {
+		sr := sum.SkipRate
+		if sr == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("skips"), formatPercent(sr.Skipped, sr.Plays, opts.percentPrecision))
+	},
+	"anomaly": func(sum *Summary, opts summaryOptions) string {
+		a := sum.Anomaly
+		if a == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("anomaly"), a.Change, formatDate(a.PriorDate, opts.dateFormat), formatCount(a.PriorPlays, opts.thousands))
+	},
+	"record": func(sum *Summary, opts summaryOptions) string {
+		rec := sum.Record
+		if rec == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("record"), formatCount(rec.Plays, opts.thousands), formatCount(rec.PriorPlays, opts.thousands), formatDate(rec.PriorDate, opts.dateFormat))
+	},
+	"achievements": func(sum *Summary, opts summaryOptions) string {
+		return strings.Join(sum.Achievements, ", ")
+	},
+	"onthisday": func(sum *Summary, opts summaryOptions) string {
+		otd := sum.OnThisDay
+		if otd == nil {
+			return ""
+		}
+		when := fmt.Sprintf(opts.label("onthisdayYear"), otd.Date[:4])
+		if otd.Date[:4] == prevYear(sum.Date) {
+			when = opts.label("onthisdayLastYear")
+		}
+		name := otd.Track.Name
+		if len(otd.Track.Artists) > 0 {
+			name += " — " + formatArtists(otd.Track.Artists, opts)
+		}
+		return fmt.Sprintf(opts.label("onthisday"), when, name)
+	},
+	"discovery": func(sum *Summary, opts summaryOptions) string {
+		d := sum.FirstDiscovery
+		if d == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("discovery"), d.At.Format("15:04"), d.Name)
+	},
+	"goal": func(sum *Summary, opts summaryOptions) string {
+		g := sum.Goal
+		if g == nil {
+			return ""
+		}
+		reached := g.NewTracks
+		if reached > g.Target {
+			reached = g.Target
+		}
+		out := fmt.Sprintf(opts.label("goal"), formatCount(g.NewTracks, opts.thousands), formatCount(g.Target, opts.thousands), formatPercent(reached, g.Target, opts.percentPrecision))
+		if g.NewTracks >= g.Target {
+			out += " 🎯"
+		}
+		return out
+	},
+	"consistency": func(sum *Summary, opts summaryOptions) string {
+		c := sum.Consistency
+		if c == nil {
+			return ""
+		}
+		out := fmt.Sprintf(opts.label("consistency"), formatCount(int(math.Round(c.Mean)), opts.thousands))
+		if c.StdDev != nil {
+			out += fmt.Sprintf(" (σ %s)", formatCount(int(math.Round(*c.StdDev)), opts.thousands))
+		}
+		return out
+	},
+	"busiest": func(sum *Summary, opts summaryOptions) string {
+		b := sum.BusiestDay
+		if b == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("busiest"), opts.label(strings.ToLower(b.Weekday)), formatCount(int(math.Round(b.Average)), opts.thousands))
+	},
+	"streak": func(sum *Summary, opts summaryOptions) string {
+		st := sum.Streak
+		if st == nil || st.Days < 2 {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("streak"), formatCount(st.Days, opts.thousands))
+	},
+	"milestone": func(sum *Summary, opts summaryOptions) string {
+		m := sum.Milestone
+		if m == nil {
+			return ""
+		}
+		out := fmt.Sprintf(opts.label("milestone"), formatCount(m.Remaining, opts.thousands), formatCount(m.Target, opts.thousands))
+		if m.Days > 0 {
+			out += fmt.Sprintf(opts.label("milestonePace"), formatCount(m.Days, opts.thousands))
+		}
+		return out
+	},
+	"binge": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Binges) == 0 {
+			return ""
+		}
+		parts := make([]string, 0, len(sum.Binges))
+		for _, b := range sum.Binges {
+			parts = append(parts, fmt.Sprintf(opts.label("bingeAlbum"), b.Album, formatCount(b.Tracks, opts.thousands)))
+		}
+		return fmt.Sprintf(opts.label("binge"), strings.Join(parts, ", "))
+	},
+	"rising": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Rising) == 0 {
+			return ""
+		}
+		parts := make([]string, 0, len(sum.Rising))
+		for _, t := range sum.Rising {
+			parts = append(parts, fmt.Sprintf(opts.label("risingTrack"), t.Name, formatCount(t.Prior, opts.thousands), formatCount(t.Recent, opts.thousands)))
+		}
+		return fmt.Sprintf(opts.label("rising"), strings.Join(parts, ", "))
+	},
+	"duo": func(sum *Summary, opts summaryOptions) string {
+		d := sum.Duo
+		if d == nil {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("duo"), d.A, d.B, formatCount(d.Plays, opts.thousands))
+	},
+	"quarters": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.ArtistShift) == 0 {
+			return ""
+		}
+		parts := make([]string, 0, len(sum.ArtistShift))
+		for _, q := range sum.ArtistShift {
+			name := q.Name
+			if name == "" {
+				name = "-"
+			}
+			parts = append(parts, q.Quarter+": "+name)
+		}
+		return fmt.Sprintf(opts.label("quarters"), strings.Join(parts, ", "))
+	},
+	"podcasts": func(sum *Summary, opts summaryOptions) string {
+		pc := sum.Podcasts
+		if pc == nil || pc.Plays == 0 {
+			return ""
+		}
+		return fmt.Sprintf(opts.label("podcasts"), formatCount(pc.Plays, opts.thousands), formatDuration(pc.Listened, opts.durationPrecision))
+	},
+	"top": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Top) == 0 {
+			if sum.filtered["top"] {
+				return fmt.Sprintf(opts.label("topNone"), opts.minPlays)
+			}
+			return ""
+		}
+		return fmt.Sprintf(opts.label("top"), formatTrackList(sum.Top, opts))
+	},
+	"alltime": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.AllTime) == 0 {
+			if sum.filtered["alltime"] {
+				return fmt.Sprintf(opts.label("alltimeNone"), opts.minPlays)
+			}
+			return ""
+		}
+		return fmt.Sprintf(opts.label("alltime"), formatTrackList(sum.AllTime, opts))
+	},
+	"members": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Members) == 0 {
+			return ""
+		}
+		var total int
+		for _, m := range sum.Members {
+			total += m.Plays
+		}
+		parts := make([]string, 0, len(sum.Members))
+		for _, m := range sum.Members {
+			if m.Missing {
+				parts = append(parts, fmt.Sprintf(opts.label("memberMissing"), m.User))
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s %s%%", m.User, formatPercent(m.Plays, total, opts.percentPrecision)))
+		}
+		return fmt.Sprintf(opts.label("members"), strings.Join(parts, ", "))
+	},
+	"groups": func(sum *Summary, opts summaryOptions) string {
+		if len(sum.Groups) == 0 {
+			if sum.filtered["groups"] {
+				return fmt.Sprintf(opts.label("groupsNone"), sum.GroupBy, opts.minPlays)
+			}
+			return ""
+		}
+		parts := make([]string, 0, len(sum.Groups))
+		for i, g := range sum.Groups {
+			parts = append(parts, fmt.Sprintf("%v. %s (%s)", i+1, g.Name, formatCount(g.Plays, opts.thousands)))
+		}
+		return fmt.Sprintf(opts.label("groups"), sum.GroupBy, strings.Join(parts, ", "))
+	},
+}
+
+// defaultSections is the order of sections when no fields are requested.
+// Left out, and only shown when requested:
+// bars repeats days as a chart, alltime needs earbug.alltime and a scan of the whole store,
+// and streak counts past the summarized window.
+var defaultSections = []string{"plays", "tracks", "time", "top", "days", "daytops", "session", "peakhour", "skips", "discovery", "onthisday", "goal", "consistency", "busiest", "milestone", "record", "achievements", "anomaly", "podcasts", "groups", "rising", "duo", "quarters", "binge", "members"}
+
+const (
+	separatorPipe   = "pipe"
+	separatorLine   = "line"
+	separatorBlank  = "blank"
+	separatorRule   = "rule"
+	separatorBullet = "bullet"
+)
+
+// sectionSeparators join sections for each earbug.sections.separator style.
+var sectionSeparators = map[string]string{
+	separatorPipe:   " | ",
+	separatorLine:   "\n",
+	separatorBlank:  "\n\n",
+	separatorRule:   "\n---\n",
+	separatorBullet: "\n• ",
+}
+
+// countSections only need play timestamps and track ids.
+var countSections = []string{"plays", "tracks", "days", "bars", "record", "goal", "consistency", "busiest", "streak", "milestone", "anomaly"}
+
+// countsOnly keeps the fields that are countSections,
+// all of countSections if that leaves none.
+func countsOnly(fields []string) []string {
+	var kept []string
+	for _, f := range fields {
+		for _, c := range countSections {
+			if f == c {
+				kept = append(kept, f)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return countSections
+	}
+	return kept
+}
+
+// renderSummary renders sum as a chat message,
+// the date followed by the selected sections,
+// on a single line unless another separator is configured.
+func renderSummary(sum *Summary, opts summaryOptions) string {
+	fields := opts.fields
+	if len(fields) == 0 {
+		fields = defaultSections
+	}
+	parts := []string{formatDate(sum.Date, opts.dateFormat)}
+	if sum.Context != "" {
+		parts = append(parts, fmt.Sprintf(opts.label("context"), sum.Context))
+		if !sum.contextKnown {
+			parts = append(parts, opts.label("contextUnknown"))
+			return strings.Join(parts, opts.sectionSep)
+		}
+	}
+	var rendered []renderedSection
+	for _, name := range fields {
+		if out := sections[name](sum, opts); out != "" {
+			rendered = append(rendered, renderedSection{name, out})
+		}
+	}
+	if opts.warnings && len(sum.Warnings) > 0 {
+		rendered = append(rendered, renderedSection{"warnings", "⚠️ " + strings.Join(sum.warningTexts(opts), "; ")})
+	}
+	if opts.maxChars > 0 {
+		return withinBudget(parts, rendered, opts.sectionSep, opts.maxChars)
+	}
+	for _, r := range rendered {
+		parts = append(parts, r.text)
+	}
+	return strings.Join(parts, opts.sectionSep)
+}
+
+// bounds of ?maxChars=, enough for the date and a section,
+// and no more than a chat message
+const (
+	minMaxChars = 50
+	maxMaxChars = 4096
+)
+
+type renderedSection struct {
+	name string
+	text string
+}
+
+// sectionPriority orders sections kept first under ?maxChars=,
+// unlisted ones and warnings after them in their requested order.
+var sectionPriority = []string{"plays", "top", "tracks", "time", "alltime", "groups", "members", "record", "anomaly", "goal", "days", "daytops", "bars", "session", "peakhour", "consistency", "busiest", "streak", "milestone", "skips", "discovery", "onthisday", "rising", "podcasts", "achievements", "duo", "binge", "quarters"}
+
+// withinBudget joins head and as many sections as fit in maxChars characters,
+// taking them by sectionPriority and stopping at the first that doesn't fit,
+// shown in their requested order followed by …(+N more) for those left out.
+// head is always kept.
+func withinBudget(head []string, rendered []renderedSection, sep string, maxChars int) string {
+	rank := make(map[string]int, len(sectionPriority))
+	for i, name := range sectionPriority {
+		rank[name] = i
+	}
+	byPriority := make([]int, len(rendered))
+	for i := range byPriority {
+		byPriority[i] = i
+	}
+	sort.SliceStable(byPriority, func(i, j int) bool {
+		ri, ok := rank[rendered[byPriority[i]].name]
+		if !ok {
+			ri = len(sectionPriority)
+		}
+		rj, ok := rank[rendered[byPriority[j]].name]
+		if !ok {
+			rj = len(sectionPriority)
+		}
+		return ri < rj
+	})
+
+	sepLen := utf8.RuneCountInString(sep)
+	used := utf8.RuneCountInString(strings.Join(head, sep))
+	keep := make([]bool, len(rendered))
+	var kept int
+	for n, i := range byPriority {
+		cost := sepLen + utf8.RuneCountInString(rendered[i].text)
+		if left := len(rendered) - n - 1; left > 0 {
+			// room for the note, shorter if later sections fit too
+			cost += sepLen + utf8.RuneCountInString(moreNote(left))
+		}
+		if used+cost > maxChars {
+			break
+		}
+		used += sepLen + utf8.RuneCountInString(rendered[i].text)
+		keep[i] = true
+		kept++
+	}
+
+	parts := head
+	for i, r := range rendered {
+		if keep[i] {
+			parts = append(parts, r.text)
+		}
+	}
+	if left := len(rendered) - kept; left > 0 {
+		parts = append(parts, moreNote(left))
+	}
+	return strings.Join(parts, sep)
+}
+
+func moreNote(n int) string {
+	return fmt.Sprintf("…(+%d more)", n)
+}
+
+const (
+	precisionPrecise = "precise"
+	precisionMinute  = "minute"
+	precisionQuarter = "quarter"
+)
+
+// formatDuration formats d at the given precision,
+// dropping zero trailing units:
+// precise 2h37m14s, minute 2h37m, quarter ~2h30m.
+func formatDuration(d time.Duration, precision string) string {
+	var prefix string
+	switch precision {
+	case precisionPrecise:
+		d = d.Round(time.Second)
+		if d < time.Second {
+			return "0s"
+		}
+		return trimDuration(d.String())
+	case precisionQuarter:
+		prefix = "~"
+		d = d.Round(15 * time.Minute)
+	default:
+		d = d.Round(time.Minute)
+	}
+	if d < time.Minute {
+		return prefix + "0m"
+	}
+	return prefix + trimDuration(strings.TrimSuffix(d.String(), "0s"))
+}
+
+// trimDuration drops zero minutes and seconds from a formatted duration.
+func trimDuration(s string) string {
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// prevYear is the year before the one starting date (2006-01-02).
+func prevYear(date string) string {
+	if len(date) < 4 {
+		return ""
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return ""
+	}
+	return strconv.Itoa(year - 1)
+}
+
+// formatDate renders a date (2006-01-02) with layout,
+// other window labels are returned as is.
+func formatDate(date, layout string) string {
+	d, err := time.Parse(dateLayout, date)
+	if err != nil || layout == "" {
+		return date
+	}
+	return d.Format(layout)
+}
+
+const (
+	numbersPlain  = "plain"
+	numbersComma  = "comma"
+	numbersPeriod = "period"
+	numbersSpace  = "space"
+)
+
+// thousandsSeparators group digits for each earbug.numbers style.
+var thousandsSeparators = map[string]string{
+	numbersPlain:  "",
+	numbersComma:  ",",
+	numbersPeriod: ".",
+	numbersSpace:  "\u202f",
+}
+
+// formatPercent formats part of whole as a percentage with precision decimals,
+// rounding halves away from zero.
+func formatPercent(part, whole, precision int) string {
+	if whole == 0 {
+		return strconv.FormatFloat(0, 'f', precision, 64)
+	}
+	scale := math.Pow10(precision)
+	pct := math.Round(float64(part)*100*scale/float64(whole)) / scale
+	return strconv.FormatFloat(pct, 'f', precision, 64)
+}
+
+// formatRatio formats r, a fraction of 1, as a percentage like formatPercent.
+func formatRatio(r float64, precision int) string {
+	scale := math.Pow10(precision)
+	return strconv.FormatFloat(math.Round(r*100*scale)/scale, 'f', precision, 64)
+}
+
+const durationUnitAlbums = "albums"
+
+// albumsWorth is how many albums of albumLength fit in d.
+func albumsWorth(d, albumLength time.Duration) float64 {
+	if albumLength <= 0 {
+		return 0
+	}
+	return float64(d) / float64(albumLength)
+}
+
+// formatListened formats listening time in the requested ?durationUnit=,
+// as a duration by default or as ≈ albums to one decimal.
+func formatListened(d time.Duration, opts summaryOptions) string {
+	if opts.durationUnit != durationUnitAlbums {
+		return formatDuration(d, opts.durationPrecision)
+	}
+	n := strconv.FormatFloat(math.Round(albumsWorth(d, opts.albumLength)*10)/10, 'f', -1, 64)
+	return fmt.Sprintf(opts.label("albums"), n)
+}
+
+// formatCount formats n with sep between groups of thousands.
+func formatCount(n int, sep string) string {
+	s := strconv.Itoa(n)
+	if sep == "" {
+		return s
+	}
+	var sign string
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatDelta formats a change in a count with its sign.
+func formatDelta(n int, sep string) string {
+	if n < 0 {
+		return formatCount(n, sep)
+	}
+	return "+" + formatCount(n, sep)
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders vals scaled to the largest value,
+// all zero values render as the lowest block.
+func sparkline(vals []int) string {
+	var max int
+	for _, v := range vals {
+		if v > max {
+			max = v
+		}
+	}
+	out := make([]rune, len(vals))
+	for i, v := range vals {
+		var level int
+		if max > 0 {
+			level = v * (len(sparkBlocks) - 1) / max
+		}
+		out[i] = sparkBlocks[level]
+	}
+	return string(out)
+}
+
+// barLengths scales vals to bars of at most width,
+// any non zero value gets at least one unit.
+func barLengths(vals []int, width int) []int {
+	var max int
+	for _, v := range vals {
+		if v > max {
+			max = v
+		}
+	}
+	out := make([]int, len(vals))
+	if max == 0 {
+		return out
+	}
+	for i, v := range vals {
+		out[i] = v * width / max
+		if v > 0 && out[i] == 0 {
+			out[i] = 1
+		}
+	}
+	return out
+}
+
+const (
+	artistsAll     = "all"
+	artistsPrimary = "primary"
+)
+
+// formatArtists renders the artists of a track,
+// all joined by the separator or only the first.
+func formatArtists(artists []string, opts summaryOptions) string {
+	if opts.artists == artistsPrimary && len(artists) > 0 {
+		return artists[0]
+	}
+	return strings.Join(artists, opts.artistSep)
+}
+
+// formatMovement describes a move to rank from prior, 0 for unranked.
+func formatMovement(rank, prior int) string {
+	switch {
+	case prior == 0:
+		return "(new)"
+	case prior > rank:
+		return fmt.Sprintf("(↑ from %v)", prior)
+	case prior < rank:
+		return fmt.Sprintf("(↓ from %v)", prior)
+	}
+	return "(=)"
+}
+
+// formatTrackList renders ranked tracks as 1. Name — Artist (plays).
+func formatTrackList(tracks []TrackCount, opts summaryOptions) string {
+	parts := make([]string, 0, len(tracks))
+	for i, t := range tracks {
+		name := t.Name
+		if len(t.Artists) > 0 {
+			name += " — " + formatArtists(t.Artists, opts)
+		}
+		counts := formatCount(t.Plays, opts.thousands)
+		if t.Completion != nil {
+			counts += fmt.Sprintf(opts.label("completion"), formatRatio(*t.Completion, opts.percentPrecision))
+		}
+		part := fmt.Sprintf("%v. %s (%s)", i+1, name, counts)
+		if t.PriorRank != nil {
+			part += " " + formatMovement(i+1, *t.PriorRank)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
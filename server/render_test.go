@@ -0,0 +1,323 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWithinBudget(t *testing.T) {
+	head := []string{"2024-03-14"}
+	rendered := []renderedSection{
+		{"plays", "5 plays"},
+		{"tracks", "3 tracks"},
+		{"time", "9m listened"},
+	}
+	tests := []struct {
+		maxChars int
+		want     string
+	}{
+		// everything, exactly at the limit
+		{45, "2024-03-14 | 5 plays | 3 tracks | 9m listened"},
+		// one short of the last section, the note takes its place exactly
+		{44, "2024-03-14 | 5 plays | 3 tracks | …(+1 more)"},
+		// one short of the note after the second section
+		{43, "2024-03-14 | 5 plays | …(+2 more)"},
+		{33, "2024-03-14 | 5 plays | …(+2 more)"},
+		// not even the first section, head is always kept
+		{32, "2024-03-14 | …(+3 more)"},
+		{1, "2024-03-14 | …(+3 more)"},
+	}
+	for _, tt := range tests {
+		got := withinBudget(head, rendered, " | ", tt.maxChars)
+		if got != tt.want {
+			t.Errorf("%d: got %q, want %q", tt.maxChars, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.maxChars && tt.maxChars > 32 {
+			t.Errorf("%d: %d characters", tt.maxChars, n)
+		}
+	}
+}
+
+func TestWithinBudgetPriority(t *testing.T) {
+	head := []string{"2024-03-14"}
+	// requested order kept, priority picks which stay
+	rendered := []renderedSection{
+		{"duo", "most heard duo: A × B (2 plays)"},
+		{"plays", "5 plays"},
+		{"tracks", "3 tracks"},
+	}
+	got := withinBudget(head, rendered, " | ", 44)
+	want := "2024-03-14 | 5 plays | 3 tracks | …(+1 more)"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMaxCharsIncludesDecoration(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &postRecorder{}
+	s := newTestServer(t, store, n, map[string]string{
+		"earbug.post.prefix": "[staging]",
+		"earbug.post.suffix": "sent by earbug",
+	})
+	rw := serve(s, http.MethodPost, "/summary?user=alice&maxChars=80", "", nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rw.Code, rw.Body)
+	}
+	posts := n.texts()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts", len(posts))
+	}
+	if got := utf8.RuneCountInString(posts[0]); got > 80 {
+		t.Errorf("posted %d characters, over 80: %q", got, posts[0])
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d         time.Duration
+		precision string
+		want      string
+	}{
+		{2*time.Hour + 37*time.Minute + 14*time.Second, precisionPrecise, "2h37m14s"},
+		{2*time.Hour + 37*time.Minute + 14*time.Second, precisionMinute, "2h37m"},
+		{2*time.Hour + 37*time.Minute + 14*time.Second, precisionQuarter, "~2h30m"},
+		{2*time.Hour + 37*time.Minute + 30*time.Second, precisionQuarter, "~2h45m"},
+		{2 * time.Hour, precisionPrecise, "2h"},
+		{2 * time.Hour, precisionMinute, "2h"},
+		{2 * time.Hour, precisionQuarter, "~2h"},
+		{5*time.Minute + 29*time.Second, precisionMinute, "5m"},
+		{5*time.Minute + 30*time.Second, precisionMinute, "6m"},
+		// sub-minute totals
+		{45 * time.Second, precisionPrecise, "45s"},
+		{400 * time.Millisecond, precisionPrecise, "0s"},
+		{29 * time.Second, precisionMinute, "0m"},
+		{30 * time.Second, precisionMinute, "1m"},
+		{59 * time.Second, precisionQuarter, "~0m"},
+		{0, precisionPrecise, "0s"},
+		{0, precisionMinute, "0m"},
+		{0, precisionQuarter, "~0m"},
+		// minute is the default
+		{90 * time.Second, "", "2m"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d, tt.precision); got != tt.want {
+			t.Errorf("formatDuration(%v, %q) = %q, want %q", tt.d, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestDurationPrecisionOption(t *testing.T) {
+	for _, v := range []string{precisionPrecise, precisionMinute, precisionQuarter} {
+		opts, err := parseSummaryOptions(url.Values{"durationPrecision": {v}})
+		if err != nil || opts.durationPrecision != v {
+			t.Errorf("%s: got %q, %v", v, opts.durationPrecision, err)
+		}
+	}
+	opts, _ := parseSummaryOptions(url.Values{})
+	if opts.durationPrecision != precisionMinute {
+		t.Errorf("default %q, want %s", opts.durationPrecision, precisionMinute)
+	}
+	if _, err := parseSummaryOptions(url.Values{"durationPrecision": {"hour"}}); err == nil {
+		t.Error("accepted unknown precision")
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		n     int
+		style string
+		want  string
+	}{
+		{999, numbersComma, "999"},
+		{1000, numbersComma, "1,000"},
+		{-999, numbersComma, "-999"},
+		{-1000, numbersComma, "-1,000"},
+		{999999, numbersComma, "999,999"},
+		{1000000, numbersComma, "1,000,000"},
+		{48213, numbersComma, "48,213"},
+		{1000, numbersPeriod, "1.000"},
+		{1000, numbersSpace, "1\u202f000"},
+		{999, numbersPlain, "999"},
+		{1000, numbersPlain, "1000"},
+		{0, numbersComma, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatCount(tt.n, thousandsSeparators[tt.style]); got != tt.want {
+			t.Errorf("formatCount(%d, %s) = %q, want %q", tt.n, tt.style, got, tt.want)
+		}
+	}
+	if got := formatDelta(1000, ","); got != "+1,000" {
+		t.Errorf("formatDelta(1000) = %q", got)
+	}
+	if got := formatDelta(-1000, ","); got != "-1,000" {
+		t.Errorf("formatDelta(-1000) = %q", got)
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	tests := []struct {
+		part, whole, precision int
+		want                   string
+	}{
+		// halves round away from zero
+		{1, 8, 0, "13"},  // 12.5
+		{3, 8, 0, "38"},  // 37.5
+		{1, 200, 0, "1"}, // 0.5
+		{-1, 8, 0, "-13"},
+		{1, 16, 1, "6.3"},  // 6.25
+		{3, 16, 1, "18.8"}, // 18.75
+		{1, 400, 1, "0.3"}, // 0.25
+		// either side of the boundary
+		{124, 1000, 0, "12"},
+		{126, 1000, 0, "13"},
+		{1, 3, 0, "33"},
+		{2, 3, 0, "67"},
+		{2, 3, 1, "66.7"},
+		{1, 1, 0, "100"},
+		{1, 1, 1, "100.0"},
+		{0, 0, 0, "0"},
+		{0, 0, 1, "0.0"},
+	}
+	for _, tt := range tests {
+		if got := formatPercent(tt.part, tt.whole, tt.precision); got != tt.want {
+			t.Errorf("formatPercent(%d, %d, %d) = %q, want %q", tt.part, tt.whole, tt.precision, got, tt.want)
+		}
+	}
+	for r, want := range map[float64]string{0.125: "13", 0.005: "1", 0.0625: "6"} {
+		if got := formatRatio(r, 0); got != want {
+			t.Errorf("formatRatio(%v, 0) = %q, want %q", r, got, want)
+		}
+	}
+	if got := formatRatio(0.0625, 1); got != "6.3" {
+		t.Errorf("formatRatio(0.0625, 1) = %q", got)
+	}
+}
+
+func TestAlbumsWorth(t *testing.T) {
+	tests := []struct {
+		d, album time.Duration
+		want     float64
+	}{
+		{270 * time.Minute, 45 * time.Minute, 6},
+		{90 * time.Minute, 45 * time.Minute, 2},
+		{30 * time.Minute, 45 * time.Minute, 2.0 / 3},
+		{0, 45 * time.Minute, 0},
+		{time.Hour, time.Hour, 1},
+		{time.Hour, 0, 0},
+		{time.Hour, -time.Minute, 0},
+	}
+	for _, tt := range tests {
+		if got := albumsWorth(tt.d, tt.album); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("albumsWorth(%v, %v) = %v, want %v", tt.d, tt.album, got, tt.want)
+		}
+	}
+}
+
+func TestFormatListenedAlbums(t *testing.T) {
+	opts, err := parseSummaryOptions(url.Values{"durationUnit": {durationUnitAlbums}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.albumLength = 45 * time.Minute
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{270 * time.Minute, "≈ 6 albums"},
+		{300 * time.Minute, "≈ 6.7 albums"},
+		{47 * time.Minute, "≈ 1 albums"},
+		{49 * time.Minute, "≈ 1.1 albums"},
+		{0, "≈ 0 albums"},
+	}
+	for _, tt := range tests {
+		if got := formatListened(tt.d, opts); got != tt.want {
+			t.Errorf("formatListened(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+	// plain durations by default
+	opts.durationUnit = ""
+	if got := formatListened(150*time.Minute, opts); got != "2h30m" {
+		t.Errorf("default unit got %q", got)
+	}
+}
+
+func TestTranslatedWeekdays(t *testing.T) {
+	sum := &Summary{
+		Date: "2024-03-11..2024-03-12",
+		Days: []DayCount{
+			{Date: "2024-03-11", Plays: 2, Top: &TrackCount{Name: "Alpha", Plays: 2}},
+			{Date: "2024-03-12", Plays: 1, Top: &TrackCount{Name: "Beta", Plays: 1}},
+		},
+		Anomaly: &Anomaly{PriorDate: "2024-03-10", PriorPlays: 10, Change: 2.5},
+	}
+	tests := []struct {
+		lang                         string
+		days, bars, daytops, anomaly string
+	}{
+		{"", "Mon 2, Tue 1", "Mon ██ 2 Tue █ 1", "Mon: Alpha (2x), Tue: Beta (1x)", "⚠️ 2.5x plays vs 2024-03-10 (10)"},
+		{"es", "lun 2, mar 1", "lun ██ 2 mar █ 1", "lun: Alpha (2x), mar: Beta (1x)", "⚠️ 2.5x reproducciones vs el 2024-03-10 (10)"},
+		{"de", "Mo 2, Di 1", "Mo ██ 2 Di █ 1", "Mo: Alpha (2x), Di: Beta (1x)", "⚠️ 2.5x Wiedergaben ggü. 2024-03-10 (10)"},
+	}
+	for _, tt := range tests {
+		opts, err := parseSummaryOptions(url.Values{"lang": {tt.lang}, "barWidth": {"2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]string{"days": tt.days, "bars": tt.bars, "daytops": tt.daytops, "anomaly": tt.anomaly} {
+			if got := sections[name](sum, opts); got != want {
+				t.Errorf("%q %s: got %q, want %q", tt.lang, name, got, want)
+			}
+		}
+	}
+}
+
+func TestTranslatedNotes(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(map[string][]string{
+		"t1": {"2024-03-13T08:00:00Z", "2024-03-14T08:00:00Z"},
+		"t9": {"2024-03-14T09:00:00Z"},
+	}))
+	s := newTestServer(t, store, nil, map[string]string{"earbug.posting.enabled": "false"})
+	for target, want := range map[string][]string{
+		"/summary?user=alice&fields=plays&lang=es":                         {"1 reproducciones de canciones sin metadatos"},
+		"/summary?user=alice&fields=plays&context=spotify:album:a&lang=de": {"aus spotify:album:a", "keine Wiedergabe in diesem Zeitraum"},
+		"/diff?user=alice&a=2024-03-13&b=2024-03-14&lang=de":               {"2024-03-14 ggü. 2024-03-13", "nur am 2024-03-14: t9"},
+		"/summary/artist?user=alice&artist=ann&lang=es":                    {"reproducciones de 1 canciones", "canciones: "},
+	} {
+		method := http.MethodGet
+		if strings.HasPrefix(target, "/summary?") {
+			method = http.MethodPost
+		}
+		rw := serve(s, method, target, "", nil)
+		for _, w := range want {
+			if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), w) {
+				t.Errorf("%s: got %d %q, want %q", target, rw.Code, rw.Body.String(), w)
+			}
+		}
+	}
+}
+
+func TestDefaultSections(t *testing.T) {
+	sum := &Summary{
+		Date:  "2024-03-14",
+		Plays: 2,
+		Top:   []TrackCount{{ID: "t1", Name: "Alpha", Plays: 2}},
+		Days:  []DayCount{{Date: "2024-03-14", Plays: 2}},
+	}
+	opts, _ := parseSummaryOptions(url.Values{"warnings": {"false"}})
+	got := renderSummary(sum, opts)
+	if !strings.Contains(got, "top: 1. Alpha (2)") || strings.Contains(got, "█") {
+		t.Errorf("got %q, want top tracks without bars", got)
+	}
+	opts, _ = parseSummaryOptions(url.Values{"fields": {"plays,bars"}})
+	if got := renderSummary(sum, opts); strings.Contains(got, "top:") || !strings.Contains(got, "█") {
+		t.Errorf("requested fields: got %q, want bars without top tracks", got)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// resolveMax bounds the tracks looked up per store read,
+// the rest are looked up on later reads.
+const resolveMax = 100
+
+// resolvedTrack is the response of earbug.resolver.url for a track id.
+type resolvedTrack struct {
+	Name    string `json:"name"`
+	Artists []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artists"`
+}
+
+// resolverCache holds tracks looked up with earbug.resolver.url,
+// nil for ids the resolver doesn't know.
+type resolverCache struct {
+	mu     sync.Mutex
+	tracks map[string]resolvedEntry
+}
+
+type resolvedEntry struct {
+	track   *earbugv3.Track
+	fetched time.Time
+}
+
+// get returns the cached track for id.
+// Ids the resolver didn't know are looked up again once fetched before unknownCutoff.
+func (c *resolverCache) get(id string, unknownCutoff time.Time) (*earbugv3.Track, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.tracks[id]
+	if ok && e.track == nil && e.fetched.Before(unknownCutoff) {
+		return nil, false
+	}
+	return e.track, ok
+}
+
+func (c *resolverCache) put(id string, t *earbugv3.Track, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tracks == nil {
+		c.tracks = make(map[string]resolvedEntry)
+	}
+	c.tracks[id] = resolvedEntry{t, now}
+}
+
+// evict drops tracks fetched before cutoff,
+// and unknown ids before unknownCutoff, returning how many it dropped.
+func (c *resolverCache) evict(cutoff, unknownCutoff time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for id, e := range c.tracks {
+		if e.fetched.Before(cutoff) || e.track == nil && e.fetched.Before(unknownCutoff) {
+			delete(c.tracks, id)
+			n++
+		}
+	}
+	return n
+}
+
+// resolveTracks adds tracks played in data but missing metadata
+// from earbug.resolver.url, all within earbug.resolver.timeout.
+// Failures are only logged, unresolved tracks keep showing as their id.
+func (s *Server) resolveTracks(ctx context.Context, data *earbugv3.Store) {
+	ctx, span := s.trace.Start(ctx, "resolve-tracks")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, s.resolverTimeout)
+	defer cancel()
+
+	if data.Tracks == nil {
+		data.Tracks = make(map[string]*earbugv3.Track)
+	}
+	var resolved, looked int
+	for _, played := range data.Playbacks {
+		id := played.TrackId
+		if id == "" || data.Tracks[id].GetName() != "" {
+			continue
+		}
+		t, ok := s.resolved.get(id, time.Now().Add(-s.resolverUnknown))
+		if !ok {
+			if looked == resolveMax {
+				continue
+			}
+			looked++
+			var err error
+			t, err = s.resolveTrack(ctx, id)
+			if err != nil {
+				s.log.Error(err, "resolve track", "track", id)
+				if ctx.Err() != nil {
+					break
+				}
+				continue
+			}
+			s.resolved.put(id, t, time.Now())
+		}
+		if t != nil {
+			data.Tracks[id] = t
+			resolved++
+		}
+	}
+	if looked > 0 {
+		s.log.V(1).Info("resolved tracks", "looked_up", looked, "resolved", resolved)
+	}
+}
+
+// resolveTrack looks up a single track, nil if the resolver doesn't know it.
+func (s *Server) resolveTrack(ctx context.Context, id string) (*earbugv3.Track, error) {
+	u := *s.resolverURL
+	q := u.Query()
+	q.Set("id", id)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := s.resolverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	var rt resolvedTrack
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&rt)
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if rt.Name == "" {
+		return nil, nil
+	}
+	t := &earbugv3.Track{Id: id, Name: rt.Name}
+	for _, a := range rt.Artists {
+		t.Artists = append(t.Artists, &earbugv3.Artist{Id: a.ID, Name: a.Name})
+	}
+	return t, nil
+}
+
+// parseResolverURL validates earbug.resolver.url.
+func parseResolverURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute http(s) url", raw)
+	}
+	return u, nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+func TestResolverCacheExpiry(t *testing.T) {
+	fetched := time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC)
+	var c resolverCache
+	c.put("t1", &earbugv3.Track{Id: "t1", Name: "Alpha"}, fetched)
+	c.put("t9", nil, fetched)
+
+	if _, ok := c.get("t9", fetched); !ok {
+		t.Errorf("unknown id missing before its cutoff")
+	}
+	later := fetched.Add(time.Minute)
+	if _, ok := c.get("t9", later); ok {
+		t.Errorf("unknown id cached past its cutoff")
+	}
+	if tr, ok := c.get("t1", later); !ok || tr.GetName() != "Alpha" {
+		t.Errorf("got %v %v, want known track kept", tr, ok)
+	}
+
+	if n := c.evict(fetched, later); n != 1 {
+		t.Errorf("evicted %d, want the unknown id", n)
+	}
+	if n := c.evict(later, later); n != 1 || len(c.tracks) != 0 {
+		t.Errorf("evicted %d, %d left, want the known track", n, len(c.tracks))
+	}
+}
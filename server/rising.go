@@ -0,0 +1,100 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+const (
+	risingTopN = 3
+	// risingPriorWeeks is how many weeks before the last one are averaged
+	risingPriorWeeks = 4
+	// risingMinPlays is the fewest plays in the last week to count as rising
+	risingMinPlays = 3
+)
+
+// RisingTrack is a track played much more in the last week
+// than in the weeks before.
+type RisingTrack struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Prior  int    `json:"prior"`
+	Recent int    `json:"recent"`
+}
+
+// risingCounts tallies per track plays in the week ending on a date
+// and in the weeks before.
+type risingCounts struct {
+	recentFrom string
+	priorFrom  string
+	to         string
+	recent     map[string]int
+	prior      map[string]int
+}
+
+func newRisingCounts(to string) *risingCounts {
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return nil
+	}
+	return &risingCounts{
+		recentFrom: end.AddDate(0, 0, -6).Format(dateLayout),
+		priorFrom:  end.AddDate(0, 0, -6-7*risingPriorWeeks).Format(dateLayout),
+		to:         to,
+		recent:     make(map[string]int),
+		prior:      make(map[string]int),
+	}
+}
+
+func (c *risingCounts) add(day, trackID string) {
+	switch {
+	case day > c.to || day < c.priorFrom:
+	case day >= c.recentFrom:
+		c.recent[trackID]++
+	default:
+		c.prior[trackID]++
+	}
+}
+
+// rising returns tracks already played in the prior weeks
+// whose plays in the last week are at least factor times their weekly average,
+// most increased first, keeping at most n.
+func (c *risingCounts) rising(data *earbugv3.Store, factor float64, n int) []RisingTrack {
+	type candidate struct {
+		RisingTrack
+		ratio float64
+	}
+	var cands []candidate
+	for id, recent := range c.recent {
+		prior := float64(c.prior[id]) / risingPriorWeeks
+		if prior == 0 || recent < risingMinPlays || float64(recent) < prior*factor {
+			continue
+		}
+		cands = append(cands, candidate{
+			RisingTrack: RisingTrack{
+				ID:     id,
+				Name:   trackName(data, id),
+				Prior:  int(math.Round(prior)),
+				Recent: recent,
+			},
+			ratio: float64(recent) / prior,
+		})
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].ratio != cands[j].ratio {
+			return cands[i].ratio > cands[j].ratio
+		}
+		return cands[i].ID < cands[j].ID
+	})
+	if len(cands) > n {
+		cands = cands[:n]
+	}
+	out := make([]RisingTrack, 0, len(cands))
+	for _, c := range cands {
+		out = append(out, c.RisingTrack)
+	}
+	return out
+}
@@ -7,178 +7,549 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"cloud.google.com/go/storage"
 	"github.com/go-logr/logr"
-	"github.com/klauspost/compress/zstd"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
 	"go.opentelemetry.io/otel/trace"
-	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
 	"go.seankhliao.com/gchat"
 	"go.seankhliao.com/svcrunner"
 	"go.seankhliao.com/svcrunner/envflag"
-	"google.golang.org/protobuf/proto"
+	"golang.org/x/sync/singleflight"
 )
 
 type Server struct {
-	bucket string
+	bucket            string
+	manifest          string
+	tenantsFile       string
+	configFile        string
+	configStrict      bool
+	timezone          string
+	webhookOverride   bool
+	webhookHostCheck  bool
+	storeURLHostsFlag string
+	storeURLTimeout   time.Duration
+	sinkPath          string
+	sessionGap        time.Duration
+	framing           string
+	recoverTruncated  bool
+	metadataObject    string
+	metadataRefresh   time.Duration
+	cacheMaxAge       time.Duration
+	resolverURLFlag   string
+	resolverTimeout   time.Duration
+	resolverUnknown   time.Duration
+	podcasts          string
+	missingMetadata   string
+	emptySkip         bool
+	emptyStatus       int
+	maxList           int
+	allTime           bool
+	checkConfig       bool
+	checkConfigPing   bool
+	anomaly           bool
+	anomalyFactor     float64
+	debugTiming       bool
+	posting           bool
+	gchatMode         string
+	gchatSpace        string
+	sender            sender
+	postPrefix        string
+	postSuffix        string
+	postErrors        bool
+	errorsWebhook     string
+	rateLimitRate     float64
+	rateLimitBurst    int
+	trustProxy        bool
+	ui                bool
+	idempotencyTTL    time.Duration
+	dedupeWindow      time.Duration
+	goalNewTracks     int
+	milestoneStep     int
+	busiestWeeks      int
+	streakToday       string
+	duoMaxArtists     int
+	numbers           string
+	percentPrecision  int
+	albumLength       time.Duration
+	separator         string
+	risingFactor      float64
+	bingeTracks       int
+	skipThreshold     float64
+	achievements      bool
+	traceWarnings     bool
+	zeroDurationPlays bool
+	freshThreshold    time.Duration
+	dateFormat        string
+	weekStartFlag     string
+	breakerFailures   int
+	breakerCooldown   time.Duration
+	catchUpDays       int
+	batchMaxUsers     int
+	reloadToken       string
+	maxPlaybacks      int
+	onceUser          string
+	onceQuery         string
+	oncePost          bool
+	userGroupsFlag    string
+	groupDedupe       bool
+	catchUpWait       time.Duration
+	scheduleJitter    time.Duration
+	jitterFixed       bool
+	excludeTracks     string
+	glanceEmojiFlag   string
+	httpTimeouts      httpTimeouts
 
-	bkt   *storage.BucketHandle
-	gchat gchat.WebhookClient
+	storeMu  sync.Mutex
+	store    ObjectStore
+	notifier Notifier
+	gchat    gchat.WebhookClient
+	chatAPI  *chatAPIClient
+	sink     *fileSink
+	flags    flagRegistry
+	// reloaded is the config /reload replaces
+	reloaded atomic.Pointer[reloadable]
+	reloadMu sync.Mutex
+	// resolverURL looks up tracks without metadata, nil if disabled
+	resolverURL    *url.URL
+	resolverClient *http.Client
+
+	allTimeTop  allTimeCache
+	resolved    resolverCache
+	limiter     rateLimiter
+	idempotency idempotencyCache
+	lastPosted  lastPosts
+	recentPosts recentPosts
+	breaker     breaker
+	loads       singleflight.Group
+	loc         *time.Location
+	weekStart   time.Weekday
+
+	hs *http.Server
+	// clock is the current time for picking summary windows
+	clock       func() time.Time
+	excluded    map[string]struct{}
+	glanceEmoji map[string]string
+	userGroups  map[string][]string
+	// storeURLHosts are the hosts presigned store urls may point at
+	storeURLHosts  map[string]struct{}
+	storeURLClient *http.Client
 
 	log   logr.Logger
 	trace trace.Tracer
+
+	storeBytes instrument.Int64Histogram
+	// schemaAhead is set once unknown fields have been seen in a store
+	schemaAhead atomic.Bool
 }
 
 func New(hs *http.Server) *Server {
-	s := &Server{}
+	s := &Server{
+		hs:    hs,
+		clock: time.Now,
+	}
+	s.reloaded.Store(&reloadable{metadata: &metadataCache{}})
 	mux := http.NewServeMux()
-	mux.HandleFunc("/summary", s.summary)
-	hs.Handler = mux
+	mux.HandleFunc("/", s.index)
+	mux.HandleFunc("/summary", s.idempotent(s.summary))
+	mux.HandleFunc("/summary/all", s.idempotent(s.summaryAll))
+	mux.HandleFunc("/summary/isoweek", s.idempotent(s.summaryISOWeek))
+	mux.HandleFunc("/summary/group", s.idempotent(s.summaryGroup))
+	mux.HandleFunc("/summary/artist", s.summaryArtist)
+	mux.HandleFunc("/sparkline", s.sparkline)
+	mux.HandleFunc("/heatmap", s.heatmap)
+	mux.HandleFunc("/diff", s.diff)
+	mux.HandleFunc("/history", s.history)
+	mux.HandleFunc("/export/metadata", s.exportMetadata)
+	mux.HandleFunc("/cursor", s.cursor)
+	mux.HandleFunc("/last", s.last)
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/reload", s.reload)
+	hs.Handler = s.accessLog(s.rateLimit(s.withTenant(mux)))
 	return s
 }
 
-func (s *Server) Register(c *envflag.Config) {
+// SetClock replaces the current time used to pick summary windows,
+// time.Now by default.
+func (s *Server) SetClock(now func() time.Time) {
+	s.clock = now
+}
+
+func (s *Server) Register(ec *envflag.Config) {
+	c := s.flags.wrap(ec)
+	c.StringVar(&s.configFile, "earbug.config", "", "json file setting flags by name and default summary query parameters, flags set otherwise take precedence")
+	c.BoolVar(&s.configStrict, "earbug.config.strict", false, "fail on unknown keys in earbug.config instead of ignoring them")
 	c.StringVar(&s.gchat.Endpoint, "earbug.gchat", "", "webhook for google chat space to post summaries")
-	c.StringVar(&s.bucket, "earbug.bucket", "", "storage bucket to read user data from")
+	c.StringVar(&s.gchatMode, "earbug.gchat.mode", gchatModeWebhook, "how to post to google chat: webhook or api (as a chat app with the service account)")
+	c.StringVar(&s.gchatSpace, "earbug.gchat.space", "", "space to post to in api mode, as spaces/ID")
+	c.StringVar(&s.sender.name, "earbug.gchat.sendername", "", "name shown in a header on posted messages, e.g. Earbug Bot, the webhook's own identity if unset")
+	c.StringVar(&s.sender.avatar, "earbug.gchat.avatar", "", "https url of an icon shown with earbug.gchat.sendername")
+	c.StringVar(&s.postPrefix, "earbug.post.prefix", "", "text before every posted message, e.g. [staging]")
+	c.StringVar(&s.postSuffix, "earbug.post.suffix", "", "footer line after every posted message")
+	c.BoolVar(&s.postErrors, "earbug.gchat.posterrors", false, "post a notice to chat when a summary fails")
+	c.StringVar(&s.errorsWebhook, "earbug.gchat.errors", "", "webhook for failure notices, defaults to the summary space")
+	c.StringVar(&s.bucket, "earbug.bucket", "", "storage bucket to read user data from, or a comma separated list of replicas to try in order")
+	c.StringVar(&s.tenantsFile, "earbug.tenants", "", "json file of tenants, each with a name, bucket and webhook, selected per request with the X-Tenant header, disabled if empty")
+	c.StringVar(&s.manifest, "earbug.manifest", "", "object in bucket listing users for /summary/all, scans the bucket if empty")
+	c.StringVar(&s.timezone, "earbug.timezone", "Local", "time zone defining the summary day")
+	c.StringVar(&s.framing, "earbug.framing", framingSingle, "framing of store objects: single, a Store message, or delimited, a stream of length delimited keyed playbacks in the wire form of Store.playbacks entries, without track metadata")
+	c.BoolVar(&s.recoverTruncated, "earbug.truncated.recover", false, "summarize the complete messages of delimited store objects cut short, e.g. by an interrupted upload, instead of failing")
+	c.StringVar(&s.metadataObject, "earbug.metadata.object", "", "object in bucket with shared track metadata, merged into each user's store")
+	c.DurationVar(&s.metadataRefresh, "earbug.metadata.refresh", time.Hour, "how often to reread the shared metadata object")
+	c.DurationVar(&s.cacheMaxAge, "earbug.cache.maxage", 0, "evict cached all time top tracks, shared metadata and resolved tracks this long after they were stored, however recently used, 0 to keep them")
+	c.StringVar(&s.resolverURLFlag, "earbug.resolver.url", "", "http endpoint returning json {name, artists: [{id, name}]} for ?id= of tracks played without metadata, disabled if empty")
+	c.DurationVar(&s.resolverTimeout, "earbug.resolver.timeout", 2*time.Second, "time allowed for earbug.resolver.url lookups in each store read, unresolved tracks show as their id")
+	c.DurationVar(&s.resolverUnknown, "earbug.resolver.unknown.maxage", 15*time.Minute, "how long ids earbug.resolver.url didn't know are cached before being looked up again")
+	c.StringVar(&s.podcasts, "earbug.podcasts", podcastsExclude, "podcast episodes in summaries: exclude, include (as music), or separate")
+	c.IntVar(&s.maxList, "earbug.maxlist", 5, "default cap on the entries of every ranked list, overridden by ?maxList=, 0 for each list's own length")
+	c.IntVar(&s.emptyStatus, "earbug.empty.status", http.StatusOK, "default status for summaries of windows without plays, overridden by ?emptyStatus=: 200 posts the zero summary, 204 or 404 post nothing")
+	c.BoolVar(&s.emptySkip, "earbug.empty.skip", false, "skip posting for users with no recorded plays instead of posting a notice")
+	c.BoolVar(&s.allTime, "earbug.alltime.enabled", false, "compute the all time top tracks section when requested with ?fields=alltime")
+	c.BoolVar(&s.anomaly, "earbug.anomaly.enabled", false, "keep per user state in the bucket and flag large day to day changes in plays")
+	c.Float64Var(&s.anomalyFactor, "earbug.anomaly.factor", 5, "change in plays from the prior day to flag as an anomaly")
+	c.DurationVar(&s.sessionGap, "earbug.session.gap", 20*time.Minute, "maximum gap between plays in a listening session")
+	c.BoolVar(&s.debugTiming, "earbug.debug.timing", false, "report stage durations in a Server-Timing response header")
+	c.BoolVar(&s.posting, "earbug.posting.enabled", true, "post summaries to chat, when disabled summaries are only returned in the response")
+	c.StringVar(&s.sinkPath, "earbug.sink.file", "", "also append posted summaries as json lines to this file, {date} in the path is replaced by the date, e.g. /var/log/earbug/{date}.jsonl")
+	c.BoolVar(&s.webhookHostCheck, "earbug.gchat.hostcheck", true, "require webhooks to point at "+gchatHost+", disable for custom sinks")
+	c.StringVar(&s.storeURLHostsFlag, "earbug.storeurl.hosts", "", "comma separated hosts a summary request body storeURL may read the store from, e.g. storage.googleapis.com, disabled if empty")
+	c.DurationVar(&s.storeURLTimeout, "earbug.storeurl.timeout", 30*time.Second, "time allowed to read a store from a storeURL")
+	c.BoolVar(&s.checkConfig, "earbug.checkconfig", false, "validate config, bucket access, and optionally webhook reachability, then exit without serving")
+	c.BoolVar(&s.checkConfigPing, "earbug.checkconfig.ping", false, "also check the webhook is reachable in earbug.checkconfig")
+	c.Float64Var(&s.rateLimitRate, "earbug.ratelimit.rate", 0, "requests per second allowed from each client ip, 0 to disable")
+	c.IntVar(&s.rateLimitBurst, "earbug.ratelimit.burst", 10, "requests a client ip can make in a burst above earbug.ratelimit.rate")
+	c.BoolVar(&s.trustProxy, "earbug.trustproxy", false, "identify clients by X-Forwarded-For, only set behind a proxy that appends to it")
+	c.DurationVar(&s.dedupeWindow, "earbug.dedupe.window", 0, "suppress a post identical to the prior post for the same user and date within this long, e.g. 5m for overlapping triggers, 0 to disable")
+	c.DurationVar(&s.idempotencyTTL, "earbug.idempotency.ttl", 24*time.Hour, "how long a completed request's Idempotency-Key is remembered, repeats within it return the prior response without posting, 0 to disable")
+	c.IntVar(&s.milestoneStep, "earbug.milestone.step", 1000, "project when total plays reach the next multiple of this in multi day summaries, e.g. 1000 or 5000, 0 to omit")
+	c.IntVar(&s.busiestWeeks, "earbug.busiest.weeks", 8, "weeks up to the end of monthly summaries to find the busiest weekday over, 0 to omit")
+	c.StringVar(&s.streakToday, "earbug.streak.today", streakOptimistic, "how a current day without plays yet counts for ?fields=streak: optimistic keeps the streak through yesterday, strict ends it")
+	c.IntVar(&s.goalNewTracks, "earbug.goal.newtracks", 0, "new tracks per week to show progress towards in weekly summaries, 0 to omit")
+	c.IntVar(&s.duoMaxArtists, "earbug.duo.maxartists", 5, "artists per track considered when finding the most heard duo")
+	c.DurationVar(&s.albumLength, "earbug.album.length", 45*time.Minute, "length of an album for listening time rendered with ?durationUnit=albums")
+	c.IntVar(&s.percentPrecision, "earbug.percent.precision", 0, "decimals in percentages in messages: 0 for whole numbers or 1")
+	c.StringVar(&s.numbers, "earbug.numbers", numbersComma, "thousands separator for counts in messages: comma, period, space, or plain")
+	c.StringVar(&s.separator, "earbug.sections.separator", separatorPipe, "how sections of text summaries are joined: pipe on one line, or line, blank, rule, or bullet on separate lines; ?fields= sets their order")
+	c.Float64Var(&s.risingFactor, "earbug.rising.factor", 2, "increase in last week's plays over the prior weekly average to flag a track as rising, 0 to disable")
+	c.StringVar(&s.missingMetadata, "earbug.metadata.missing", missingID, "how tracks without metadata show in lists: id, skip, or placeholder (Unknown track), counts always include them")
+	c.BoolVar(&s.zeroDurationPlays, "earbug.plays.zeroduration", true, "count plays of tracks with a zero or unknown duration, e.g. unresolved tracks")
+	c.BoolVar(&s.achievements, "earbug.achievements", true, "celebrate achievements in summaries, e.g. listening every day of a week")
+	c.BoolVar(&s.traceWarnings, "earbug.trace.warnings", false, "also record data quality warnings as events on the summary span, and include its trace and span ids when logging them")
+	c.Float64Var(&s.skipThreshold, "earbug.skip.threshold", 0.5, "share of a track played before the next starts below which it counts as skipped, 0 to disable skip rates")
+	c.IntVar(&s.bingeTracks, "earbug.binge.mintracks", 4, "plays in a row from one album within a session to report as a binge, 0 to disable")
+	c.DurationVar(&s.freshThreshold, "earbug.fresh.threshold", 36*time.Hour, "age of the latest play after which ?requireFresh=true refuses to post")
+	c.StringVar(&s.dateFormat, "earbug.dateformat", dateLayout, "go time layout for dates in messages, e.g. \"Mon, Jan 2\"")
+	c.StringVar(&s.weekStartFlag, "earbug.weekstart", "monday", "first day of the week for weekly summaries and breakdowns: monday or sunday")
+	c.IntVar(&s.breakerFailures, "earbug.breaker.failures", 5, "consecutive bucket read failures before failing reads fast, 0 to disable")
+	c.DurationVar(&s.breakerCooldown, "earbug.breaker.cooldown", 30*time.Second, "time to fail bucket reads fast before trying again")
+	c.StringVar(&s.onceUser, "earbug.once.user", "", "print yesterday's summary for this user to stdout and exit without serving")
+	c.StringVar(&s.onceQuery, "earbug.once.query", "", "summary options for earbug.once.user as a query string, e.g. format=markdown&fields=plays,top")
+	c.BoolVar(&s.oncePost, "earbug.once.post", false, "also post the summary for earbug.once.user")
+	c.IntVar(&s.maxPlaybacks, "earbug.maxplaybacks", 0, "most recent playbacks to read from a store, older ones are dropped with a warning, 0 for no cap")
+	c.StringVar(&s.reloadToken, "earbug.reload.token", "", "bearer token for POST /reload to reread the shared metadata object, earbug.tenants and the summary parameters in earbug.config, disabled if empty")
+	c.IntVar(&s.batchMaxUsers, "earbug.batch.maxusers", 100, "most users a /summary/all body can list")
+	c.StringVar(&s.userGroupsFlag, "earbug.groups", "", "comma separated name=user+user groups for combined summaries at /summary/group?group=name")
+	c.BoolVar(&s.groupDedupe, "earbug.groups.dedupe", true, "count a track played at the same time by several group members once")
+	c.IntVar(&s.catchUpDays, "earbug.catchup.days", 0, "on startup, post up to this many days of summaries missed since each batch user was last summarized, 0 to disable")
+	c.DurationVar(&s.catchUpWait, "earbug.catchup.wait", 5*time.Minute, "how long catch up waits for the bucket to become accessible after startup")
+	c.DurationVar(&s.scheduleJitter, "earbug.schedule.jitter", 0, "delay each user's scheduled catch up by a random amount up to this, e.g. 5m, to spread load when many instances start together")
+	c.BoolVar(&s.jitterFixed, "earbug.schedule.jitter.deterministic", false, "derive each user's scheduled delay from the user and date instead of randomly")
+	c.StringVar(&s.excludeTracks, "earbug.exclude.tracks", "", "comma separated track ids to leave out of all stats")
+	c.StringVar(&s.glanceEmojiFlag, "earbug.glance.emoji", "", "comma separated metric=emoji overrides for ?format=glance, metrics are plays, tracks, new, e.g. plays=🎧")
+	c.BoolVar(&s.ui, "earbug.ui.enabled", false, "serve a form for triggering summaries at /")
+	c.DurationVar(&s.httpTimeouts.readHeader, "earbug.http.readheadertimeout", 10*time.Second, "time allowed to read request headers")
+	c.DurationVar(&s.httpTimeouts.read, "earbug.http.readtimeout", 30*time.Second, "time allowed to read a request")
+	c.DurationVar(&s.httpTimeouts.write, "earbug.http.writetimeout", 5*time.Minute, "time allowed to write a response, bounds slow summaries of long windows")
+	c.DurationVar(&s.httpTimeouts.idle, "earbug.http.idletimeout", 2*time.Minute, "time to keep idle connections open")
+	c.BoolVar(&s.webhookOverride, "earbug.gchat.override", false, "allow overriding the webhook per request with ?webhook= or X-Webhook, for development only")
 }
 
 func (s *Server) Init(ctx context.Context, t svcrunner.Tools) error {
 	s.log = t.Log.WithName("earbug-gchat")
 	s.trace = otel.Tracer("earbug-gchat")
+	s.httpTimeouts.apply(s.hs)
 
-	client, err := storage.NewClient(ctx)
+	var err error
+	s.storeBytes, err = global.Meter("earbug-gchat").Int64Histogram("earbug.store.size",
+		instrument.WithDescription("decompressed size of store objects read"),
+		instrument.WithUnit("By"),
+	)
 	if err != nil {
-		return fmt.Errorf("create storage client: %w", err)
+		return fmt.Errorf("create store size histogram: %w", err)
 	}
 
-	s.bkt = client.Bucket(s.bucket)
-	s.gchat.Client = &http.Client{
-		Transport: otelhttp.NewTransport(nil),
+	err = s.setup(ctx)
+	if s.checkConfig {
+		os.Exit(s.reportConfig(ctx, os.Stdout, err))
 	}
-	return nil
-}
-
-type userReq struct {
-	User string `json:"user"`
+	if s.onceUser != "" {
+		os.Exit(s.runOnce(ctx, os.Stdout, os.Stderr, err))
+	}
+	if err == nil && s.catchUpDays > 0 {
+		go s.catchUp(context.Background())
+	}
+	if err == nil && s.cacheMaxAge > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.hs.RegisterOnShutdown(cancel)
+		go s.sweepCaches(ctx)
+	}
+	return err
 }
 
-func (s *Server) summary(rw http.ResponseWriter, r *http.Request) {
-	log := s.log.WithName("summary")
-	ctx, span := s.trace.Start(r.Context(), "summary")
-	defer span.End()
-
-	user, msg, code, err := func(method string, body io.ReadCloser) (string, string, int, error) {
-		ctx, span = s.trace.Start(ctx, "extract-user")
-		defer span.End()
-
-		if r.Method != http.MethodPost {
-			log = log.WithValues("method", r.Method)
-			return "", "invalid method", http.StatusMethodNotAllowed, errors.New("POST only")
-		}
-		b, err := io.ReadAll(r.Body)
+// setup validates the config and creates clients.
+func (s *Server) setup(ctx context.Context) error {
+	initial := &reloadable{metadata: &metadataCache{}}
+	if s.configFile != "" {
+		var err error
+		initial.summaryDefaults, err = s.loadConfig()
 		if err != nil {
-			return "", "read body", http.StatusBadRequest, err
+			return fmt.Errorf("invalid earbug.config: %w", err)
 		}
-		var user userReq
-		err = json.Unmarshal(b, &user)
-		if err == nil && user.User == "" {
-			err = errors.New("no user provided")
-		}
-		if err != nil {
-			return "", "unmarshal body", http.StatusBadRequest, err
-		}
-		return user.User, "", 0, nil
-	}(r.Method, r.Body)
+	}
+	err := validFraming(s.framing)
 	if err != nil {
-		http.Error(rw, msg, code)
-		log.Error(err, msg, "ctx", ctx, "http_request", r)
-		return
+		return err
 	}
-
-	log = log.WithValues("user", user)
-
-	data, msg, code, err := func(user string) (*earbugv3.Store, string, int, error) {
-		ctx, span = s.trace.Start(ctx, "read-data")
-		defer span.End()
-
-		key := user + ".pb.zstd"
-		obj := s.bkt.Object(key)
-		or, err := obj.NewReader(ctx)
-		if err != nil {
-			return nil, "create object reader", http.StatusInternalServerError, err
+	switch s.missingMetadata {
+	case missingID, missingSkip, missingPlaceholder:
+	default:
+		return fmt.Errorf("unknown earbug.metadata.missing %q", s.missingMetadata)
+	}
+	switch s.podcasts {
+	case podcastsExclude, podcastsInclude, podcastsSeparate:
+	default:
+		return fmt.Errorf("unknown earbug.podcasts %q", s.podcasts)
+	}
+	if _, ok := thousandsSeparators[s.numbers]; !ok {
+		return fmt.Errorf("unknown earbug.numbers %q", s.numbers)
+	}
+	if _, ok := sectionSeparators[s.separator]; !ok {
+		return fmt.Errorf("unknown earbug.sections.separator %q", s.separator)
+	}
+	// not the reference time, it formats as itself in every layout
+	if sample := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC); s.dateFormat == "" || sample.Format(s.dateFormat) == s.dateFormat {
+		return fmt.Errorf("earbug.dateformat %q has no date elements", s.dateFormat)
+	}
+	switch s.weekStartFlag {
+	case "monday":
+		s.weekStart = time.Monday
+	case "sunday":
+		s.weekStart = time.Sunday
+	default:
+		return fmt.Errorf("unknown earbug.weekstart %q, expected monday or sunday", s.weekStartFlag)
+	}
+	s.glanceEmoji, err = parseGlanceEmoji(s.glanceEmojiFlag)
+	if err != nil {
+		return fmt.Errorf("invalid earbug.glance.emoji: %w", err)
+	}
+	s.userGroups, err = parseUserGroups(s.userGroupsFlag)
+	if err != nil {
+		return fmt.Errorf("invalid earbug.groups: %w", err)
+	}
+	s.storeURLHosts = parseStoreURLHosts(s.storeURLHostsFlag)
+	if s.storeURLTimeout <= 0 {
+		return fmt.Errorf("earbug.storeurl.timeout %v must be positive", s.storeURLTimeout)
+	}
+	s.storeURLClient = s.newStoreURLClient()
+	s.excluded = make(map[string]struct{})
+	for _, id := range strings.Split(s.excludeTracks, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			s.excluded[id] = struct{}{}
 		}
-		defer or.Close()
-
-		zr, err := zstd.NewReader(or)
+	}
+	s.loc, err = time.LoadLocation(s.timezone)
+	if err != nil {
+		return fmt.Errorf("load timezone: %w", err)
+	}
+	if s.tenantsFile != "" {
+		initial.tenants, err = s.loadTenants()
 		if err != nil {
-			return nil, "create zstd reader", http.StatusInternalServerError, err
+			return fmt.Errorf("invalid earbug.tenants: %w", err)
 		}
-		defer zr.Close()
-
-		b, err := io.ReadAll(zr)
+	}
+	s.reloaded.Store(initial)
+	if s.sinkPath != "" {
+		s.sink = &fileSink{path: s.sinkPath, loc: s.loc, now: s.clock}
+	}
+	if s.gchat.Endpoint != "" {
+		s.gchat.Endpoint, err = s.parseWebhook(s.gchat.Endpoint)
 		if err != nil {
-			return nil, "read object", http.StatusInternalServerError, err
+			return fmt.Errorf("invalid earbug.gchat: %w", err)
 		}
-
-		var data earbugv3.Store
-		err = proto.Unmarshal(b, &data)
+	}
+	if s.errorsWebhook != "" {
+		s.errorsWebhook, err = s.parseWebhook(s.errorsWebhook)
 		if err != nil {
-			return nil, "unmarshal as proto", http.StatusInternalServerError, err
+			return fmt.Errorf("invalid earbug.gchat.errors: %w", err)
 		}
-		return &data, "", 0, nil
-	}(user)
+	}
+	err = validSender(s.sender)
 	if err != nil {
-		http.Error(rw, msg, code)
-		log.Error(err, msg, "ctx", ctx, "http_request", r)
-		return
-	}
-
-	msg, code, err = func(data *earbugv3.Store) (string, int, error) {
-		ctx, span = s.trace.Start(ctx, "post-summary")
-		defer span.End()
-
-		playedBefore := make(map[string]struct{})
-		playedYesterday := make(map[string]struct{})
-		var yesterdayPlays int
-		tsPrefix := time.Now().Add(time.Duration(-24) * time.Hour).Format("2006-01-02")
-		for ts, played := range data.Playbacks {
-			cmp := strings.Compare(ts[:10], tsPrefix)
-			if cmp < 0 {
-				playedBefore[played.TrackId] = struct{}{}
-			} else if cmp == 0 {
-				yesterdayPlays++
-				playedYesterday[played.TrackId] = struct{}{}
-			}
+		return err
+	}
+	switch s.gchatMode {
+	case gchatModeWebhook:
+		if s.posting && s.gchat.Endpoint == "" && s.manifest == "" && s.notifier == nil {
+			return errors.New("no webhook configured: set earbug.gchat, per user webhooks in earbug.manifest, or earbug.posting.enabled=false")
 		}
-
-		var yesterdayNewTracks int
-		for id := range playedYesterday {
-			if _, ok := playedBefore[id]; !ok {
-				yesterdayNewTracks++
+	case gchatModeAPI:
+		if s.posting {
+			s.chatAPI, err = newChatAPIClient(ctx, s.gchatSpace, s.sender)
+			if err != nil {
+				return err
 			}
 		}
+	default:
+		return fmt.Errorf("unknown earbug.gchat.mode %q, expected %s or %s", s.gchatMode, gchatModeWebhook, gchatModeAPI)
+	}
 
-		log = log.WithValues("summary_date", tsPrefix, "plays", yesterdayPlays, "tracks", len(playedYesterday), "tracks_new", yesterdayNewTracks)
-		chatMsg := fmt.Sprintf("%s | %v plays | %v tracks (%v new)", tsPrefix, yesterdayPlays, len(playedYesterday), yesterdayNewTracks)
-		err = s.gchat.Post(ctx, gchat.WebhookPayload{
-			Text: chatMsg,
-		})
+	if s.duoMaxArtists < 2 {
+		return fmt.Errorf("earbug.duo.maxartists %d must be at least 2", s.duoMaxArtists)
+	}
+	if s.skipThreshold < 0 || s.skipThreshold > 1 {
+		return fmt.Errorf("earbug.skip.threshold %v out of range 0-1", s.skipThreshold)
+	}
+	if s.bingeTracks < 0 {
+		return fmt.Errorf("earbug.binge.mintracks %d must not be negative", s.bingeTracks)
+	}
+	if s.maxList < 0 || s.maxList > 50 {
+		return fmt.Errorf("earbug.maxlist %d out of range 0-50", s.maxList)
+	}
+	if err := validEmptyStatus(s.emptyStatus); err != nil {
+		return fmt.Errorf("invalid earbug.empty.status: %w", err)
+	}
+	if s.albumLength <= 0 {
+		return fmt.Errorf("earbug.album.length %v must be positive", s.albumLength)
+	}
+	if s.percentPrecision < 0 || s.percentPrecision > 1 {
+		return fmt.Errorf("earbug.percent.precision %d must be 0 or 1", s.percentPrecision)
+	}
+	if s.cacheMaxAge < 0 || s.cacheMaxAge > 0 && s.cacheMaxAge < time.Minute {
+		return fmt.Errorf("earbug.cache.maxage %v must be 0 or at least 1m", s.cacheMaxAge)
+	}
+	if s.resolverURLFlag != "" {
+		s.resolverURL, err = parseResolverURL(s.resolverURLFlag)
 		if err != nil {
-			return "post message", http.StatusInternalServerError, err
+			return fmt.Errorf("invalid earbug.resolver.url: %w", err)
+		}
+		if s.resolverTimeout <= 0 {
+			return fmt.Errorf("earbug.resolver.timeout %v must be positive", s.resolverTimeout)
+		}
+		if s.resolverUnknown <= 0 {
+			return fmt.Errorf("earbug.resolver.unknown.maxage %v must be positive", s.resolverUnknown)
+		}
+		s.resolverClient = &http.Client{
+			Transport: otelhttp.NewTransport(nil),
 		}
+	}
+	if s.milestoneStep < 0 {
+		return fmt.Errorf("earbug.milestone.step %d must not be negative", s.milestoneStep)
+	}
+	if s.busiestWeeks < 0 {
+		return fmt.Errorf("earbug.busiest.weeks %d must not be negative", s.busiestWeeks)
+	}
+	switch s.streakToday {
+	case streakOptimistic, streakStrict:
+	default:
+		return fmt.Errorf("unknown earbug.streak.today %q, expected %s or %s", s.streakToday, streakOptimistic, streakStrict)
+	}
+	if s.goalNewTracks < 0 {
+		return fmt.Errorf("earbug.goal.newtracks %d must not be negative", s.goalNewTracks)
+	}
+	if s.rateLimitRate > 0 && s.rateLimitBurst < 1 {
+		return fmt.Errorf("earbug.ratelimit.burst %d must be at least 1", s.rateLimitBurst)
+	}
+
+	_, err = s.objects(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.gchat.Client = &http.Client{
+		Transport: otelhttp.NewTransport(nil),
+	}
+	return nil
+}
+
+// httpTimeouts bound how long connections to the http server can take.
+type httpTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+func (t httpTimeouts) apply(hs *http.Server) {
+	hs.ReadHeaderTimeout = t.readHeader
+	hs.ReadTimeout = t.read
+	hs.WriteTimeout = t.write
+	hs.IdleTimeout = t.idle
+}
+
+type userReq struct {
+	User string `json:"user"`
+	// StoreURL is a presigned url to read the store from instead of the bucket
+	StoreURL string `json:"storeURL,omitempty"`
+}
+
+// requestUser returns the user named in the user query parameter,
+// or in a POST body of {"user": "..."}.
+func requestUser(r *http.Request) (string, string, int, error) {
+	req, msg, code, err := requestBody(r)
+	return req.User, msg, code, err
+}
 
-		return "ok", http.StatusOK, nil
-	}(data)
+// requestBody is requestUser with the rest of the POST body.
+func requestBody(r *http.Request) (userReq, string, int, error) {
+	if user := r.URL.Query().Get("user"); user != "" {
+		setAccessUser(r.Context(), user)
+		return userReq{User: user}, "", 0, nil
+	}
+	if r.Method != http.MethodPost {
+		return userReq{}, "no user", http.StatusBadRequest, errors.New("no user provided")
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return userReq{}, "read body", http.StatusBadRequest, err
+	}
+	if len(b) == 0 {
+		return userReq{}, `empty request body; expected {"user":"..."}`, http.StatusBadRequest, errors.New("empty body")
+	}
+	var user userReq
+	err = json.Unmarshal(b, &user)
+	if err == nil && user.User == "" {
+		err = errors.New("no user provided")
+	}
 	if err != nil {
-		http.Error(rw, msg, code)
-		log.Error(err, msg, "ctx", ctx, "http_request", r)
-		return
+		return userReq{}, "unmarshal body", http.StatusBadRequest, err
 	}
+	setAccessUser(r.Context(), user.User)
+	return user, "", 0, nil
+}
+
+const gchatHost = "chat.googleapis.com"
 
-	rw.Write([]byte(msg))
-	log.Info("posted summary", "ctx", ctx, "http_request", r)
+// parseWebhook validates and normalizes a webhook endpoint,
+// only absolute https urls are accepted,
+// pointing at google chat unless the host check is disabled.
+func (s *Server) parseWebhook(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", errors.New("webhook must be an absolute https url")
+	}
+	if s.webhookHostCheck && u.Host != gchatHost {
+		return "", fmt.Errorf("webhook host %q is not %s", u.Host, gchatHost)
+	}
+	return u.String(), nil
 }
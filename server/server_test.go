@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-logr/logr"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/trace"
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"go.seankhliao.com/gchat"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// testNow is the clock of test servers, summaries default to 2024-03-14.
+var testNow = time.Date(2024, time.March, 15, 9, 0, 0, 0, time.UTC)
+
+// memStore is an ObjectStore held in memory.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// reads counts NewReader calls
+	reads int
+}
+
+func (m *memStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	b, ok := m.objects[name]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStore) NewWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return &memWriter{m: m, name: name}
+}
+
+func (m *memStore) List(ctx context.Context, suffix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.objects {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memStore) put(name string, b []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	m.objects[name] = b
+}
+
+// putStore writes data as user's zstd compressed store object.
+func (m *memStore) putStore(t testing.TB, user string, data *earbugv3.Store) {
+	t.Helper()
+	b, err := proto.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.put(user+storeSuffix, zstdBytes(t, b))
+}
+
+type memWriter struct {
+	m    *memStore
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+func (w *memWriter) Close() error {
+	w.m.put(w.name, w.buf.Bytes())
+	return nil
+}
+
+// postRecorder is a Notifier keeping what was posted.
+type postRecorder struct {
+	mu    sync.Mutex
+	posts []gchat.WebhookPayload
+	err   error
+}
+
+func (p *postRecorder) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.posts = append(p.posts, msg)
+	return nil
+}
+
+func (p *postRecorder) texts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var texts []string
+	for _, msg := range p.posts {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func zstdBytes(t testing.TB, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// newTestServer is a Server with its flags at their defaults
+// changed by set, in UTC at testNow, reading from store and posting to n.
+func newTestServer(t testing.TB, store ObjectStore, n Notifier, set map[string]string) *Server {
+	t.Helper()
+	s := New(&http.Server{})
+	s.Register(nil)
+	s.timezone = "UTC"
+	for name, v := range set {
+		fv, ok := s.flags.vars[name]
+		if !ok {
+			t.Fatalf("unknown flag %s", name)
+		}
+		err := fv.set(v)
+		if err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+	s.log = logr.Discard()
+	s.trace = trace.NewNoopTracerProvider().Tracer("test")
+	var err error
+	s.storeBytes, err = global.Meter("test").Int64Histogram("earbug.store.size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetClock(func() time.Time { return testNow })
+	s.SetObjectStore(store)
+	if n != nil {
+		s.SetNotifier(n)
+	}
+	err = s.setup(context.Background())
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	return s
+}
+
+// serve sends a request to the server's handler.
+func serve(s *Server, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rw := httptest.NewRecorder()
+	s.hs.Handler.ServeHTTP(rw, r)
+	return rw
+}
+
+// testTrack is a track of the given length by the given artists.
+func testTrack(id, name string, length time.Duration, artists ...string) *earbugv3.Track {
+	t := &earbugv3.Track{Id: id, Name: name, Duration: durationpb.New(length)}
+	for _, a := range artists {
+		t.Artists = append(t.Artists, &earbugv3.Artist{Id: strings.ToLower(a), Name: a})
+	}
+	return t
+}
+
+// testStore has plays of a few tracks over the days before testNow,
+// by track id at RFC3339 times.
+func testStore(plays map[string][]string) *earbugv3.Store {
+	data := &earbugv3.Store{
+		Playbacks: make(map[string]*earbugv3.Playback),
+		Tracks: map[string]*earbugv3.Track{
+			"t1": testTrack("t1", "Alpha", 3*time.Minute, "Ann"),
+			"t2": testTrack("t2", "Beta", 4*time.Minute, "Bob"),
+			"t3": testTrack("t3", "Gamma", 5*time.Minute, "Ann", "Cat"),
+		},
+	}
+	for id, times := range plays {
+		for _, ts := range times {
+			data.Playbacks[ts] = &earbugv3.Playback{TrackId: id}
+		}
+	}
+	return data
+}
+
+// yesterdayPlays are plays on 2024-03-14 UTC, the day before testNow.
+var yesterdayPlays = map[string][]string{
+	"t1": {"2024-03-14T08:00:00Z", "2024-03-14T09:00:00Z", "2024-03-14T10:00:00Z"},
+	"t2": {"2024-03-14T11:00:00Z", "2024-03-14T12:00:00Z"},
+	"t3": {"2024-03-14T13:00:00Z", "2024-03-13T13:00:00Z"},
+}
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		user   string
+		code   int
+		msg    string
+	}{
+		{"query", "/summary?user=alice", "", "alice", 0, ""},
+		{"body", "/summary", `{"user":"alice"}`, "alice", 0, ""},
+		{"empty", "/summary", "", "", http.StatusBadRequest, `empty request body; expected {"user":"..."}`},
+		{"malformed", "/summary", `{"user":`, "", http.StatusBadRequest, "unmarshal body"},
+		{"no user", "/summary", `{"storeURL":"https://example.com/x"}`, "", http.StatusBadRequest, "unmarshal body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req, msg, code, err := requestBody(r)
+			if (err != nil) != (tt.code != 0) || code != tt.code || msg != tt.msg || req.User != tt.user {
+				t.Errorf("got %q, %q %d %v, want %q, %q %d", req.User, msg, code, err, tt.user, tt.msg, tt.code)
+			}
+		})
+	}
+}
+
+func TestSummaryEmptyBody(t *testing.T) {
+	s := newTestServer(t, &memStore{}, &postRecorder{}, nil)
+	rw := serve(s, http.MethodPost, "/summary", "", nil)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", rw.Code)
+	}
+	if got, want := strings.TrimSpace(rw.Body.String()), `empty request body; expected {"user":"..."}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.seankhliao.com/gchat"
+	chat "google.golang.org/api/chat/v1"
+)
+
+// fileSink appends each posted message as a json line
+// to a file per day, named by replacing {date} in path.
+type fileSink struct {
+	mu   sync.Mutex
+	path string
+	loc  *time.Location
+	now  func() time.Time
+}
+
+type sinkRecord struct {
+	Time      time.Time `json:"time"`
+	ThreadKey string    `json:"threadKey,omitempty"`
+	Text      string    `json:"text"`
+}
+
+func (f *fileSink) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	return f.write(msg, "")
+}
+
+func (f *fileSink) write(msg gchat.WebhookPayload, threadKey string) error {
+	now := f.now().In(f.loc)
+	b, err := json.Marshal(sinkRecord{now, threadKey, msg.Text})
+	if err != nil {
+		return err
+	}
+	name := strings.ReplaceAll(f.path, "{date}", now.Format(dateLayout))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open sink file: %w", err)
+	}
+	_, err = file.Write(append(b, '\n'))
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("append to %s: %w", name, err)
+	}
+	return file.Close()
+}
+
+// teeNotifier also writes messages posted to chat to a file sink.
+// The sink is written after chat, and its failures are only logged:
+// the message is already out and failing would only cause a repost.
+type teeNotifier struct {
+	Notifier
+	sink *fileSink
+	log  logr.Logger
+}
+
+func (t teeNotifier) Post(ctx context.Context, msg gchat.WebhookPayload) error {
+	err := t.Notifier.Post(ctx, msg)
+	if err == nil {
+		t.archive(msg, "")
+	}
+	return err
+}
+
+func (t teeNotifier) PostThread(ctx context.Context, msg gchat.WebhookPayload, threadKey string) error {
+	tp, ok := t.Notifier.(threadPoster)
+	if !ok {
+		return t.Post(ctx, msg)
+	}
+	err := tp.PostThread(ctx, msg, threadKey)
+	if err == nil {
+		t.archive(msg, threadKey)
+	}
+	return err
+}
+
+func (t teeNotifier) PostCards(ctx context.Context, msg gchat.WebhookPayload, cards []*chat.CardWithId, threadKey string) error {
+	cp, ok := t.Notifier.(cardPoster)
+	if !ok && threadKey == "" {
+		return t.Post(ctx, msg)
+	} else if !ok {
+		return t.PostThread(ctx, msg, threadKey)
+	}
+	err := cp.PostCards(ctx, msg, cards, threadKey)
+	if err == nil {
+		t.archive(msg, threadKey)
+	}
+	return err
+}
+
+func (t teeNotifier) archive(msg gchat.WebhookPayload, threadKey string) {
+	err := t.sink.write(msg, threadKey)
+	if err != nil {
+		t.log.Error(err, "write to file sink")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import "time"
+
+// SkipRate is the share of plays stopped early,
+// inferred from when the next play started.
+type SkipRate struct {
+	Skipped int `json:"skipped"`
+	// Plays followed by another play in the same session,
+	// the only ones whose listened time is known
+	Plays   int `json:"plays"`
+	Percent int `json:"percent"`
+}
+
+// skipRate counts sorted plays where the next play started
+// before threshold of the track's duration had passed.
+// Plays with no duration, or not followed by another within gap, are left out,
+// nil if that leaves none.
+func skipRate(plays []playback, gap time.Duration, threshold float64) *SkipRate {
+	var sr SkipRate
+	for i, p := range plays {
+		if i+1 == len(plays) || p.dur <= 0 {
+			continue
+		}
+		listened := plays[i+1].ts.Sub(p.ts)
+		if listened-p.dur >= gap {
+			continue
+		}
+		sr.Plays++
+		if float64(listened) < threshold*float64(p.dur) {
+			sr.Skipped++
+		}
+	}
+	if sr.Plays == 0 {
+		return nil
+	}
+	sr.Percent = sr.Skipped * 100 / sr.Plays
+	return &sr
+}
+
+// setCompletion sets the average share of each of top's duration listened,
+// inferred like skipRate and at most 1 per play.
+// Tracks without a play whose listened time is known are left unset.
+func setCompletion(top []TrackCount, plays []playback, gap time.Duration) {
+	type completion struct {
+		sum   float64
+		plays int
+	}
+	byTrack := make(map[string]*completion, len(top))
+	for _, t := range top {
+		byTrack[t.ID] = &completion{}
+	}
+	for i, p := range plays {
+		c, ok := byTrack[p.trackID]
+		if !ok || i+1 == len(plays) || p.dur <= 0 {
+			continue
+		}
+		listened := plays[i+1].ts.Sub(p.ts)
+		if listened-p.dur >= gap {
+			continue
+		}
+		if listened > p.dur {
+			listened = p.dur
+		}
+		c.sum += float64(listened) / float64(p.dur)
+		c.plays++
+	}
+	for i := range top {
+		if c := byTrack[top[i].ID]; c.plays > 0 {
+			avg := c.sum / float64(c.plays)
+			top[i].Completion = &avg
+		}
+	}
+}
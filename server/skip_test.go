@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSkipRate(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		ts, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	four := 4 * time.Minute
+	plays := []playback{
+		{ts: at("08:00"), dur: four}, // complete
+		{ts: at("08:04"), dur: four}, // skipped after 1m
+		{ts: at("08:05"), dur: four}, // half played
+		{ts: at("08:07"), dur: 0},    // no duration, left out
+		{ts: at("08:08"), dur: four}, // session ends, left out
+		{ts: at("09:00"), dur: four}, // last, left out
+	}
+	gap := 30 * time.Minute
+
+	tests := []struct {
+		threshold float64
+		want      SkipRate
+	}{
+		// exactly half counts as played
+		{0.5, SkipRate{Skipped: 1, Plays: 3, Percent: 33}},
+		{0.75, SkipRate{Skipped: 2, Plays: 3, Percent: 66}},
+		{1, SkipRate{Skipped: 2, Plays: 3, Percent: 66}},
+		{0.2, SkipRate{Skipped: 0, Plays: 3, Percent: 0}},
+	}
+	for _, tt := range tests {
+		got := skipRate(plays, gap, tt.threshold)
+		if got == nil || *got != tt.want {
+			t.Errorf("threshold %v: got %+v, want %+v", tt.threshold, got, tt.want)
+		}
+	}
+
+	if got := skipRate(plays[3:], gap, 0.5); got != nil {
+		t.Errorf("no known listened time, got %+v", got)
+	}
+	if got := skipRate(nil, gap, 0.5); got != nil {
+		t.Errorf("no plays, got %+v", got)
+	}
+}
+
+func TestSkipsSection(t *testing.T) {
+	opts, _ := parseSummaryOptions(nil)
+	sum := &Summary{SkipRate: &SkipRate{Skipped: 1, Plays: 3}}
+	if got := sections["skips"](sum, opts); got != "skip rate 33%" {
+		t.Errorf("got %q", got)
+	}
+	if got := sections["skips"](&Summary{}, opts); got != "" {
+		t.Errorf("without a skip rate got %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const snapshotPrefix = "snapshots/"
+
+// createWriter is implemented by object stores that can write an object
+// only if it doesn't exist yet, failing on Close if it does.
+type createWriter interface {
+	NewCreateWriter(ctx context.Context, name, contentType string) io.WriteCloser
+}
+
+func snapshotName(user, label string) string {
+	return snapshotPrefix + user + "/" + strings.ReplaceAll(label, " ", "-") + ".json"
+}
+
+// writeSnapshot stores sum as json in the bucket,
+// keeping an existing snapshot of the same window unless overwrite is set.
+// It returns the object name.
+func (s *Server) writeSnapshot(ctx context.Context, sum *Summary, overwrite bool) (string, int, error) {
+	ctx, span := s.trace.Start(ctx, "write-snapshot")
+	defer span.End()
+
+	name := snapshotName(sum.User, sum.Date)
+	exists := func() (string, int, error) {
+		return "snapshot exists, set overwrite=true to replace it", http.StatusConflict, fmt.Errorf("snapshot %s already exists", name)
+	}
+	b, err := json.Marshal(sum)
+	if err != nil {
+		return "marshal snapshot", http.StatusInternalServerError, err
+	}
+	store, err := s.objects(ctx)
+	if err != nil {
+		return "get bucket", http.StatusInternalServerError, err
+	}
+
+	var ow io.WriteCloser
+	cw, atomic := store.(createWriter)
+	switch {
+	case overwrite:
+		ow = store.NewWriter(ctx, name, "application/json")
+	case atomic:
+		ow = cw.NewCreateWriter(ctx, name, "application/json")
+	default:
+		or, err := store.NewReader(ctx, name)
+		if err == nil {
+			or.Close()
+			return exists()
+		} else if !errors.Is(err, storage.ErrObjectNotExist) {
+			return "check snapshot", http.StatusInternalServerError, err
+		}
+		ow = store.NewWriter(ctx, name, "application/json")
+	}
+	_, err = ow.Write(b)
+	if cerr := ow.Close(); err == nil {
+		err = cerr
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusPreconditionFailed:
+			return exists()
+		case http.StatusForbidden:
+			return "bucket not writable, grant object create access to persist snapshots", http.StatusInternalServerError, err
+		}
+	}
+	if err != nil {
+		return "write snapshot", http.StatusInternalServerError, err
+	}
+	return name, http.StatusOK, nil
+}
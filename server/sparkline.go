@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+type artistSparkline struct {
+	Artist string `json:"artist,omitempty"`
+	Name   string `json:"name"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Plays  int    `json:"plays"`
+	Days   []int  `json:"days,omitempty"`
+	// Weeks are ISO weeks from the one starting on From
+	Weeks []int `json:"weeks,omitempty"`
+}
+
+// sparkline reports daily plays of a single artist, or all plays,
+// over the days up to and including yesterday.
+// With ?weekly=true, plays are counted by ISO week instead,
+// ending with the week of yesterday.
+func (s *Server) sparkline(rw http.ResponseWriter, r *http.Request) {
+	log := s.log.WithName("sparkline")
+	ctx, span := s.trace.Start(r.Context(), "sparkline")
+	defer span.End()
+
+	user, msg, code, err := requestUser(r)
+	if err != nil {
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("user", user)
+
+	artist, days, weeks, err := func() (string, int, int, error) {
+		q := r.URL.Query()
+		artist := q.Get("artist")
+		if v := q.Get("weekly"); v != "" {
+			weekly, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", 0, 0, fmt.Errorf("parse weekly: %w", err)
+			}
+			if weekly {
+				weeks := 12
+				if v := q.Get("weeks"); v != "" {
+					weeks, err = strconv.Atoi(v)
+					if err != nil {
+						return "", 0, 0, fmt.Errorf("parse weeks: %w", err)
+					}
+				}
+				if weeks < 1 || weeks > 104 {
+					return "", 0, 0, invalidf("weeks %d out of range 1-104", weeks)
+				}
+				return artist, 0, weeks, nil
+			}
+		}
+		if artist == "" {
+			return "", 0, 0, errors.New("no artist provided")
+		}
+		days := 30
+		if v := q.Get("days"); v != "" {
+			var err error
+			days, err = strconv.Atoi(v)
+			if err != nil {
+				return "", 0, 0, fmt.Errorf("parse days: %w", err)
+			}
+		}
+		if days < 1 || days > 366 {
+			return "", 0, 0, invalidf("days %d out of range 1-366", days)
+		}
+		return artist, days, 0, nil
+	}()
+	if err != nil {
+		msg := "invalid options"
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	log = log.WithValues("artist", artist, "days", days, "weeks", weeks)
+
+	data, err := s.readStore(ctx, user, &serverTiming{})
+	if err != nil {
+		msg, code := stageStatus(err)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	var dates []string
+	if weeks > 0 {
+		dates = weekDays(s.clock(), s.loc, weeks, s.weekStart)
+	} else {
+		dates = lastDays(s.clock(), s.loc, days)
+	}
+	match := s.countedPlay(data.Store)
+	name := "all plays"
+	if artist != "" {
+		var tracks map[string]struct{}
+		name, tracks = artistTracks(data.Store, artist)
+		match = func(p *earbugv3.Playback) bool {
+			_, ok := tracks[p.TrackId]
+			return ok
+		}
+	}
+	counts := dailyPlays(data.Store, s.loc, dates, match)
+	res := artistSparkline{
+		Artist: artist,
+		Name:   name,
+		From:   dates[0],
+		To:     dates[len(dates)-1],
+		Days:   counts,
+	}
+	for _, c := range counts {
+		res.Plays += c
+	}
+	line := res.Days
+	if weeks > 0 {
+		res.Days, res.Weeks = nil, make([]int, weeks)
+		for i, c := range counts {
+			res.Weeks[i/7] += c
+		}
+		line = res.Weeks
+	}
+
+	if wantsJSON(r) {
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(res)
+	} else {
+		fmt.Fprintf(rw, "%s %s..%s %s (%v plays)\n", res.Name, res.From, res.To, sparkline(line), res.Plays)
+	}
+	log.Info("served sparkline", "plays", res.Plays, "ctx", ctx, "http_request", r)
+}
+
+// weekDays returns the dates of the n weeks starting on first in loc
+// ending with the week of the day before now,
+// up to and including that day.
+func weekDays(now time.Time, loc *time.Location, n int, first time.Weekday) []string {
+	last := now.In(loc).AddDate(0, 0, -1)
+	var dates []string
+	for d := weekStart(last, first).AddDate(0, 0, -7*(n-1)); !d.After(last); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format(dateLayout))
+	}
+	return dates
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("accept"), "application/json")
+}
+
+// artistTracks returns the artist's name and the ids of tracks they appear on,
+// the name falls back to the id if no track lists the artist.
+// Tracks are visited in id order so the name is stable
+// when tracks disagree on it.
+func artistTracks(data *earbugv3.Store, artist string) (string, map[string]struct{}) {
+	name := artist
+	tracks := make(map[string]struct{})
+	for _, id := range sortedKeys(data.Tracks) {
+		track := data.Tracks[id]
+		for _, a := range track.Artists {
+			if a.Id == artist {
+				tracks[id] = struct{}{}
+				if a.Name != "" && name == artist {
+					name = a.Name
+				}
+				break
+			}
+		}
+	}
+	return name, tracks
+}
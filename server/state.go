@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+const statePrefix = "state/"
+
+// userState is the small per user state kept in the bucket
+// between summaries.
+type userState struct {
+	Date  string `json:"date"`
+	Plays int    `json:"plays"`
+	// Prior is the state before Date,
+	// kept so a rerun for the same day compares against the same prior day.
+	Prior *userState `json:"prior,omitempty"`
+}
+
+// readState returns the stored state for user,
+// or nil if none has been written yet.
+func (s *Server) readState(ctx context.Context, user string) (*userState, error) {
+	var st userState
+	ok, err := s.readJSON(ctx, statePrefix+user+".json", &st)
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	} else if !ok {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+func (s *Server) writeState(ctx context.Context, user string, st *userState) error {
+	err := s.writeJSON(ctx, statePrefix+user+".json", st)
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
+
+// readJSON unmarshals the named object into v,
+// reporting false if it doesn't exist.
+func (s *Server) readJSON(ctx context.Context, name string, v any) (bool, error) {
+	store, err := s.objects(ctx)
+	if err != nil {
+		return false, err
+	}
+	or, err := store.NewReader(ctx, name)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer or.Close()
+	b, err := io.ReadAll(or)
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return false, fmt.Errorf("unmarshal %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// writeJSON replaces the named object with v marshaled as json.
+func (s *Server) writeJSON(ctx context.Context, name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", name, err)
+	}
+	store, err := s.objects(ctx)
+	if err != nil {
+		return err
+	}
+	ow := store.NewWriter(ctx, name, "application/json")
+	_, err = ow.Write(b)
+	if err != nil {
+		ow.Close()
+		return err
+	}
+	return ow.Close()
+}
+
+// priorDay returns the state of the last day before date, if known.
+func (st *userState) priorDay(date string) *userState {
+	for st != nil && st.Date >= date {
+		st = st.Prior
+	}
+	return st
+}
+
+// next returns the state to store after summarizing plays on date.
+func (st *userState) next(date string, plays int) *userState {
+	next := &userState{
+		Date:  date,
+		Plays: plays,
+	}
+	if prior := st.priorDay(date); prior != nil {
+		next.Prior = &userState{
+			Date:  prior.Date,
+			Plays: prior.Plays,
+		}
+	}
+	return next
+}
+
+// Anomaly flags an unusually large change in plays from the prior summary.
+type Anomaly struct {
+	PriorDate  string  `json:"priorDate"`
+	PriorPlays int     `json:"priorPlays"`
+	Change     float64 `json:"change"`
+}
+
+// detectAnomaly compares plays against the prior day,
+// flagging changes of at least factor in either direction.
+// A prior of zero plays is treated as one to keep the ratio finite.
+func detectAnomaly(prior *userState, plays int, factor float64) *Anomaly {
+	if prior == nil || factor <= 1 {
+		return nil
+	}
+	before, after := float64(prior.Plays), float64(plays)
+	if before < 1 {
+		before = 1
+	}
+	if after < 1 {
+		after = 1
+	}
+	change := after / before
+	if change < factor && 1/change < factor {
+		return nil
+	}
+	return &Anomaly{
+		PriorDate:  prior.Date,
+		PriorPlays: prior.Plays,
+		Change:     float64(plays) / before,
+	}
+}
@@ -0,0 +1,995 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+const dateLayout = "2006-01-02"
+
+// newTrackListMax caps the new tracks listed in a summary.
+const newTrackListMax = 100
+
+type playback struct {
+	ts      time.Time
+	trackID string
+	dur     time.Duration
+	played  *earbugv3.Playback
+	// no type information, counted as music
+	untyped bool
+}
+
+// Summary is the listening summary for a single user over a window of days.
+type Summary struct {
+	User string `json:"user"`
+	// Date labels the window, the date for single days
+	Date      string     `json:"date"`
+	Days      []DayCount `json:"days,omitempty"`
+	Plays     int        `json:"plays"`
+	Tracks    int        `json:"tracks"`
+	NewTracks int        `json:"newTracks"`
+	// OneOffs are tracks played exactly once in the window
+	OneOffs        int           `json:"oneOffs"`
+	Listened       time.Duration `json:"listened"`
+	LongestSession *Session      `json:"longestSession,omitempty"`
+	PeakHour       *PeakHour     `json:"peakHour,omitempty"`
+	Binges         []Binge       `json:"binges,omitempty"`
+	Record         *Record       `json:"record,omitempty"`
+	SkipRate       *SkipRate     `json:"skipRate,omitempty"`
+	OnThisDay      *OnThisDay    `json:"onThisDay,omitempty"`
+	Podcasts       *Podcasts     `json:"podcasts,omitempty"`
+	Anomaly        *Anomaly      `json:"anomaly,omitempty"`
+	Goal           *Goal         `json:"goal,omitempty"`
+	Milestone      *Milestone    `json:"milestone,omitempty"`
+	Consistency    *Consistency  `json:"consistency,omitempty"`
+	BusiestDay     *BusiestDay   `json:"busiestDay,omitempty"`
+	Streak         *Streak       `json:"streak,omitempty"`
+	Duo            *Duo          `json:"duo,omitempty"`
+	Rising         []RisingTrack `json:"rising,omitempty"`
+	// ArtistShift is the top artist of each quarter in windows spanning quarters
+	ArtistShift    []QuarterArtist `json:"artistShift,omitempty"`
+	Prior          *DayTotals      `json:"prior,omitempty"`
+	FirstDiscovery *Discovery      `json:"firstDiscovery,omitempty"`
+	Top            []TrackCount    `json:"top,omitempty"`
+	// RankBy is rankAffinity when Top is ranked by affinity, empty for plays
+	RankBy string `json:"rankBy,omitempty"`
+	// NewTrackList are the most played of the new tracks,
+	// at most newTrackListMax, with ?includeNewTracks=true
+	NewTrackList []TrackCount  `json:"newTrackList,omitempty"`
+	AllTime      []TrackCount  `json:"allTime,omitempty"`
+	GroupBy      string        `json:"groupBy,omitempty"`
+	Groups       []GroupCount  `json:"groups,omitempty"`
+	Context      string        `json:"context,omitempty"`
+	Members      []MemberShare `json:"members,omitempty"`
+	Achievements []string      `json:"achievements,omitempty"`
+	// Warnings are notes on degraded data the summary was computed from
+	Warnings []string `json:"warnings,omitempty"`
+	// warnings are Warnings as labels to translate
+	warnings []warning
+
+	// plays without type information, counted as music
+	untyped int
+	// music plays up to the end of the window
+	totalPlays int
+	// some plays in the window recorded their context
+	contextKnown bool
+	// sections whose entries were all below the minPlays threshold
+	filtered map[string]bool
+	// plays of each artist id in the window,
+	// and in total up to the end of the window
+	artistPlays  map[string]int
+	artistTotals map[string]int
+}
+
+// capLists keeps at most n entries in each ranked list.
+func (sum *Summary) capLists(n int) {
+	if len(sum.Top) > n {
+		sum.Top = sum.Top[:n]
+	}
+	if len(sum.NewTrackList) > n {
+		sum.NewTrackList = sum.NewTrackList[:n]
+	}
+	if len(sum.AllTime) > n {
+		sum.AllTime = sum.AllTime[:n]
+	}
+	if len(sum.Groups) > n {
+		sum.Groups = sum.Groups[:n]
+	}
+	if len(sum.Rising) > n {
+		sum.Rising = sum.Rising[:n]
+	}
+	if len(sum.Binges) > n {
+		sum.Binges = sum.Binges[:n]
+	}
+}
+
+// countsOnly clears everything that relies on track metadata,
+// leaving play and track id counts.
+func (sum *Summary) countsOnly() {
+	sum.Listened = 0
+	sum.LongestSession = nil
+	sum.Binges = nil
+	sum.SkipRate = nil
+	sum.OnThisDay = nil
+	sum.Podcasts = nil
+	sum.Duo = nil
+	sum.ArtistShift = nil
+	sum.Rising = nil
+	sum.FirstDiscovery = nil
+	sum.Top = nil
+	sum.AllTime = nil
+	sum.Groups = nil
+	sum.Achievements = nil
+	if sum.Prior != nil {
+		sum.Prior.Listened = 0
+	}
+	for i := range sum.Days {
+		sum.Days[i].Top = nil
+	}
+}
+
+// DayCount is the number of plays on a date.
+type DayCount struct {
+	Date  string `json:"date"`
+	Plays int    `json:"plays"`
+	// Top is the most played track of the day, nil with no plays
+	Top *TrackCount `json:"top,omitempty"`
+}
+
+// DayTotals are the headline counts for a day.
+type DayTotals struct {
+	Plays    int           `json:"plays"`
+	Tracks   int           `json:"tracks"`
+	Listened time.Duration `json:"listened"`
+}
+
+// Record is a day with more plays than any before it.
+type Record struct {
+	Plays int `json:"plays"`
+	// PriorPlays on PriorDate was the previous best,
+	// the earliest day with that many plays
+	PriorPlays int    `json:"priorPlays"`
+	PriorDate  string `json:"priorDate"`
+}
+
+// dailyRecord returns the record set by plays on a day,
+// given the plays on each earlier day,
+// nil if it didn't beat them or there were none.
+func dailyRecord(plays int, earlier map[string]int) *Record {
+	var best Record
+	for _, day := range sortedKeys(earlier) {
+		if n := earlier[day]; n > best.PriorPlays {
+			best.PriorPlays, best.PriorDate = n, day
+		}
+	}
+	if best.PriorDate == "" || plays <= best.PriorPlays {
+		return nil
+	}
+	best.Plays = plays
+	return &best
+}
+
+// OnThisDay is the most played track on the same date in the latest prior year
+// with plays on it.
+type OnThisDay struct {
+	Date  string     `json:"date"`
+	Track TrackCount `json:"track"`
+}
+
+// Discovery is the first ever play of a track.
+type Discovery struct {
+	At   time.Time `json:"at"`
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+}
+
+// Podcasts summarizes podcast episode plays,
+// reported separately from music.
+type Podcasts struct {
+	Plays    int           `json:"plays"`
+	Listened time.Duration `json:"listened"`
+}
+
+// Goal is progress towards a target number of new tracks.
+type Goal struct {
+	NewTracks int `json:"newTracks"`
+	Target    int `json:"target"`
+	// Percent of the target reached, at most 100
+	Percent int `json:"percent"`
+}
+
+func newTracksGoal(newTracks, target int) *Goal {
+	if target <= 0 {
+		return nil
+	}
+	pct := newTracks * 100 / target
+	if pct > 100 {
+		pct = 100
+	}
+	return &Goal{
+		NewTracks: newTracks,
+		Target:    target,
+		Percent:   pct,
+	}
+}
+
+// Milestone projects when total plays reach the next round number.
+type Milestone struct {
+	Target    int `json:"target"`
+	Remaining int `json:"remaining"`
+	// Days at the window's pace to reach Target, 0 with no plays in the window
+	Days int `json:"days"`
+}
+
+// projectMilestone finds the next multiple of step above total
+// and how many days it takes at perDay plays a day.
+func projectMilestone(total int, perDay float64, step int) *Milestone {
+	if step <= 0 {
+		return nil
+	}
+	target := (total/step + 1) * step
+	m := &Milestone{
+		Target:    target,
+		Remaining: target - total,
+	}
+	if perDay > 0 {
+		m.Days = int(math.Ceil(float64(m.Remaining) / perDay))
+	}
+	return m
+}
+
+// Consistency is how evenly plays spread over the days of a window.
+type Consistency struct {
+	Mean float64 `json:"mean"`
+	// StdDev of daily plays, nil with fewer than two days
+	StdDev *float64 `json:"stdDev,omitempty"`
+}
+
+// meanStdDev returns the mean and population standard deviation of ns,
+// zeros if empty.
+func meanStdDev(ns []int) (float64, float64) {
+	if len(ns) == 0 {
+		return 0, 0
+	}
+	var total float64
+	for _, n := range ns {
+		total += float64(n)
+	}
+	mean := total / float64(len(ns))
+	var squares float64
+	for _, n := range ns {
+		d := float64(n) - mean
+		squares += d * d
+	}
+	return mean, math.Sqrt(squares / float64(len(ns)))
+}
+
+// dailyConsistency summarizes the plays per day, nil without days.
+func dailyConsistency(days []DayCount) *Consistency {
+	if len(days) == 0 {
+		return nil
+	}
+	plays := make([]int, len(days))
+	for i, d := range days {
+		plays[i] = d.Plays
+	}
+	mean, sd := meanStdDev(plays)
+	c := &Consistency{Mean: mean}
+	if len(days) >= 2 {
+		c.StdDev = &sd
+	}
+	return c
+}
+
+// monthDays is the fewest days in a window summarized as monthly.
+const monthDays = 28
+
+// BusiestDay is the weekday with the most plays on average
+// over the trailing earbug.busiest.weeks.
+type BusiestDay struct {
+	Weekday string  `json:"weekday"`
+	Average float64 `json:"average"`
+}
+
+// weekdayAverages averages plays by weekday over the dates from to to,
+// counting dates without plays. Weekdays that don't occur are zero.
+func weekdayAverages(plays map[string]int, from, to string) [7]float64 {
+	var totals, dates [7]int
+	for _, day := range (window{from: from, to: to}).days() {
+		d, _ := time.Parse(dateLayout, day)
+		totals[d.Weekday()] += plays[day]
+		dates[d.Weekday()]++
+	}
+	var avgs [7]float64
+	for i := range avgs {
+		if dates[i] > 0 {
+			avgs[i] = float64(totals[i]) / float64(dates[i])
+		}
+	}
+	return avgs
+}
+
+// busiestWeekday picks the highest average, ties going to the weekday
+// earliest in a week starting on first. It returns -1 without plays.
+func busiestWeekday(avgs [7]float64, first time.Weekday) time.Weekday {
+	busiest := time.Weekday(-1)
+	var best float64
+	for i := 0; i < 7; i++ {
+		day := (first + time.Weekday(i)) % 7
+		if avgs[day] > best {
+			busiest, best = day, avgs[day]
+		}
+	}
+	return busiest
+}
+
+// busiestCounts tallies plays per date over the weeks ending on a date,
+// and the first date played.
+type busiestCounts struct {
+	from     string
+	to       string
+	earliest string
+	plays    map[string]int
+}
+
+func newBusiestCounts(to string, weeks int) *busiestCounts {
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return nil
+	}
+	return &busiestCounts{
+		from:     end.AddDate(0, 0, 1-7*weeks).Format(dateLayout),
+		to:       to,
+		earliest: to,
+		plays:    make(map[string]int),
+	}
+}
+
+func (c *busiestCounts) add(day string) {
+	if day < c.earliest {
+		c.earliest = day
+	}
+	if day >= c.from && day <= c.to {
+		c.plays[day]++
+	}
+}
+
+// busiest finds the busiest weekday over the counted weeks,
+// from the first play if later. It returns nil for less than a week of history.
+func (c *busiestCounts) busiest(first time.Weekday) *BusiestDay {
+	from := c.from
+	if c.earliest > from {
+		from = c.earliest
+	}
+	if len((window{from: from, to: c.to}).days()) < 7 {
+		return nil
+	}
+	avgs := weekdayAverages(c.plays, from, c.to)
+	day := busiestWeekday(avgs, first)
+	if day < 0 {
+		return nil
+	}
+	return &BusiestDay{Weekday: day.String(), Average: avgs[day]}
+}
+
+const (
+	streakOptimistic = "optimistic"
+	streakStrict     = "strict"
+)
+
+// Streak is the run of consecutive dates with plays up to today.
+type Streak struct {
+	Days int `json:"days"`
+	// Through is the last date of the run
+	Through string `json:"through"`
+}
+
+// listeningStreak counts the consecutive dates in days ending on today.
+// Today without plays yet ends the run with strict,
+// otherwise the run is counted through the day before:
+// a day in progress doesn't break a streak until it's over.
+// It returns nil without a run.
+func listeningStreak(days map[string]bool, today string, strict bool) *Streak {
+	d, err := time.Parse(dateLayout, today)
+	if err != nil {
+		return nil
+	}
+	if !days[today] && !strict {
+		d = d.AddDate(0, 0, -1)
+	}
+	st := Streak{Through: d.Format(dateLayout)}
+	for days[d.Format(dateLayout)] {
+		st.Days++
+		d = d.AddDate(0, 0, -1)
+	}
+	if st.Days == 0 {
+		return nil
+	}
+	return &st
+}
+
+// PeakHour is the hour of day with the most plays.
+type PeakHour struct {
+	Hour  int `json:"hour"`
+	Plays int `json:"plays"`
+	// Percent of the window's plays in the hour
+	Percent int `json:"percent"`
+}
+
+// peakHour finds the hour of day with the most plays,
+// the earliest on ties.
+func peakHour(plays []playback) *PeakHour {
+	if len(plays) == 0 {
+		return nil
+	}
+	var hours [24]int
+	for _, p := range plays {
+		hours[p.ts.Hour()]++
+	}
+	var peak int
+	for h, n := range hours {
+		if n > hours[peak] {
+			peak = h
+		}
+	}
+	return &PeakHour{
+		Hour:    peak,
+		Plays:   hours[peak],
+		Percent: hours[peak] * 100 / len(plays),
+	}
+}
+
+type Session struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Tracks   int           `json:"tracks"`
+}
+
+const (
+	podcastsExclude  = "exclude"
+	podcastsInclude  = "include"
+	podcastsSeparate = "separate"
+)
+
+// summaryConfig configures how summaries are computed.
+type summaryConfig struct {
+	loc        *time.Location
+	sessionGap time.Duration
+	// podcasts is one of podcastsExclude, podcastsInclude, podcastsSeparate
+	podcasts string
+	// groupBy names the grouper for ranking plays, none if empty
+	groupBy string
+	// allTime ranks the top tracks over the entire store
+	allTime bool
+	// context limits plays in the window to those from a context uri
+	context string
+	// duoMaxArtists caps artists per track when pairing them
+	duoMaxArtists int
+	// risingFactor is the increase over prior weekly plays for rising tracks,
+	// 0 to not look for them
+	risingFactor float64
+	// compare totals the day before single day windows
+	compare bool
+	// artists counts plays per artist for achievements
+	artists bool
+	// skipThreshold is the share of a track played before the next
+	// below which it counts as skipped, 0 to not compute skip rates
+	skipThreshold float64
+	// skipZeroDuration leaves out plays of tracks with no known duration
+	skipZeroDuration bool
+	// priorRanks finds where top tracks of multi day windows ranked
+	// in the window before
+	priorRanks bool
+	// newTrackList lists the new tracks, not just their count
+	newTrackList bool
+	// rankBy orders the top tracks, rankPlays if empty
+	rankBy string
+	// bingeTracks is the fewest plays in a row from an album to report,
+	// 0 to not look for them
+	bingeTracks int
+	// busiestWeeks is how many trailing weeks monthly windows
+	// find the busiest weekday over, 0 to not look for it
+	busiestWeeks int
+	// weekStart breaks ties between equally busy weekdays
+	weekStart time.Weekday
+	// streakToday is the current date to count the listening streak up to,
+	// empty to not count it
+	streakToday string
+	// streakStrict ends the streak on a current date without plays
+	streakStrict bool
+}
+
+func (s *Server) summaryConfig(loc *time.Location) summaryConfig {
+	return summaryConfig{
+		loc:              loc,
+		sessionGap:       s.sessionGap,
+		podcasts:         s.podcasts,
+		duoMaxArtists:    s.duoMaxArtists,
+		risingFactor:     s.risingFactor,
+		bingeTracks:      s.bingeTracks,
+		busiestWeeks:     s.busiestWeeks,
+		weekStart:        s.weekStart,
+		streakStrict:     s.streakToday == streakStrict,
+		skipThreshold:    s.skipThreshold,
+		skipZeroDuration: !s.zeroDurationPlays,
+	}
+}
+
+// warning is a note on degraded data, the label for key formatted with args.
+type warning struct {
+	key  string
+	args []any
+}
+
+// warn adds a warning, in english to Warnings.
+func (sum *Summary) warn(key string, args ...any) {
+	sum.Warnings = append(sum.Warnings, fmt.Sprintf(english[key], args...))
+	sum.warnings = append(sum.warnings, warning{key, args})
+}
+
+// warningTexts are the warnings in the requested language.
+func (sum *Summary) warningTexts(opts summaryOptions) []string {
+	texts := make([]string, len(sum.warnings))
+	for i, w := range sum.warnings {
+		texts[i] = fmt.Sprintf(opts.label(w.key), w.args...)
+	}
+	return texts
+}
+
+// isPodcast reports whether a play is of a podcast episode,
+// and whether any type information was available to decide.
+func isPodcast(played *earbugv3.Playback, track *earbugv3.Track) (podcast, known bool) {
+	switch track.GetType() {
+	case "episode":
+		return true, true
+	case "track":
+		return false, true
+	}
+	for _, uri := range []string{played.TrackUri, track.GetUri()} {
+		if strings.HasPrefix(uri, "spotify:episode:") {
+			return true, true
+		} else if strings.HasPrefix(uri, "spotify:track:") {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// aggregate summarizes the plays in data within the window,
+// with per day counts for windows longer than a day.
+// For last n plays windows, earlier plays in the window count as before it.
+// Every section is computed from a single pass over the playbacks.
+func aggregate(data *earbugv3.Store, user string, win window, cfg summaryConfig) *Summary {
+	sum := &Summary{
+		User:    user,
+		Date:    win.label,
+		Context: cfg.context,
+	}
+	if cfg.podcasts == podcastsSeparate {
+		sum.Podcasts = &Podcasts{}
+	}
+
+	group := groupers[cfg.groupBy]
+	var groups groupCounts
+
+	var allCounts map[string]int
+	if cfg.allTime {
+		allCounts = make(map[string]int)
+	}
+
+	var rising *risingCounts
+	if cfg.risingFactor > 0 {
+		rising = newRisingCounts(win.to)
+	}
+
+	var busiest *busiestCounts
+	if cfg.busiestWeeks > 0 && !win.single() && win.lastN == 0 && len(win.days()) >= monthDays {
+		busiest = newBusiestCounts(win.to, cfg.busiestWeeks)
+	}
+
+	// dates with plays, up to the current day whatever the window
+	var playDays map[string]bool
+	if cfg.streakToday != "" {
+		playDays = make(map[string]bool)
+	}
+
+	var priorDate string
+	var prior DayTotals
+	priorTracks := make(map[string]struct{})
+	if cfg.compare && win.single() {
+		if d, err := time.Parse(dateLayout, win.from); err == nil {
+			priorDate = d.AddDate(0, 0, -1).Format(dateLayout)
+		}
+	}
+
+	// plays per track in the window of the same length before this one
+	var priorFrom string
+	var priorCounts map[string]int
+	if cfg.priorRanks && !win.single() && win.lastN == 0 {
+		if d, err := time.Parse(dateLayout, win.from); err == nil {
+			priorFrom = d.AddDate(0, 0, -len(win.days())).Format(dateLayout)
+			priorCounts = make(map[string]int)
+		}
+	}
+
+	var earlierDays map[string]int
+	if win.single() && cfg.context == "" {
+		earlierDays = make(map[string]int)
+	}
+	// plays per track on the window's month and day in the latest earlier year
+	var thisDay string
+	var thisDayCounts map[string]int
+
+	playedBefore := make(map[string]struct{})
+	playedOn := make(map[string]int)
+	var plays []playback
+	var malformed int
+	for key, played := range data.Playbacks {
+		track := data.Tracks[played.TrackId]
+		if cfg.skipZeroDuration && track.GetDuration().AsDuration() <= 0 {
+			continue
+		}
+		podcast, known := isPodcast(played, track)
+		music := !podcast || cfg.podcasts == podcastsInclude
+		if allCounts != nil && music {
+			allCounts[played.TrackId]++
+		}
+
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			malformed++
+			continue
+		}
+		day := ts.In(cfg.loc).Format(dateLayout)
+		if busiest != nil && music {
+			busiest.add(day)
+		}
+		if playDays != nil && music {
+			playDays[day] = true
+		}
+		if day > win.to {
+			continue
+		}
+		if music {
+			sum.totalPlays++
+		}
+		if cfg.context != "" && day >= win.from {
+			// earlier plays from other contexts still make a track not new
+			if played.ContextUri != "" {
+				sum.contextKnown = true
+			}
+			if played.ContextUri != cfg.context {
+				continue
+			}
+		}
+
+		if !music {
+			if sum.Podcasts != nil && win.contains(day) && win.lastN == 0 {
+				sum.Podcasts.Plays++
+				sum.Podcasts.Listened += track.GetDuration().AsDuration()
+			}
+			continue
+		}
+
+		if rising != nil {
+			rising.add(day, played.TrackId)
+		}
+		if cfg.artists {
+			if sum.artistTotals == nil {
+				sum.artistTotals = make(map[string]int)
+			}
+			for _, a := range track.GetArtists() {
+				sum.artistTotals[a.Id]++
+			}
+		}
+
+		if day == priorDate {
+			prior.Plays++
+			prior.Listened += track.GetDuration().AsDuration()
+			priorTracks[played.TrackId] = struct{}{}
+		}
+
+		if day < win.from || !win.after.IsZero() && !ts.After(win.after) {
+			playedBefore[played.TrackId] = struct{}{}
+			if priorCounts != nil && day >= priorFrom {
+				priorCounts[played.TrackId]++
+			}
+			if earlierDays != nil {
+				earlierDays[day]++
+			}
+			if win.single() && day[4:] == win.from[4:] && day >= thisDay {
+				if day > thisDay {
+					thisDay, thisDayCounts = day, make(map[string]int)
+				}
+				thisDayCounts[played.TrackId]++
+			}
+			continue
+		}
+
+		plays = append(plays, playback{
+			ts:      ts.In(cfg.loc),
+			trackID: played.TrackId,
+			dur:     track.GetDuration().AsDuration(),
+			played:  played,
+			untyped: !known,
+		})
+	}
+	sortPlays(plays)
+	if win.lastN > 0 && len(plays) > win.lastN {
+		for _, p := range plays[:len(plays)-win.lastN] {
+			playedBefore[p.trackID] = struct{}{}
+		}
+		plays = plays[len(plays)-win.lastN:]
+	}
+
+	if cfg.artists {
+		sum.artistPlays = make(map[string]int)
+	}
+	var unresolved, noDuration int
+	for _, p := range plays {
+		if p.untyped {
+			sum.untyped++
+		}
+		if sum.artistPlays != nil {
+			for _, a := range data.Tracks[p.trackID].GetArtists() {
+				sum.artistPlays[a.Id]++
+			}
+		}
+		if _, ok := data.Tracks[p.trackID]; !ok {
+			unresolved++
+		} else if p.dur <= 0 {
+			noDuration++
+		}
+		playedOn[p.trackID]++
+		if group != nil {
+			groups.add(group(p.played, data.Tracks[p.trackID]))
+		}
+	}
+
+	sum.Plays = len(plays)
+	sum.Tracks = len(playedOn)
+	var newCounts map[string]int
+	if cfg.newTrackList {
+		newCounts = make(map[string]int)
+	}
+	for id, n := range playedOn {
+		if _, ok := playedBefore[id]; !ok {
+			sum.NewTracks++
+			if newCounts != nil {
+				newCounts[id] = n
+			}
+		}
+		if n == 1 {
+			sum.OneOffs++
+		}
+	}
+	for _, p := range plays {
+		sum.Listened += p.dur
+		if _, ok := playedBefore[p.trackID]; !ok && sum.FirstDiscovery == nil {
+			sum.FirstDiscovery = &Discovery{
+				At:   p.ts,
+				ID:   p.trackID,
+				Name: trackName(data, p.trackID),
+			}
+		}
+	}
+	if !win.single() {
+		perDay := make(map[string]int)
+		for _, p := range plays {
+			perDay[p.ts.Format(dateLayout)]++
+		}
+		tops := dailyTopTracks(data, plays)
+		for _, day := range win.days() {
+			dc := DayCount{
+				Date:  day,
+				Plays: perDay[day],
+			}
+			if top, ok := tops[day]; ok {
+				dc.Top = &top
+			}
+			sum.Days = append(sum.Days, dc)
+		}
+	}
+	if priorDate != "" && len(playedBefore) > 0 {
+		// without any earlier plays, this is the first day of data
+		prior.Tracks = len(priorTracks)
+		sum.Prior = &prior
+	}
+	if cfg.rankBy == rankAffinity {
+		// completion is needed for every track before cutting to topN
+		sum.Top = topTracks(data, playedOn, len(playedOn))
+		setCompletion(sum.Top, plays, cfg.sessionGap)
+		rankByAffinity(sum.Top)
+		if len(sum.Top) > topN {
+			sum.Top = sum.Top[:topN]
+		}
+		sum.RankBy = rankAffinity
+	} else {
+		sum.Top = topTracks(data, playedOn, topN)
+		setCompletion(sum.Top, plays, cfg.sessionGap)
+	}
+	if newCounts != nil {
+		sum.NewTrackList = topTracks(data, newCounts, newTrackListMax)
+	}
+	if priorCounts != nil {
+		setPriorRanks(sum.Top, topTracks(data, priorCounts, len(priorCounts)))
+	}
+	sum.Duo = topDuo(data, plays, cfg.duoMaxArtists)
+	if win.lastN == 0 {
+		sum.ArtistShift = artistShift(data, plays, win)
+	}
+	if rising != nil {
+		sum.Rising = rising.rising(data, cfg.risingFactor, risingTopN)
+	}
+	if busiest != nil {
+		sum.BusiestDay = busiest.busiest(cfg.weekStart)
+	}
+	if playDays != nil {
+		sum.Streak = listeningStreak(playDays, cfg.streakToday, cfg.streakStrict)
+	}
+	if allCounts != nil {
+		sum.AllTime = topTracks(data, allCounts, allTimeTopN)
+	}
+	if group != nil {
+		sum.GroupBy = cfg.groupBy
+		sum.Groups = groups.top(groupTopN)
+	}
+	if longest, ok := longestSession(sessions(plays, cfg.sessionGap)); ok {
+		sum.LongestSession = &Session{
+			Start:    longest.start,
+			Duration: longest.duration(),
+			Tracks:   longest.tracks,
+		}
+	}
+	sum.PeakHour = peakHour(plays)
+	if len(data.Tracks) == 0 {
+		// the caller notes the missing metadata once
+		unresolved = 0
+	}
+	if cfg.skipThreshold > 0 {
+		sum.SkipRate = skipRate(plays, cfg.sessionGap, cfg.skipThreshold)
+	}
+	for _, w := range []struct {
+		n   int
+		key string
+	}{
+		{malformed, "warnMalformed"},
+		{unresolved, "warnUnresolved"},
+		{noDuration, "warnNoDuration"},
+		{sum.untyped, "warnUntyped"},
+	} {
+		if w.n > 0 {
+			sum.warn(w.key, w.n)
+		}
+	}
+	if earlierDays != nil {
+		sum.Record = dailyRecord(sum.Plays, earlierDays)
+	}
+	if top := topTracks(data, thisDayCounts, 1); len(top) > 0 {
+		sum.OnThisDay = &OnThisDay{
+			Date:  thisDay,
+			Track: top[0],
+		}
+	}
+	if cfg.bingeTracks > 0 {
+		sum.Binges = albumBinges(plays, cfg.sessionGap, cfg.bingeTracks)
+	}
+	return sum
+}
+
+// sortPlays orders plays by time,
+// breaking ties on the same instant by track id,
+// so results don't depend on map iteration order.
+func sortPlays(plays []playback) {
+	sort.Slice(plays, func(i, j int) bool {
+		if !plays[i].ts.Equal(plays[j].ts) {
+			return plays[i].ts.Before(plays[j].ts)
+		}
+		return plays[i].trackID < plays[j].trackID
+	})
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type session struct {
+	start  time.Time
+	end    time.Time
+	tracks int
+}
+
+func (s session) duration() time.Duration {
+	return s.end.Sub(s.start)
+}
+
+// sessions groups sorted plays into sessions,
+// a new session starts when the time between the end of a play
+// and the start of the next is at least gap.
+func sessions(plays []playback, gap time.Duration) []session {
+	var out []session
+	for _, p := range plays {
+		end := p.ts.Add(p.dur)
+		if n := len(out); n > 0 && p.ts.Sub(out[n-1].end) < gap {
+			cur := &out[n-1]
+			cur.tracks++
+			if end.After(cur.end) {
+				cur.end = end
+			}
+			continue
+		}
+		out = append(out, session{
+			start:  p.ts,
+			end:    end,
+			tracks: 1,
+		})
+	}
+	return out
+}
+
+// longestSession returns the session with the longest duration,
+// preferring the earliest on ties.
+func longestSession(ss []session) (session, bool) {
+	var longest session
+	for i, s := range ss {
+		if i == 0 || s.duration() > longest.duration() {
+			longest = s
+		}
+	}
+	return longest, len(ss) > 0
+}
+
+// latestPlay returns the time of the most recent playback.
+func latestPlay(data *earbugv3.Store) (time.Time, bool) {
+	var latest time.Time
+	for key := range data.Playbacks {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err == nil && ts.After(latest) {
+			latest = ts
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// lastDays returns the n dates in loc up to and including the day before now,
+// oldest first.
+func lastDays(now time.Time, loc *time.Location, n int) []string {
+	now = now.In(loc)
+	dates := make([]string, n)
+	for i := range dates {
+		dates[i] = now.AddDate(0, 0, i-n).Format(dateLayout)
+	}
+	return dates
+}
+
+// dailyPlays counts the playbacks matching match on each of dates.
+func dailyPlays(data *earbugv3.Store, loc *time.Location, dates []string, match func(*earbugv3.Playback) bool) []int {
+	idx := make(map[string]int, len(dates))
+	for i, d := range dates {
+		idx[d] = i
+	}
+	counts := make([]int, len(dates))
+	for key, played := range data.Playbacks {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			continue
+		}
+		i, ok := idx[ts.In(loc).Format(dateLayout)]
+		if !ok || !match(played) {
+			continue
+		}
+		counts[i]++
+	}
+	return counts
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// largeStore has plays of tracks at intervals over days up to 2024-03-14 UTC,
+// plays per track skewed so counts differ.
+func largeStore(tracks, plays, days int) *earbugv3.Store {
+	data := &earbugv3.Store{
+		Playbacks: make(map[string]*earbugv3.Playback, plays),
+		Tracks:    make(map[string]*earbugv3.Track, tracks),
+	}
+	for i := 0; i < tracks; i++ {
+		id := fmt.Sprintf("t%d", i)
+		data.Tracks[id] = testTrack(id, "Track "+id, time.Duration(120+i%180)*time.Second, fmt.Sprintf("Artist %d", i%50))
+		data.Tracks[id].Type = "track"
+	}
+	end := time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)
+	step := time.Duration(days) * 24 * time.Hour / time.Duration(plays)
+	for i := 0; i < plays; i++ {
+		ts := end.Add(-time.Duration(i+1) * step)
+		id := fmt.Sprintf("t%d", (i*i)%tracks)
+		data.Playbacks[ts.Format(time.RFC3339)] = &earbugv3.Playback{TrackId: id}
+	}
+	return data
+}
+
+// naiveSummary computes the basic counts of aggregate
+// the way it was before, a pass over the playbacks per statistic.
+func naiveSummary(data *earbugv3.Store, win window, loc *time.Location) *Summary {
+	sum := &Summary{Date: win.label}
+	dayOf := func(key string) (string, time.Time, bool) {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			return "", ts, false
+		}
+		return ts.In(loc).Format(dateLayout), ts, true
+	}
+
+	// plays and per track counts
+	counts := make(map[string]int)
+	for key, played := range data.Playbacks {
+		if day, _, ok := dayOf(key); ok && win.contains(day) {
+			sum.Plays++
+			counts[played.TrackId]++
+		}
+	}
+	sum.Tracks = len(counts)
+
+	// listened time
+	for key, played := range data.Playbacks {
+		if day, _, ok := dayOf(key); ok && win.contains(day) {
+			sum.Listened += data.Tracks[played.TrackId].GetDuration().AsDuration()
+		}
+	}
+
+	// new tracks, not played before the window
+	before := make(map[string]struct{})
+	for key, played := range data.Playbacks {
+		if day, _, ok := dayOf(key); ok && day < win.from {
+			before[played.TrackId] = struct{}{}
+		}
+	}
+	for id, n := range counts {
+		if _, ok := before[id]; !ok {
+			sum.NewTracks++
+		}
+		if n == 1 {
+			sum.OneOffs++
+		}
+	}
+
+	// per day counts
+	if !win.single() {
+		perDay := make(map[string]int)
+		for key := range data.Playbacks {
+			if day, _, ok := dayOf(key); ok && win.contains(day) {
+				perDay[day]++
+			}
+		}
+		for _, day := range win.days() {
+			sum.Days = append(sum.Days, DayCount{Date: day, Plays: perDay[day]})
+		}
+	}
+
+	// peak hour
+	hours := make(map[int]int)
+	for key := range data.Playbacks {
+		if day, ts, ok := dayOf(key); ok && win.contains(day) {
+			hours[ts.In(loc).Hour()]++
+		}
+	}
+	peak := -1
+	for h, n := range hours {
+		if peak < 0 || n > hours[peak] || n == hours[peak] && h < peak {
+			peak = h
+		}
+	}
+	if peak >= 0 {
+		sum.PeakHour = &PeakHour{Hour: peak, Plays: hours[peak]}
+	}
+
+	// top tracks
+	for id, n := range counts {
+		sum.Top = append(sum.Top, TrackCount{ID: id, Plays: n})
+	}
+	sort.Slice(sum.Top, func(i, j int) bool {
+		if sum.Top[i].Plays != sum.Top[j].Plays {
+			return sum.Top[i].Plays > sum.Top[j].Plays
+		}
+		return sum.Top[i].ID < sum.Top[j].ID
+	})
+	return sum
+}
+
+func TestAggregateMatchesNaive(t *testing.T) {
+	data := largeStore(200, 5000, 60)
+	win := window{label: "week", from: "2024-03-08", to: "2024-03-14"}
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	got := aggregate(data, "user", win, cfg)
+	want := naiveSummary(data, win, time.UTC)
+	if got.Plays != want.Plays || got.Tracks != want.Tracks || got.NewTracks != want.NewTracks ||
+		got.OneOffs != want.OneOffs || got.Listened != want.Listened {
+		t.Errorf("aggregate %d plays %d tracks %d new %d one-offs %v, naive %d %d %d %d %v",
+			got.Plays, got.Tracks, got.NewTracks, got.OneOffs, got.Listened,
+			want.Plays, want.Tracks, want.NewTracks, want.OneOffs, want.Listened)
+	}
+	for i, d := range want.Days {
+		if i >= len(got.Days) || got.Days[i].Plays != d.Plays {
+			t.Errorf("day %s: got %v, want %d plays", d.Date, got.Days, d.Plays)
+			break
+		}
+	}
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	data := largeStore(2000, 100000, 365)
+	win := window{label: "week", from: "2024-03-08", to: "2024-03-14"}
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	b.Run("single-pass", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			aggregate(data, "user", win, cfg)
+		}
+	})
+	b.Run("multi-pass", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			naiveSummary(data, win, time.UTC)
+		}
+	})
+}
+
+// mixedOffsets are plays on 2024-03-14 UTC whose keys sort lexically
+// in the reverse of their order in time.
+var mixedOffsets = map[string][]string{
+	"t3": {"2024-03-14T05:00:00-05:00"}, // 10:00Z
+	"t1": {"2024-03-14T09:00:00Z"},
+	"t2": {"2024-03-14T10:30:00+02:00"}, // 08:30Z
+}
+
+func TestMixedOffsetOrder(t *testing.T) {
+	data := testStore(mixedOffsets)
+
+	latest, ok := latestPlay(data)
+	if want := time.Date(2024, time.March, 14, 10, 0, 0, 0, time.UTC); !ok || !latest.Equal(want) {
+		t.Errorf("latestPlay = %v, want %v", latest, want)
+	}
+
+	var plays []playback
+	for key, played := range data.Playbacks {
+		ts, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plays = append(plays, playback{ts: ts, trackID: played.TrackId})
+	}
+	sortPlays(plays)
+	var order []string
+	for _, p := range plays {
+		order = append(order, p.trackID)
+	}
+	if got := strings.Join(order, ","); got != "t2,t1,t3" {
+		t.Errorf("sortPlays order %s, want t2,t1,t3", got)
+	}
+
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	sum := aggregate(data, "user", dayWindow("2024-03-14"), cfg)
+	if d := sum.FirstDiscovery; d == nil || d.ID != "t2" || !d.At.Equal(time.Date(2024, time.March, 14, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("first discovery %+v, want t2 at 08:30Z", d)
+	}
+	// t2 ends 08:34, t1 starts within the gap, t3 an hour later
+	if ls := sum.LongestSession; ls == nil || ls.Tracks != 2 || !ls.Start.Equal(time.Date(2024, time.March, 14, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("longest session %+v, want 2 tracks from 08:30Z", ls)
+	}
+}
+
+func TestMixedOffsetDays(t *testing.T) {
+	// 23:30 at -02:00 is the next day in UTC
+	data := testStore(map[string][]string{"t1": {"2024-03-13T23:30:00-02:00", "2024-03-14T00:30:00+02:00"}})
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	if sum := aggregate(data, "user", dayWindow("2024-03-14"), cfg); sum.Plays != 1 {
+		t.Errorf("got %d plays on 2024-03-14 UTC, want 1", sum.Plays)
+	}
+	if sum := aggregate(data, "user", dayWindow("2024-03-13"), cfg); sum.Plays != 1 {
+		t.Errorf("got %d plays on 2024-03-13 UTC, want 1", sum.Plays)
+	}
+}
+
+func TestBusiestDay(t *testing.T) {
+	// a play a day, two on Tuesdays, from 2024-02-01
+	var plays []string
+	end := time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)
+	for d := time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC); d.Before(end); d = d.AddDate(0, 0, 1) {
+		plays = append(plays, d.Format(time.RFC3339))
+		if d.Weekday() == time.Tuesday {
+			plays = append(plays, d.Add(time.Hour).Format(time.RFC3339))
+		}
+	}
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, busiestWeeks: 8, weekStart: time.Monday}
+	win := window{label: "2024-02-15", from: "2024-02-15", to: "2024-03-14"}
+	sum := aggregate(testStore(map[string][]string{"t1": plays}), "user", win, cfg)
+	if want := (&BusiestDay{Weekday: "Tuesday", Average: 2}); sum.BusiestDay == nil || *sum.BusiestDay != *want {
+		t.Errorf("busiest day %+v, want %+v", sum.BusiestDay, want)
+	}
+
+	// less than a week since the first play
+	sum = aggregate(testStore(map[string][]string{"t1": plays[len(plays)-5:]}), "user", win, cfg)
+	if sum.BusiestDay != nil {
+		t.Errorf("busiest day %+v from 5 days of plays, want none", sum.BusiestDay)
+	}
+}
+
+// BenchmarkLargeStore compares the full pass aggregate makes per summary
+// with limiting the scan to the window through a sorted index of plays,
+// built per request as the store is read fresh, or ahead of time.
+func BenchmarkLargeStore(b *testing.B) {
+	data := largeStore(5000, 300000, 3*365)
+	win := window{label: "2024-03-14", from: "2024-03-14", to: "2024-03-14"}
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	index := func() []playback {
+		plays := make([]playback, 0, len(data.Playbacks))
+		for key, played := range data.Playbacks {
+			ts, err := time.Parse(time.RFC3339, key)
+			if err == nil {
+				plays = append(plays, playback{ts: ts, trackID: played.TrackId})
+			}
+		}
+		sortPlays(plays)
+		return plays
+	}
+	inWindow := func(plays []playback) int {
+		from := time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC)
+		i := sort.Search(len(plays), func(i int) bool { return !plays[i].ts.Before(from) })
+		j := sort.Search(len(plays), func(i int) bool { return !plays[i].ts.Before(from.AddDate(0, 0, 1)) })
+		return j - i
+	}
+
+	b.Run("aggregate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			aggregate(data, "user", win, cfg)
+		}
+	})
+	b.Run("index-per-request", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			inWindow(index())
+		}
+	})
+	b.Run("index-prebuilt", func(b *testing.B) {
+		plays := index()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			inWindow(plays)
+		}
+	})
+}
+
+func TestOneOffs(t *testing.T) {
+	data := &earbugv3.Store{
+		Playbacks: make(map[string]*earbugv3.Playback),
+		Tracks:    make(map[string]*earbugv3.Track),
+	}
+	tally := map[string]int{"a": 1, "b": 1, "c": 2, "d": 3, "e": 1, "old": 1}
+	for id, n := range tally {
+		data.Tracks[id] = testTrack(id, "Track "+id, 3*time.Minute, "Ann")
+		for i := 0; i < n; i++ {
+			ts := time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC).Add(time.Duration(len(data.Playbacks)) * time.Hour)
+			data.Playbacks[ts.Format(time.RFC3339)] = &earbugv3.Playback{TrackId: id}
+		}
+	}
+	// played before, so a one-off that isn't new
+	data.Playbacks["2024-03-01T08:00:00Z"] = &earbugv3.Playback{TrackId: "old"}
+
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, skipZeroDuration: true}
+	sum := aggregate(data, "user", dayWindow("2024-03-14"), cfg)
+	if sum.Tracks != 6 || sum.OneOffs != 4 || sum.NewTracks != 5 {
+		t.Errorf("got %d tracks, %d one-offs, %d new, want 6, 4, 5", sum.Tracks, sum.OneOffs, sum.NewTracks)
+	}
+	opts, _ := parseSummaryOptions(nil)
+	if got, want := sections["tracks"](sum, opts), "6 tracks (5 new, 4 one-offs)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListeningStreak(t *testing.T) {
+	through := func(last string, n int) map[string]bool {
+		days := make(map[string]bool)
+		d, _ := time.Parse(dateLayout, last)
+		for i := 0; i < n; i++ {
+			days[d.AddDate(0, 0, -i).Format(dateLayout)] = true
+		}
+		return days
+	}
+	tests := []struct {
+		name   string
+		days   map[string]bool
+		strict bool
+		want   *Streak
+	}{
+		// today already has plays, it counts either way
+		{"fresh today optimistic", through("2024-03-14", 5), false, &Streak{Days: 5, Through: "2024-03-14"}},
+		{"fresh today strict", through("2024-03-14", 5), true, &Streak{Days: 5, Through: "2024-03-14"}},
+		// no plays today yet
+		{"empty today optimistic", through("2024-03-13", 4), false, &Streak{Days: 4, Through: "2024-03-13"}},
+		{"empty today strict", through("2024-03-13", 4), true, nil},
+		// yesterday empty ends the run in both
+		{"gap yesterday optimistic", through("2024-03-12", 4), false, nil},
+		{"gap yesterday strict", through("2024-03-12", 4), true, nil},
+		// runs cross month and leap day boundaries
+		{"across months", through("2024-03-14", 20), false, &Streak{Days: 20, Through: "2024-03-14"}},
+		{"none", map[string]bool{}, false, nil},
+	}
+	for _, tt := range tests {
+		got := listeningStreak(tt.days, "2024-03-14", tt.strict)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStreakSection(t *testing.T) {
+	// daily plays from 2024-03-10 to yesterday, 2024-03-14, none yet on 2024-03-15
+	var plays []string
+	for d := 10; d <= 14; d++ {
+		plays = append(plays, time.Date(2024, time.March, d, 12, 0, 0, 0, time.UTC).Format(time.RFC3339))
+	}
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(map[string][]string{"t1": plays}))
+	for mode, want := range map[string]string{
+		streakOptimistic: "2024-03-14 | 5 day streak",
+		streakStrict:     "2024-03-14",
+	} {
+		s := newTestServer(t, store, nil, map[string]string{
+			"earbug.posting.enabled": "false",
+			"earbug.streak.today":    mode,
+		})
+		rw := serve(s, http.MethodPost, "/summary?user=alice&fields=streak&warnings=false", "", nil)
+		if got := rw.Body.String(); rw.Code != http.StatusOK || got != want {
+			t.Errorf("%s: got %d %q, want %q", mode, rw.Code, got, want)
+		}
+	}
+
+	// plays after the window still extend the streak
+	cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, streakToday: "2024-03-14"}
+	sum := aggregate(testStore(map[string][]string{"t1": plays}), "user", dayWindow("2024-03-11"), cfg)
+	if want := (Streak{Days: 5, Through: "2024-03-14"}); sum.Streak == nil || *sum.Streak != want {
+		t.Errorf("streak %+v, want %+v", sum.Streak, want)
+	}
+}
+
+func TestMeanStdDev(t *testing.T) {
+	tests := []struct {
+		ns       []int
+		mean, sd float64
+	}{
+		{nil, 0, 0},
+		{[]int{3}, 3, 0},
+		{[]int{1, 1, 1}, 1, 0},
+		{[]int{0, 10}, 5, 5},
+		{[]int{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2},
+		{[]int{1, 2, 3, 4}, 2.5, math.Sqrt(1.25)},
+	}
+	for _, tt := range tests {
+		mean, sd := meanStdDev(tt.ns)
+		if math.Abs(mean-tt.mean) > 1e-9 || math.Abs(sd-tt.sd) > 1e-9 {
+			t.Errorf("meanStdDev(%v) = %v, %v, want %v, %v", tt.ns, mean, sd, tt.mean, tt.sd)
+		}
+	}
+}
+
+func TestDailyConsistency(t *testing.T) {
+	if c := dailyConsistency(nil); c != nil {
+		t.Errorf("no days: got %+v, want nil", c)
+	}
+	opts, _ := parseSummaryOptions(nil)
+
+	one := dailyConsistency([]DayCount{{Date: "2024-03-14", Plays: 6}})
+	if one.Mean != 6 || one.StdDev != nil {
+		t.Errorf("one day: got %+v, want mean 6 without a deviation", one)
+	}
+	if got, want := sections["consistency"](&Summary{Consistency: one}, opts), "avg 6/day"; got != want {
+		t.Errorf("one day: got %q, want %q", got, want)
+	}
+
+	two := dailyConsistency([]DayCount{{Date: "2024-03-13", Plays: 1}, {Date: "2024-03-14", Plays: 9}})
+	if two.Mean != 5 || two.StdDev == nil || *two.StdDev != 4 {
+		t.Errorf("two days: got %+v, want mean 5, deviation 4", two)
+	}
+	if got, want := sections["consistency"](&Summary{Consistency: two}, opts), "avg 5/day (σ 4)"; got != want {
+		t.Errorf("two days: got %q, want %q", got, want)
+	}
+}
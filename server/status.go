@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type status struct {
+	// Breaker is for the setup selected by X-Tenant, the flags' if unset
+	Breaker breakerStatus `json:"breaker"`
+	// Tenants has the breaker of each tenant in earbug.tenants
+	Tenants map[string]breakerStatus `json:"tenants,omitempty"`
+}
+
+// status reports the state of the service.
+func (s *Server) status(rw http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	st := status{
+		Breaker: s.breakerFor(r.Context()).status(now, s.breakerFailures),
+	}
+	for _, t := range s.reloaded.Load().tenants {
+		if st.Tenants == nil {
+			st.Tenants = make(map[string]breakerStatus)
+		}
+		st.Tenants[t.Name] = t.breaker.status(now, s.breakerFailures)
+	}
+	rw.Header().Set("content-type", "application/json")
+	json.NewEncoder(rw).Encode(st)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// ObjectStore is the subset of bucket operations the server uses.
+// NewReader returns an error wrapping storage.ErrObjectNotExist
+// for missing objects.
+type ObjectStore interface {
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	NewWriter(ctx context.Context, name, contentType string) io.WriteCloser
+	// List returns the names of objects with the given suffix.
+	List(ctx context.Context, suffix string) ([]string, error)
+}
+
+// SetObjectStore replaces the storage bucket,
+// it must be called before Init.
+func (s *Server) SetObjectStore(store ObjectStore) {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	s.store = store
+}
+
+type gcsStore struct {
+	name string
+	bkt  *storage.BucketHandle
+}
+
+func (g *gcsStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	or, err := g.bkt.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gcsReader{or, g.name}, nil
+}
+
+// NewCreateWriter writes the object only if it doesn't exist.
+func (g *gcsStore) NewCreateWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	ow := g.bkt.Object(name).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	ow.ContentType = contentType
+	return ow
+}
+
+// NewGenerationWriter writes the object only if it's at generation,
+// or doesn't exist for 0.
+func (g *gcsStore) NewGenerationWriter(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	cond := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+	ow := g.bkt.Object(name).If(cond).NewWriter(ctx)
+	ow.ContentType = contentType
+	return ow
+}
+
+type gcsReader struct {
+	*storage.Reader
+	bucket string
+}
+
+func (r gcsReader) Bucket() string {
+	return r.bucket
+}
+
+func (r gcsReader) Generation() int64 {
+	return r.Attrs.Generation
+}
+
+func (r gcsReader) ContentType() string {
+	return r.Attrs.ContentType
+}
+
+func (r gcsReader) ContentEncoding() string {
+	return r.Attrs.ContentEncoding
+}
+
+func (g *gcsStore) NewWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	ow := g.bkt.Object(name).NewWriter(ctx)
+	ow.ContentType = contentType
+	return ow
+}
+
+func (g *gcsStore) List(ctx context.Context, suffix string) ([]string, error) {
+	var names []string
+	it := g.bkt.Objects(ctx, &storage.Query{})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(attrs.Name, suffix) {
+			names = append(names, attrs.Name)
+		}
+	}
+	return names, nil
+}
+
+// objects returns the object store of the request's tenant if any,
+// or the earbug.bucket store,
+// creating the storage client if there is none.
+// A failed creation is retried on the next call
+// instead of leaving the service without a client.
+func (s *Server) objects(ctx context.Context) (ObjectStore, error) {
+	if t := tenantFrom(ctx); t != nil {
+		return t.objects(ctx)
+	}
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	if s.store != nil {
+		return s.store, nil
+	}
+
+	store, err := openBuckets(ctx, s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	s.store = store
+	return s.store, nil
+}
+
+// openBuckets creates a store for a comma separated list of bucket replicas.
+func openBuckets(ctx context.Context, buckets string) (ObjectStore, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create storage client: %w", err)
+	}
+	var stores []ObjectStore
+	for _, name := range strings.Split(buckets, ",") {
+		name = strings.TrimSpace(name)
+		stores = append(stores, &gcsStore{name, client.Bucket(name)})
+	}
+	if len(stores) == 1 {
+		return stores[0], nil
+	}
+	return &failoverStore{stores}, nil
+}
+
+// bucketReader is implemented by object readers
+// that know which bucket they read from.
+type bucketReader interface {
+	Bucket() string
+}
+
+// failoverStore reads from the first of its replicas able to serve an object,
+// including when the object is missing from earlier ones.
+// Writes go to the first replica, conditional ones too.
+type failoverStore struct {
+	stores []ObjectStore
+}
+
+// primary is the replica written to.
+func (f *failoverStore) primary() ObjectStore {
+	return f.stores[0]
+}
+
+// writable is the replica of store that writes go to,
+// for reads that must see the generation a conditional write checks.
+func writable(store ObjectStore) ObjectStore {
+	if f, ok := store.(*failoverStore); ok {
+		return f.primary()
+	}
+	return store
+}
+
+func (f *failoverStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	var err error
+	var errs []string
+	for _, store := range f.stores {
+		var or io.ReadCloser
+		or, err = store.NewReader(ctx, name)
+		if err == nil {
+			return or, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("read %s from all buckets: %s: %w", name, strings.Join(errs, "; "), err)
+}
+
+func (f *failoverStore) NewWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return f.primary().NewWriter(ctx, name, contentType)
+}
+
+// NewCreateWriter writes the object to the primary only if it doesn't exist there.
+// A primary without conditional writes writes unconditionally,
+// openBuckets only makes replicas with them.
+func (f *failoverStore) NewCreateWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	if cw, ok := f.primary().(createWriter); ok {
+		return cw.NewCreateWriter(ctx, name, contentType)
+	}
+	return f.primary().NewWriter(ctx, name, contentType)
+}
+
+// NewGenerationWriter writes the object to the primary only if it's at generation there.
+func (f *failoverStore) NewGenerationWriter(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	if gw, ok := f.primary().(generationWriter); ok {
+		return gw.NewGenerationWriter(ctx, name, contentType, generation)
+	}
+	return f.primary().NewWriter(ctx, name, contentType)
+}
+
+func (f *failoverStore) List(ctx context.Context, suffix string) ([]string, error) {
+	var err error
+	for _, store := range f.stores {
+		var names []string
+		names, err = store.List(ctx, suffix)
+		if err == nil {
+			return names, nil
+		}
+	}
+	return nil, err
+}
+
+// checkCredentials drops the storage client after credential errors,
+// so the next request recreates it with fresh credentials,
+// e.g. during credential rotation.
+// With a tenant in ctx, it's the tenant's client that's dropped.
+func (s *Server) checkCredentials(ctx context.Context, err error) {
+	var retrieveErr *oauth2.RetrieveError
+	var apiErr *googleapi.Error
+	if !errors.As(err, &retrieveErr) && !(errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized) {
+		return
+	}
+
+	mu, store := &s.storeMu, &s.store
+	if t := tenantFrom(ctx); t != nil {
+		mu, store = &t.storeMu, &t.store
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	switch (*store).(type) {
+	case *gcsStore, *failoverStore:
+		s.log.Info("dropping storage client after credential error", "tenant", tenantScoped(ctx, ""), "err", err.Error())
+		*store = nil
+	}
+}
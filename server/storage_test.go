@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// genStore is a memStore with object generations and conditional writes,
+// failing them on Close with 412 Precondition Failed as gcs does.
+type genStore struct {
+	memStore
+	gens map[string]int64
+}
+
+type genReader struct {
+	io.ReadCloser
+	gen int64
+}
+
+func (r genReader) Generation() int64 { return r.gen }
+
+func (g *genStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	or, err := g.memStore.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return genReader{or, g.gens[name]}, nil
+}
+
+func (g *genStore) NewWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return &genWriter{g: g, name: name, generation: -1}
+}
+
+func (g *genStore) NewCreateWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return &genWriter{g: g, name: name}
+}
+
+func (g *genStore) NewGenerationWriter(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	return &genWriter{g: g, name: name, generation: generation}
+}
+
+type genWriter struct {
+	g    *genStore
+	name string
+	// generation the object must be at, 0 for missing, -1 for any
+	generation int64
+	buf        bytes.Buffer
+}
+
+func (w *genWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+func (w *genWriter) Close() error {
+	g := w.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if w.generation >= 0 && g.gens[w.name] != w.generation {
+		return &googleapi.Error{Code: http.StatusPreconditionFailed}
+	}
+	if g.objects == nil {
+		g.objects = make(map[string][]byte)
+	}
+	if g.gens == nil {
+		g.gens = make(map[string]int64)
+	}
+	g.objects[w.name] = w.buf.Bytes()
+	g.gens[w.name]++
+	return nil
+}
+
+func TestFailoverConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := &genStore{}, &genStore{}
+	f := &failoverStore{[]ObjectStore{primary, secondary}}
+
+	write := func(ow io.WriteCloser) error {
+		ow.Write([]byte("{}"))
+		return ow.Close()
+	}
+	if err := write(f.NewCreateWriter(ctx, "a", "application/json")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	var apiErr *googleapi.Error
+	if err := write(f.NewCreateWriter(ctx, "a", "application/json")); !errors.As(err, &apiErr) || apiErr.Code != http.StatusPreconditionFailed {
+		t.Errorf("create existing: got %v, want 412", err)
+	}
+	if err := write(f.NewGenerationWriter(ctx, "a", "application/json", 1)); err != nil {
+		t.Errorf("write at generation: %v", err)
+	}
+	if err := write(f.NewGenerationWriter(ctx, "a", "application/json", 1)); !errors.As(err, &apiErr) || apiErr.Code != http.StatusPreconditionFailed {
+		t.Errorf("write at old generation: got %v, want 412", err)
+	}
+	if primary.gens["a"] != 2 || len(secondary.objects) != 0 {
+		t.Errorf("got primary generation %d, %d secondary objects, want 2 and 0", primary.gens["a"], len(secondary.objects))
+	}
+
+	// a primary without conditional writes writes unconditionally
+	plain := &memStore{}
+	f = &failoverStore{[]ObjectStore{plain, secondary}}
+	if err := write(f.NewGenerationWriter(ctx, "b", "application/json", 5)); err != nil || plain.objects["b"] == nil {
+		t.Errorf("plain primary: got %v, want written", err)
+	}
+}
+
+func TestCursorFailover(t *testing.T) {
+	// only the secondary has a cursor, its generation means nothing to the primary
+	ctx := context.Background()
+	primary, secondary := &genStore{}, &genStore{}
+	ow := secondary.NewWriter(ctx, cursorName("c", "alice"), "application/json")
+	ow.Write([]byte(`{"after":"2024-03-14T00:00:00Z"}`))
+	ow.Close()
+	s := newTestServer(t, &failoverStore{[]ObjectStore{primary, secondary}}, nil, map[string]string{"earbug.posting.enabled": "false"})
+
+	_, gen, err := s.readCursor(ctx, "c", "alice")
+	if err != nil || gen != 0 {
+		t.Fatalf("got generation %d, %v, want 0 from the primary", gen, err)
+	}
+	err = s.writeCursor(ctx, "c", "alice", testNow, gen)
+	if err != nil {
+		t.Errorf("write cursor: %v", err)
+	}
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	framingSingle    = "single"
+	framingDelimited = "delimited"
+)
+
+func validFraming(framing string) error {
+	switch framing {
+	case framingSingle, framingDelimited:
+		return nil
+	}
+	return fmt.Errorf("unknown framing %q, expected %s or %s", framing, framingSingle, framingDelimited)
+}
+
+const (
+	storeSuffix = ".pb.zstd"
+	// gzipStoreSuffix is read when there's no object with storeSuffix.
+	gzipStoreSuffix = ".pb.gz"
+)
+
+// loadedStore is a decoded store
+// with metadata about the object it was read from.
+type loadedStore struct {
+	*earbugv3.Store
+	user string
+	// generation of the object, 0 if unknown
+	generation int64
+	// bucket the object was read from, empty if unknown
+	bucket string
+	// members of a merged group store
+	members []MemberShare
+	// hash is the first 8 hex digits of the sha256 of the decompressed object
+	hash string
+	// truncated is the number of older playbacks dropped
+	// to stay within earbug.maxplaybacks
+	truncated int
+	// partial is set for a store recovered from a truncated object
+	partial bool
+	// latest is the time of the most recent playback, zero without any
+	latest time.Time
+}
+
+// generationReader is implemented by object readers
+// that know the generation of the object being read.
+type generationReader interface {
+	Generation() int64
+}
+
+// contentReader is implemented by object readers
+// that know the object's content metadata.
+type contentReader interface {
+	ContentType() string
+	ContentEncoding() string
+}
+
+const (
+	encodingIdentity = "identity"
+	encodingZstd     = "zstd"
+	encodingGzip     = "gzip"
+)
+
+// objectEncoding picks how the object name read by or is compressed,
+// from its content encoding or type if set, falling back to its suffix.
+// It returns an empty encoding if neither says.
+func objectEncoding(name string, or io.Reader) (string, error) {
+	if cr, ok := or.(contentReader); ok {
+		switch enc := cr.ContentEncoding(); enc {
+		case "":
+		case encodingGzip:
+			// gcs decompresses these on read (decompressive transcoding),
+			// sniff what we actually got
+			return "", nil
+		case encodingZstd, encodingIdentity:
+			return enc, nil
+		default:
+			return "", fmt.Errorf("unsupported content encoding %q for %s", enc, name)
+		}
+		switch cr.ContentType() {
+		case "application/zstd":
+			return encodingZstd, nil
+		case "application/gzip":
+			return encodingGzip, nil
+		case "application/x-protobuf", "application/protobuf":
+			return encodingIdentity, nil
+		}
+	}
+	switch {
+	case strings.HasSuffix(name, ".zstd"), strings.HasSuffix(name, ".zst"):
+		return encodingZstd, nil
+	case strings.HasSuffix(name, ".gz"):
+		return encodingGzip, nil
+	case strings.HasSuffix(name, ".pb"):
+		return encodingIdentity, nil
+	}
+	return "", nil
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// sniffEncoding picks the encoding from the magic bytes at the start of br,
+// uncompressed if there's no match.
+func sniffEncoding(br *bufio.Reader) string {
+	b, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(b, zstdMagic):
+		return encodingZstd
+	case bytes.HasPrefix(b, gzipMagic):
+		return encodingGzip
+	}
+	return encodingIdentity
+}
+
+// readObject reads and decompresses the object name read by or,
+// also writing the decompressed bytes to tee if not nil.
+// The encoding is picked per object, so stores of different users
+// may be compressed differently.
+func readObject(name string, or io.Reader, tee io.Writer) ([]byte, error) {
+	enc, err := objectEncoding(name, or)
+	if err != nil {
+		return nil, err
+	}
+	if enc == "" {
+		br := bufio.NewReader(or)
+		enc, or = sniffEncoding(br), br
+	}
+	switch enc {
+	case encodingZstd:
+		zr, err := zstd.NewReader(or)
+		if err != nil {
+			return nil, fmt.Errorf("create zstd reader for %s: %w", name, err)
+		}
+		defer zr.Close()
+		or = zr
+	case encodingGzip:
+		gr, err := gzip.NewReader(or)
+		if err != nil {
+			return nil, fmt.Errorf("create gzip reader for %s: %w", name, err)
+		}
+		defer gr.Close()
+		or = gr
+	}
+	if tee != nil {
+		or = io.TeeReader(or, tee)
+	}
+	b, err := io.ReadAll(or)
+	if err != nil {
+		// what was read is kept for recovering truncated objects
+		return b, fmt.Errorf("read %s as %s: %w", name, enc, err)
+	}
+	return b, nil
+}
+
+// objectReader is the part of an ObjectStore needed to read stores.
+type objectReader interface {
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// readStore reads and decodes the store object for user from the bucket.
+// Concurrent reads for a user share a single read,
+// callers must not modify the returned store.
+// The shared read outlives any one caller giving up,
+// each caller waits only as long as its own ctx.
+func (s *Server) readStore(ctx context.Context, user string, timing *serverTiming) (*loadedStore, error) {
+	type result struct {
+		ls     *loadedStore
+		timing *serverTiming
+	}
+	ch := s.loads.DoChan(tenantScoped(ctx, user), func() (any, error) {
+		shared := &serverTiming{}
+		ls, err := s.readStoreFrom(detached{ctx}, user, nil, shared)
+		return result{ls, shared}, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, stageError(stageAbandoned, http.StatusServiceUnavailable, ctx.Err())
+	case r := <-ch:
+		if r.Shared {
+			s.log.V(1).Info("shared concurrent store read", "user", user)
+		}
+		res := r.Val.(result)
+		timing.stages = append(timing.stages, res.timing.stages...)
+		return res.ls, r.Err
+	}
+}
+
+// detached keeps the values of a context, such as the tenant and trace,
+// without its cancellation or deadline.
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+func (d detached) Value(key any) any         { return d.parent.Value(key) }
+
+// readStoreFrom reads and decodes the store object for user from store,
+// the bucket if nil. Only bucket reads go through the circuit breaker.
+// Failures are a *readError.
+func (s *Server) readStoreFrom(ctx context.Context, user string, store objectReader, timing *serverTiming) (*loadedStore, error) {
+	ctx, span := s.trace.Start(ctx, "read-data")
+	defer span.End()
+
+	start := time.Now()
+	br := s.breakerFor(ctx)
+	if store != nil {
+		// failures elsewhere say nothing about the bucket
+		br = &breaker{}
+	}
+	if !br.allow(start, s.breakerFailures) {
+		return nil, stageError(stageUnavailable, http.StatusServiceUnavailable, errors.New("bucket reads failing, circuit breaker open"))
+	}
+	if store == nil {
+		var err error
+		store, err = s.objects(ctx)
+		if err != nil {
+			br.done(err, time.Now(), s.breakerFailures, s.breakerCooldown)
+			return nil, stageError(stageBucket, http.StatusInternalServerError, err)
+		}
+	}
+	key := user + storeSuffix
+	or, err := store.NewReader(ctx, key)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		key = user + gzipStoreSuffix
+		or, err = store.NewReader(ctx, key)
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		br.done(err, time.Now(), s.breakerFailures, s.breakerCooldown)
+		return nil, stageError(stageNotFound, http.StatusNotFound, err)
+	} else if err != nil {
+		br.done(err, time.Now(), s.breakerFailures, s.breakerCooldown)
+		s.checkCredentials(ctx, err)
+		return nil, stageError(stageOpen, http.StatusInternalServerError, err)
+	}
+	defer or.Close()
+
+	h := sha256.New()
+	b, err := readObject(key, or, h)
+	truncated := errors.Is(err, io.ErrUnexpectedEOF)
+	if truncated {
+		// the object is broken, not the bucket
+		br.done(nil, time.Now(), s.breakerFailures, s.breakerCooldown)
+		span.AddEvent("truncated store object", trace.WithAttributes(
+			attribute.String("user", user),
+			attribute.String("object", key),
+			attribute.Int("bytes", len(b)),
+		))
+		if !s.recoverTruncated {
+			return nil, stageError(stageTruncated, http.StatusInternalServerError, err)
+		}
+	} else {
+		br.done(err, time.Now(), s.breakerFailures, s.breakerCooldown)
+		if err != nil {
+			return nil, stageError(stageRead, http.StatusInternalServerError, err)
+		}
+	}
+	timing.add("read", start)
+	s.storeBytes.Record(ctx, int64(len(b)), attribute.Int("user_bucket", userBucket(user)))
+
+	start = time.Now()
+	defer timing.add("decode", start)
+
+	var data *earbugv3.Store
+	if truncated {
+		data, err = decodeTruncated(b, s.framing)
+		if err != nil {
+			return nil, stageError(stageTruncated, http.StatusInternalServerError, err)
+		}
+		s.log.Info("recovered complete records of truncated store", "user", user, "object", key, "bytes", len(b))
+	} else {
+		data, err = decodeStore(b, s.framing)
+		if err != nil {
+			return nil, stageError(stageDecode, http.StatusInternalServerError, err)
+		}
+	}
+	if !s.schemaAhead.Load() {
+		if n := unknownBytes(data.ProtoReflect()); n > 0 {
+			s.schemaAhead.Store(true)
+			s.log.Info("store has unknown fields, the earbug schema may be ahead of this service", "user", user, "unknown_bytes", n)
+		}
+	}
+	excludePlaybacks(data, s.excluded)
+	dropped := capPlaybacks(data, s.maxPlaybacks)
+	if dropped > 0 {
+		s.log.Info("store over earbug.maxplaybacks, dropped oldest", "user", user, "dropped", dropped)
+	}
+	if s.metadataObject != "" {
+		shared, err := s.sharedTracks(ctx)
+		if err != nil {
+			return nil, stageError(stageMetadata, http.StatusInternalServerError, err)
+		}
+		data.Tracks = mergeTracks(data.Tracks, shared)
+	}
+	if s.resolverURL != nil {
+		s.resolveTracks(ctx, data)
+	}
+	ls := &loadedStore{
+		Store:     data,
+		user:      user,
+		partial:   truncated,
+		truncated: dropped,
+		hash:      hex.EncodeToString(h.Sum(nil))[:8],
+	}
+	ls.latest, _ = latestPlay(data)
+	if gr, ok := or.(generationReader); ok {
+		ls.generation = gr.Generation()
+	}
+	if br, ok := or.(bucketReader); ok {
+		ls.bucket = br.Bucket()
+		span.SetAttributes(attribute.String("bucket", ls.bucket))
+	}
+	return ls, nil
+}
+
+// excludePlaybacks drops plays of the excluded tracks from data.
+func excludePlaybacks(data *earbugv3.Store, excluded map[string]struct{}) {
+	if len(excluded) == 0 {
+		return
+	}
+	for key, played := range data.Playbacks {
+		if _, ok := excluded[played.TrackId]; ok {
+			delete(data.Playbacks, key)
+		}
+	}
+}
+
+// capPlaybacks keeps only the most recent max playbacks in data,
+// returning how many were dropped.
+// Keys that aren't timestamps sort first and are dropped first.
+func capPlaybacks(data *earbugv3.Store, max int) int {
+	if max <= 0 || len(data.Playbacks) <= max {
+		return 0
+	}
+	type timedKey struct {
+		key string
+		ts  time.Time
+	}
+	keys := make([]timedKey, 0, len(data.Playbacks))
+	for key := range data.Playbacks {
+		ts, _ := time.Parse(time.RFC3339, key)
+		keys = append(keys, timedKey{key, ts})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].ts.Equal(keys[j].ts) {
+			return keys[i].ts.Before(keys[j].ts)
+		}
+		return keys[i].key < keys[j].key
+	})
+	drop := keys[:len(keys)-max]
+	for _, k := range drop {
+		delete(data.Playbacks, k.key)
+	}
+	return len(drop)
+}
+
+// unknownBytes is the size of unknown fields in m and the messages it holds.
+func unknownBytes(m protoreflect.Message) int {
+	n := len(m.GetUnknown())
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					n += unknownBytes(mv.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				l := v.List()
+				for i := 0; i < l.Len(); i++ {
+					n += unknownBytes(l.Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			n += unknownBytes(v.Message())
+		}
+		return true
+	})
+	return n
+}
+
+// storeSizeBuckets is the number of groups users are hashed into
+// to label store size metrics without per user cardinality.
+const storeSizeBuckets = 16
+
+func userBucket(user string) int {
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return int(h.Sum32() % storeSizeBuckets)
+}
+
+// decodeStore decodes the decompressed object b.
+// With delimited framing, b is a stream of varint length prefixed
+// keyed playbacks, assembled into a Store without track metadata.
+func decodeStore(b []byte, framing string) (*earbugv3.Store, error) {
+	var data earbugv3.Store
+	if framing != framingDelimited {
+		err := proto.Unmarshal(b, &data)
+		if err != nil {
+			return nil, err
+		}
+		return &data, nil
+	}
+
+	_, err := decodeDelimited(b, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// decodeDelimited adds the length delimited keyed playbacks in b to data,
+// returning how many were complete.
+//
+// A Playback has no time of its own, the Store keys them by it,
+// so each frame is a keyed playback:
+//
+//	message KeyedPlayback {
+//	  string time = 1; // RFC3339, the key in Store.playbacks
+//	  Playback playback = 2;
+//	}
+//
+// the wire form of a Store.playbacks entry.
+// Frames of bare Playback messages fail, they can't be placed in time.
+func decodeDelimited(b []byte, data *earbugv3.Store) (int, error) {
+	if data.Playbacks == nil {
+		data.Playbacks = make(map[string]*earbugv3.Playback)
+	}
+	var i int
+	for ; len(b) > 0; i++ {
+		size, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return i, fmt.Errorf("frame %d: length: %w", i, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if uint64(len(b)) < size {
+			return i, fmt.Errorf("frame %d: %w", i, io.ErrUnexpectedEOF)
+		}
+		key, played, err := decodeKeyedPlayback(b[:size])
+		if err != nil {
+			return i, fmt.Errorf("frame %d: %w", i, err)
+		}
+		data.Playbacks[key] = played
+		b = b[size:]
+	}
+	return i, nil
+}
+
+// decodeKeyedPlayback decodes a single frame of decodeDelimited.
+func decodeKeyedPlayback(b []byte) (string, *earbugv3.Playback, error) {
+	var key string
+	var playback [][]byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			playback = append(playback, v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if _, err := time.Parse(time.RFC3339, key); err != nil {
+		// checked first, a bare Playback's track uri fails to parse as a Playback
+		return "", nil, fmt.Errorf("time %q of keyed playback isn't RFC3339, frames must be keyed playbacks, not bare Playback messages", key)
+	}
+	played := &earbugv3.Playback{}
+	opts := proto.UnmarshalOptions{Merge: true}
+	for _, v := range playback {
+		err := opts.Unmarshal(v, played)
+		if err != nil {
+			return "", nil, fmt.Errorf("playback: %w", err)
+		}
+	}
+	return key, played, nil
+}
+
+// decodeTruncated decodes the complete messages at the start of b,
+// what was read of a truncated object.
+// Only delimited framing can be recovered, a single message is cut mid way.
+func decodeTruncated(b []byte, framing string) (*earbugv3.Store, error) {
+	if framing != framingDelimited {
+		return nil, errors.New("only delimited stores can be recovered after truncation")
+	}
+	var data earbugv3.Store
+	n, _ := decodeDelimited(b, &data)
+	if n == 0 {
+		return nil, errors.New("no complete messages before truncation")
+	}
+	return &data, nil
+}
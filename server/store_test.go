@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// delimited frames the playbacks of stores in order of time
+// as length delimited keyed playbacks.
+func delimited(t testing.TB, stores ...*earbugv3.Store) []byte {
+	t.Helper()
+	var b []byte
+	for _, data := range stores {
+		keys := make([]string, 0, len(data.Playbacks))
+		for key := range data.Playbacks {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			played, err := proto.Marshal(data.Playbacks[key])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var frame []byte
+			frame = protowire.AppendTag(frame, 1, protowire.BytesType)
+			frame = protowire.AppendString(frame, key)
+			frame = protowire.AppendTag(frame, 2, protowire.BytesType)
+			frame = protowire.AppendBytes(frame, played)
+			b = protowire.AppendBytes(b, frame)
+		}
+	}
+	return b
+}
+
+func TestDecodeStoreFraming(t *testing.T) {
+	first := testStore(map[string][]string{"t1": {"2024-03-14T08:00:00Z"}})
+	second := &earbugv3.Store{Playbacks: map[string]*earbugv3.Playback{
+		"2024-03-14T09:00:00Z": {TrackId: "t2"},
+	}}
+	single, err := proto.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		data, err := decodeStore(single, framingSingle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(data, first) {
+			t.Errorf("got %v, want %v", data, first)
+		}
+	})
+	t.Run("delimited", func(t *testing.T) {
+		data, err := decodeStore(delimited(t, first, second), framingDelimited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Playbacks) != 2 || data.Playbacks["2024-03-14T09:00:00Z"].GetTrackId() != "t2" {
+			t.Errorf("frames not assembled, got playbacks %v", data.Playbacks)
+		}
+		if len(data.Tracks) != 0 {
+			t.Errorf("got %d tracks from playbacks", len(data.Tracks))
+		}
+	})
+	t.Run("delimited bare playbacks", func(t *testing.T) {
+		var b []byte
+		for _, played := range second.Playbacks {
+			m, err := proto.Marshal(&earbugv3.Playback{TrackId: played.TrackId, TrackUri: "spotify:track:" + played.TrackId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = protowire.AppendBytes(b, m)
+		}
+		_, err := decodeStore(b, framingDelimited)
+		if err == nil || !strings.Contains(err.Error(), "frame 0") || !strings.Contains(err.Error(), "keyed playbacks") {
+			t.Errorf("got %v, want an error naming the frame format", err)
+		}
+	})
+	t.Run("delimited empty", func(t *testing.T) {
+		data, err := decodeStore(nil, framingDelimited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Playbacks) != 0 {
+			t.Errorf("got playbacks %v", data.Playbacks)
+		}
+	})
+	t.Run("delimited read as single", func(t *testing.T) {
+		// the length prefixes aren't valid fields of a single store
+		_, err := decodeStore(delimited(t, first, second), framingSingle)
+		if err == nil {
+			t.Error("decoded a delimited object with single framing")
+		}
+	})
+}
+
+func TestReadStoreDelimited(t *testing.T) {
+	store := &memStore{}
+	store.put("alice"+storeSuffix, zstdBytes(t, delimited(t,
+		testStore(map[string][]string{"t1": {"2024-03-14T08:00:00Z"}}),
+		testStore(map[string][]string{"t2": {"2024-03-14T09:00:00Z"}}),
+	)))
+	s := newTestServer(t, store, &postRecorder{}, map[string]string{"earbug.framing": framingDelimited})
+	data, err := s.readStore(context.Background(), "alice", &serverTiming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Playbacks) != 2 {
+		t.Errorf("got %d playbacks, want 2", len(data.Playbacks))
+	}
+}
+
+func TestValidFraming(t *testing.T) {
+	for _, framing := range []string{framingSingle, framingDelimited} {
+		if err := validFraming(framing); err != nil {
+			t.Errorf("%s: %v", framing, err)
+		}
+	}
+	if err := validFraming("stream"); err == nil {
+		t.Error("accepted unknown framing")
+	}
+}
+
+// typedReader is an object reader with content metadata.
+type typedReader struct {
+	io.Reader
+	contentType, contentEncoding string
+}
+
+func (r typedReader) ContentType() string     { return r.contentType }
+func (r typedReader) ContentEncoding() string { return r.contentEncoding }
+
+func TestObjectEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		or       io.Reader
+		want     string
+		wantFail bool
+	}{
+		// metadata wins over the suffix
+		{"metadata encoding", typedReader{nil, "", "zstd"}, encodingZstd, false},
+		{"metadata identity", typedReader{nil, "", "identity"}, encodingIdentity, false},
+		{"metadata type zstd", typedReader{nil, "application/zstd", ""}, encodingZstd, false},
+		{"metadata type gzip", typedReader{nil, "application/gzip", ""}, encodingGzip, false},
+		{"metadata type proto", typedReader{nil, "application/x-protobuf", ""}, encodingIdentity, false},
+		// transcoded by gcs, left to sniffing
+		{"metadata gzip encoding", typedReader{nil, "application/x-protobuf", "gzip"}, "", false},
+		{"metadata unknown encoding", typedReader{nil, "", "br"}, "", true},
+		// no useful metadata, the suffix decides
+		{"suffix zstd", typedReader{nil, "application/octet-stream", ""}, encodingZstd, false},
+		{"suffix no metadata", strings.NewReader(""), encodingZstd, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := objectEncoding("alice"+storeSuffix, tt.or)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("err = %v, want failure %v", err, tt.wantFail)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for name, want := range map[string]string{
+		"a.pb.zstd": encodingZstd,
+		"a.pb.zst":  encodingZstd,
+		"a.pb.gz":   encodingGzip,
+		"a.pb":      encodingIdentity,
+		"a.bin":     "",
+	} {
+		got, err := objectEncoding(name, strings.NewReader(""))
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestReadObjectMetadataOverSuffix(t *testing.T) {
+	raw, err := proto.Marshal(testStore(yesterdayPlays))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// uncompressed despite the .pb.zstd name, as its metadata says
+	or := typedReader{bytes.NewReader(raw), "application/x-protobuf", "identity"}
+	b, err := readObject("alice"+storeSuffix, or, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, raw) {
+		t.Error("content changed reading an identity object")
+	}
+
+	// without metadata the suffix says zstd, and raw bytes fail
+	_, err = readObject("alice"+storeSuffix, bytes.NewReader(raw), nil)
+	if err == nil {
+		t.Error("read raw bytes as zstd")
+	}
+}
+
+func gzipBytes(t testing.TB, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(b)
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestReadObjectCompression(t *testing.T) {
+	raw, err := proto.Marshal(testStore(yesterdayPlays))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		// by suffix
+		{"a.pb.zstd", zstdBytes(t, raw)},
+		{"a.pb.gz", gzipBytes(t, raw)},
+		{"a.pb", raw},
+		// by magic bytes
+		{"a.bin", zstdBytes(t, raw)},
+		{"a.bin", gzipBytes(t, raw)},
+		{"a.bin", raw},
+	}
+	for _, tt := range tests {
+		var tee bytes.Buffer
+		b, err := readObject(tt.name, bytes.NewReader(tt.b), &tee)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(b, raw) || !bytes.Equal(tee.Bytes(), raw) {
+			t.Errorf("%s: content changed", tt.name)
+		}
+	}
+	if _, err := readObject("a.pb.gz", bytes.NewReader(raw), nil); err == nil {
+		t.Error("read raw bytes as gzip")
+	}
+}
+
+func TestReadStoreGzipFallback(t *testing.T) {
+	raw, err := proto.Marshal(testStore(yesterdayPlays))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memStore{}
+	store.put("alice"+gzipStoreSuffix, gzipBytes(t, raw))
+	s := newTestServer(t, store, &postRecorder{}, nil)
+	data, err := s.readStore(context.Background(), "alice", &serverTiming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Playbacks) != 7 {
+		t.Errorf("got %d playbacks, want 7", len(data.Playbacks))
+	}
+}
+
+func TestReadStoreTruncated(t *testing.T) {
+	var fragments []*earbugv3.Store
+	// enough for several zstd blocks, the decoder only returns whole ones
+	for i := 0; i < 5000; i++ {
+		fragments = append(fragments, &earbugv3.Store{Playbacks: map[string]*earbugv3.Playback{
+			time.Date(2024, time.March, 14, 0, 0, i, 0, time.UTC).Format(time.RFC3339): {TrackId: fmt.Sprint("t", i), TrackUri: fmt.Sprint("spotify:track:t", i)},
+		}})
+	}
+	object := zstdBytes(t, delimited(t, fragments...))
+	// cut mid frame, as an interrupted upload leaves it
+	cut := object[:len(object)*2/3]
+
+	t.Run("fails", func(t *testing.T) {
+		store := &memStore{}
+		store.put("alice"+storeSuffix, cut)
+		s := newTestServer(t, store, &postRecorder{}, map[string]string{"earbug.framing": framingDelimited})
+		_, err := s.readStore(context.Background(), "alice", &serverTiming{})
+		if !errors.Is(err, errTruncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("got %v, want a truncated object error", err)
+		}
+		if msg, code := stageStatus(err); msg != "truncated store object" || code != http.StatusInternalServerError {
+			t.Errorf("got %q %d", msg, code)
+		}
+	})
+	t.Run("recovers", func(t *testing.T) {
+		store := &memStore{}
+		store.put("alice"+storeSuffix, cut)
+		s := newTestServer(t, store, &postRecorder{}, map[string]string{
+			"earbug.framing":           framingDelimited,
+			"earbug.truncated.recover": "true",
+		})
+		data, err := s.readStore(context.Background(), "alice", &serverTiming{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !data.partial || len(data.Playbacks) == 0 || len(data.Playbacks) >= len(fragments) {
+			t.Errorf("got partial %v with %d of %d playbacks", data.partial, len(data.Playbacks), len(fragments))
+		}
+	})
+	t.Run("single framing can't recover", func(t *testing.T) {
+		b, err := proto.Marshal(largeStore(50, 500, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		object := zstdBytes(t, b)
+		store := &memStore{}
+		store.put("alice"+storeSuffix, object[:len(object)/2])
+		s := newTestServer(t, store, &postRecorder{}, map[string]string{"earbug.truncated.recover": "true"})
+		_, err = s.readStore(context.Background(), "alice", &serverTiming{})
+		if !errors.Is(err, errTruncated) {
+			t.Fatalf("got %v, want a truncated object error", err)
+		}
+	})
+}
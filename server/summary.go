@@ -0,0 +1,562 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.seankhliao.com/gchat"
+	chat "google.golang.org/api/chat/v1"
+)
+
+func (s *Server) summary(rw http.ResponseWriter, r *http.Request) {
+	s.serveSummary(rw, r, "summary", yesterday)
+}
+
+func (s *Server) summaryISOWeek(rw http.ResponseWriter, r *http.Request) {
+	s.serveSummary(rw, r, "summary-isoweek", s.isoWeek)
+}
+
+// serveSummary posts the summary of the window picked by pick
+// for the requested user.
+func (s *Server) serveSummary(rw http.ResponseWriter, r *http.Request, name string, pick windowFunc) {
+	log := s.log.WithName(name)
+	ctx, span := s.trace.Start(r.Context(), name)
+	defer span.End()
+	timing := &serverTiming{}
+
+	req, msg, code, err := func() (userReq, string, int, error) {
+		ctx, span = s.trace.Start(ctx, "extract-user")
+		defer span.End()
+
+		if r.Method != http.MethodPost {
+			log = log.WithValues("method", r.Method)
+			return userReq{}, "invalid method", http.StatusMethodNotAllowed, errors.New("POST only")
+		}
+		return requestBody(r)
+	}()
+	if err != nil {
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	user := req.User
+
+	log = log.WithValues("user", user)
+
+	opts, err := s.summaryOptions(r.URL.Query())
+	if err == nil && r.URL.Query().Get("format") == "" && opts.format != formatDigestV1 {
+		// an explicit format wins, the digest is json whatever is accepted
+		if wantsProtobuf(r) {
+			opts.format = formatProtobuf
+		} else if strings.Contains(r.Header.Get("accept"), "application/json") {
+			opts.format = formatJSON
+		}
+	}
+	if len(opts.ignoredFields) > 0 {
+		log.Info("ignoring unknown fields", "fields", opts.ignoredFields)
+	}
+	if err != nil {
+		msg := "invalid options"
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	now := s.now(opts)
+	loc := s.loc
+	if opts.loc != nil {
+		loc = opts.loc
+	}
+	win, err := pick(r, now, loc)
+	if v := r.URL.Query().Get("period"); err == nil && v != "" {
+		win, err = periodWindow(v, now, loc, s.weekStart)
+	}
+	if err == nil && opts.lastN > 0 {
+		win = lastPlays(opts.lastN, now, loc)
+	}
+	if err == nil && opts.excludeToday {
+		// today is the current date in the summary time zone,
+		// which may differ from the date where the request was made
+		win = win.before(now.In(loc).Format(dateLayout))
+		if win.empty() {
+			err = invalidf("window %s has no days before today", win.label)
+		}
+	}
+	if err != nil {
+		msg := "invalid window"
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, optionsStatus(err))
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	var cursorGen int64
+	if opts.channel != "" {
+		log = log.WithValues("channel", opts.channel)
+		var after time.Time
+		after, cursorGen, err = s.readCursor(ctx, opts.channel, user)
+		if err != nil {
+			msg := "read cursor"
+			s.setTiming(rw, timing)
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		win = cursorWindow(after, now, loc)
+	}
+
+	if opts.explain {
+		s.setTiming(rw, timing)
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(win.explain(now, loc))
+		log.Info("explained window", "ctx", ctx, "http_request", r)
+		return
+	}
+
+	client, msg, code, err := func() (Notifier, string, int, error) {
+		if !postsToChat(opts.format) {
+			return nil, "", 0, nil
+		}
+		override := r.URL.Query().Get("webhook")
+		if override == "" {
+			override = r.Header.Get("X-Webhook")
+		}
+		if override == "" {
+			client, err := s.notifierFor(ctx, "")
+			if err != nil {
+				return nil, "no webhook configured", http.StatusInternalServerError, err
+			}
+			return client, "", 0, nil
+		}
+		if !s.webhookOverride {
+			return nil, "webhook override disabled", http.StatusForbidden, errors.New("webhook override requested but not enabled")
+		}
+		endpoint, err := s.parseWebhook(override)
+		if err != nil {
+			return nil, "invalid webhook", http.StatusBadRequest, err
+		}
+		log = log.WithValues("webhook_override", true)
+		client, err := s.notifierFor(ctx, endpoint)
+		if err != nil {
+			return nil, "no webhook configured", http.StatusInternalServerError, err
+		}
+		return client, "", 0, nil
+	}()
+	if err != nil {
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+
+	var src objectReader
+	if req.StoreURL != "" {
+		u, err := s.parseStoreURL(req.StoreURL)
+		if err != nil {
+			msg := "invalid storeURL"
+			s.setTiming(rw, timing)
+			http.Error(rw, msg, http.StatusBadRequest)
+			log.Error(err, msg, "ctx", ctx, "http_request", r)
+			return
+		}
+		src = urlStore{s.storeURLClient, u}
+		log = log.WithValues("store_url_host", u.Host)
+	}
+	var data *loadedStore
+	if src == nil {
+		data, err = s.readStore(ctx, user, timing)
+	} else {
+		// reads of a request's own url aren't shared
+		data, err = s.readStoreFrom(ctx, user, src, timing)
+	}
+	if err != nil {
+		msg, code := stageStatus(err)
+		s.notifyFailure(ctx, user, msg)
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	if data.bucket != "" {
+		rw.Header().Set("X-Earbug-Bucket", data.bucket)
+	}
+	if opts.includeHash {
+		// of the decompressed store, so it matches across compression
+		rw.Header().Set("X-Earbug-Data-Hash", data.hash)
+	}
+
+	if opts.perDay > 0 {
+		msg, err := s.postDays(ctx, client, loc, lastDays(now, loc, opts.perDay), data, opts, timing)
+		s.setTiming(rw, timing)
+		if err != nil {
+			http.Error(rw, msg, http.StatusInternalServerError)
+			log.Error(err, "post daily summaries", "ctx", ctx, "http_request", r)
+			return
+		}
+		rw.Write([]byte(msg))
+		log.Info("posted daily summaries", "days", opts.perDay, "ctx", ctx, "http_request", r)
+		return
+	}
+	if opts.threadedDaily {
+		msg, code, err := s.postThreadedDaily(ctx, client, loc, win, data, opts, timing)
+		s.setTiming(rw, timing)
+		if err != nil {
+			http.Error(rw, msg, code)
+			log.Error(err, "post threaded daily summaries", "ctx", ctx, "http_request", r)
+			return
+		}
+		switch code {
+		case http.StatusNoContent:
+			rw.WriteHeader(code)
+			log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+			return
+		case http.StatusNotFound:
+			http.Error(rw, msg, code)
+			log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+			return
+		}
+		rw.Write([]byte(msg))
+		log.Info("posted threaded daily summaries", "days", len(win.days()), "ctx", ctx, "http_request", r)
+		return
+	}
+
+	if opts.channel != "" {
+		opts.cursor = &cursorClaim{opts.channel, cursorGen}
+	}
+	sum, msg, code, err := s.postSummary(ctx, client, loc, win, data, opts, timing)
+	if sum != nil {
+		log = log.WithValues(sum.logValues()...)
+	}
+	if err != nil {
+		s.notifyFailure(ctx, user, msg)
+		s.setTiming(rw, timing)
+		http.Error(rw, msg, code)
+		log.Error(err, msg, "ctx", ctx, "http_request", r)
+		return
+	}
+	s.setTiming(rw, timing)
+	switch code {
+	case http.StatusNoContent:
+		rw.WriteHeader(code)
+		log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+		return
+	case http.StatusNotFound:
+		http.Error(rw, msg, code)
+		log.Info(msg+", not posted", "ctx", ctx, "http_request", r)
+		return
+	}
+	switch opts.format {
+	case formatMarkdown:
+		rw.Header().Set("content-type", "text/markdown; charset=utf-8")
+	case formatProtobuf:
+		rw.Header().Set("content-type", contentTypeProtobuf)
+		rw.Write(marshalSummary(sum))
+		log.Info("returned summary", "ctx", ctx, "http_request", r)
+		return
+	case formatJSON:
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(sum)
+		log.Info("returned summary", "ctx", ctx, "http_request", r)
+		return
+	case formatDigestV1:
+		rw.Header().Set("content-type", "application/json")
+		json.NewEncoder(rw).Encode(digestV1(sum))
+		log.Info("returned summary digest", "schema", schemaDigestV1, "ctx", ctx, "http_request", r)
+		return
+	}
+	rw.Write([]byte(msg))
+	log.Info("posted summary", "ctx", ctx, "http_request", r)
+}
+
+// now is the moment to summarize as of,
+// ?asOf= if set, otherwise the current time.
+func (s *Server) now(opts summaryOptions) time.Time {
+	if !opts.asOf.IsZero() {
+		return opts.asOf
+	}
+	return s.clock()
+}
+
+// postSummary computes the summary of the window in loc for the store's user
+// and posts it with client.
+// With posting disabled (a nil client), the rendered message is returned instead.
+func (s *Server) postSummary(ctx context.Context, client Notifier, loc *time.Location, win window, data *loadedStore, opts summaryOptions, timing *serverTiming) (*Summary, string, int, error) {
+	ctx, span := s.trace.Start(ctx, "post-summary")
+	defer span.End()
+
+	user := data.user
+	start := time.Now()
+	latest := data.latest
+	stale := !latest.IsZero() && s.clock().Sub(latest) > s.freshThreshold
+	if opts.requireFresh && stale {
+		msg := "data stale since " + latest.In(loc).Format(time.RFC3339)
+		return nil, msg, http.StatusConflict, fmt.Errorf("latest play %v older than %v", latest, s.freshThreshold)
+	}
+	noMetadata := len(data.Tracks) == 0 && len(data.Playbacks) > 0
+	if noMetadata {
+		opts.fields = countsOnly(opts.fields)
+	}
+	cfg := s.summaryConfig(loc)
+	cfg.groupBy = opts.groupBy
+	cfg.context = opts.context
+	cfg.compare = opts.compare
+	cfg.priorRanks = opts.priorRank
+	cfg.newTrackList = opts.includeNewTracks
+	cfg.rankBy = opts.rankBy
+	cfg.artists = s.achievements
+	if opts.hasField("streak") {
+		cfg.streakToday = s.now(opts).In(loc).Format(dateLayout)
+	}
+	var allTime []TrackCount
+	if s.allTime && opts.hasField("alltime") {
+		var cached bool
+		allTime, cached = s.allTimeTop.lookup(data, s.cacheMaxAge)
+		cfg.allTime = !cached
+	}
+	sum := aggregate(data.Store, user, win, cfg)
+	sum.Members = data.members
+	if s.achievements {
+		sum.Achievements = earnedAchievements(sum, data.Store)
+	}
+	if noMetadata {
+		// names would all be bare ids, keep to the counts
+		sum.countsOnly()
+		sum.warn("warnMetadata")
+	}
+	if data.partial {
+		sum.warn("warnTruncated")
+	}
+	if data.truncated > 0 {
+		sum.warn("warnCapped", s.maxPlaybacks, data.truncated)
+	}
+	if stale {
+		sum.warn("warnStale", latest.In(loc).Format(time.RFC3339))
+	}
+	if s.traceWarnings {
+		s.traceWarning(span, user, sum.Warnings)
+	}
+	// the cache holds the unfiltered list, ?minPlays= applies per request
+	if cfg.allTime {
+		s.allTimeTop.put(data, sum.AllTime)
+	} else if allTime != nil {
+		sum.AllTime = allTime
+	}
+	if opts.minPlays > 1 {
+		top := tracksWithPlays(sum.Top, opts.minPlays)
+		allTime := tracksWithPlays(sum.AllTime, opts.minPlays)
+		groups := groupsWithPlays(sum.Groups, opts.minPlays)
+		sum.filtered = map[string]bool{
+			"top":     len(top) == 0 && len(sum.Top) > 0,
+			"alltime": len(allTime) == 0 && len(sum.AllTime) > 0,
+			"groups":  len(groups) == 0 && len(sum.Groups) > 0,
+		}
+		sum.Top, sum.AllTime, sum.Groups = top, allTime, groups
+	}
+	if !win.single() && win.lastN == 0 {
+		sum.Goal = newTracksGoal(sum.NewTracks, s.goalNewTracks)
+		sum.Consistency = dailyConsistency(sum.Days)
+		sum.Milestone = projectMilestone(sum.totalPlays, float64(sum.Plays)/float64(len(win.days())), s.milestoneStep)
+	}
+	applyMissing(sum, data.Store, s.missingMetadata)
+	if opts.maxList > 0 {
+		sum.capLists(opts.maxList)
+	}
+	if sum.untyped > 0 {
+		s.log.V(1).Info("no type information for plays, assuming music", "user", user, "plays", sum.untyped)
+	}
+	var state *userState
+	// not for longer windows truncated to a day by excludeToday,
+	// or for days still collecting plays
+	anomaly := s.anomaly && win.single() && win.label == win.from && !win.partial
+	if anomaly {
+		var err error
+		state, err = s.readState(ctx, user)
+		if err != nil {
+			return sum, "read state", http.StatusInternalServerError, err
+		}
+		sum.Anomaly = detectAnomaly(state.priorDay(sum.Date), sum.Plays, s.anomalyFactor)
+	}
+	var chatMsg string
+	switch opts.format {
+	case formatMarkdown:
+		chatMsg = renderMarkdown(sum, opts)
+	case formatGlance:
+		chatMsg = renderGlance(sum, opts)
+	default:
+		chatMsg = renderSummary(sum, opts)
+	}
+	if len(data.Playbacks) == 0 {
+		// distinct from no plays on the day, there's no history at all
+		if s.emptySkip {
+			timing.add("aggregate", start)
+			return sum, "skipped: no plays recorded", http.StatusOK, nil
+		}
+		chatMsg = "No plays recorded yet for " + user
+	}
+	timing.add("aggregate", start)
+
+	if opts.persist {
+		msg, code, err := s.writeSnapshot(ctx, sum, opts.overwrite)
+		if err != nil {
+			return sum, msg, code, err
+		}
+		s.log.Info("persisted snapshot", "user", user, "object", msg)
+	}
+	if sum.Plays == 0 && opts.emptyStatus != http.StatusOK {
+		return sum, "no plays in window", opts.emptyStatus, nil
+	}
+	if !thresholdsMet(sum, opts.thresholds, opts.thresholdMode) {
+		return sum, "thresholds not met", http.StatusNoContent, nil
+	}
+
+	// the cursor is claimed past the gates,
+	// plays of a summary held back are still new next time
+	if client == nil {
+		_, msg, code, err := s.claimCursor(ctx, opts.cursor, user, data, win)
+		if err != nil {
+			return sum, msg, code, err
+		}
+		return sum, chatMsg, http.StatusOK, nil
+	}
+
+	start = time.Now()
+	defer timing.add("post", start)
+	var threadKey string
+	tp, threaded := client.(threadPoster)
+	if opts.attachJSON && threaded {
+		threadKey = "earbug-" + user + "-" + sum.Date
+	}
+	var cards []*chat.CardWithId
+	cp, carded := client.(cardPoster)
+	if opts.buttons && carded {
+		cards = trackButtons(data.Store, sum.Top)
+	}
+	if cards != nil && s.postPrefix != "" {
+		cards[0].Card.Header = &chat.GoogleAppsCardV1CardHeader{Title: s.postPrefix}
+	}
+	chatMsg = s.decorate(chatMsg)
+	dedupeKey := tenantScoped(ctx, user+"/"+sum.Date)
+	if s.dedupeWindow > 0 {
+		if !s.recentPosts.claim(dedupeKey, chatMsg, time.Now(), s.dedupeWindow) {
+			s.log.Info("suppressed duplicate of a recent post", "user", user, "date", sum.Date)
+			return sum, "duplicate of a recent post", http.StatusNoContent, nil
+		}
+	}
+	releaseCursor, msg, code, err := s.claimCursor(ctx, opts.cursor, user, data, win)
+	if err != nil {
+		if s.dedupeWindow > 0 {
+			s.recentPosts.release(dedupeKey, chatMsg)
+		}
+		return sum, msg, code, err
+	}
+	payload := gchat.WebhookPayload{
+		Text: chatMsg,
+	}
+	switch {
+	case cards != nil:
+		err = cp.PostCards(ctx, payload, cards, threadKey)
+	case threadKey != "":
+		err = tp.PostThread(ctx, payload, threadKey)
+	default:
+		err = client.Post(ctx, payload)
+	}
+	if err != nil {
+		if s.dedupeWindow > 0 {
+			s.recentPosts.release(dedupeKey, chatMsg)
+		}
+		releaseCursor()
+		return sum, "post message", http.StatusInternalServerError, err
+	}
+	s.lastPosted.put(tenantScoped(ctx, user), lastPost{
+		PostedAt: time.Now(),
+		Text:     chatMsg,
+		Summary:  sum,
+	})
+	if s.catchUpDays > 0 && win.single() && win.label == win.from && !win.partial {
+		err = s.writePosted(ctx, user, win.from)
+		if err != nil {
+			// the summary is out, failing would only cause a repost
+			s.log.Error(err, "record posted date", "user", user, "date", win.from)
+		}
+	}
+
+	if anomaly {
+		err = s.writeState(ctx, user, state.next(sum.Date, sum.Plays))
+		if err != nil {
+			return sum, "write state", http.StatusInternalServerError, err
+		}
+	}
+
+	if opts.attachJSON {
+		b, err := json.Marshal(sum)
+		if err != nil {
+			return sum, "marshal json attachment", http.StatusInternalServerError, err
+		}
+		if threadKey != "" {
+			err = tp.PostThread(ctx, gchat.WebhookPayload{Text: string(b)}, threadKey)
+		} else {
+			// no threads, a code block in a second message
+			err = client.Post(ctx, gchat.WebhookPayload{Text: "```\n" + string(b) + "\n```"})
+		}
+		if err != nil {
+			return sum, "post json attachment", http.StatusInternalServerError, err
+		}
+	}
+
+	return sum, "ok", http.StatusOK, nil
+}
+
+// postDays posts a separate summary for each of dates in order,
+// continuing past failures, which are reported together.
+// The returned message has a line with the result for each date.
+func (s *Server) postDays(ctx context.Context, client Notifier, loc *time.Location, dates []string, data *loadedStore, opts summaryOptions, timing *serverTiming) (string, error) {
+	var lines []string
+	var failed []string
+	for _, date := range dates {
+		_, msg, _, err := s.postSummary(ctx, client, loc, dayWindow(date), data, opts, timing)
+		if err != nil {
+			s.notifyFailure(ctx, data.user, msg)
+			failed = append(failed, fmt.Sprintf("%s: %s: %v", date, msg, err))
+			msg = "failed: " + msg
+		}
+		lines = append(lines, date+": "+msg)
+	}
+	out := strings.Join(lines, "\n")
+	if len(failed) > 0 {
+		return out, fmt.Errorf("%d of %d days failed: %s", len(failed), len(dates), strings.Join(failed, "; "))
+	}
+	return out, nil
+}
+
+// traceWarning records each warning as an event on span
+// and logs it through logr with the span's trace and span ids,
+// there are no OTel log records.
+func (s *Server) traceWarning(span trace.Span, user string, warnings []string) {
+	sc := span.SpanContext()
+	for _, w := range warnings {
+		span.AddEvent("data quality warning", trace.WithAttributes(attribute.String("warning", w)))
+		s.log.Info("data quality warning", "user", user, "warning", w, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+}
+
+func (sum *Summary) logValues() []any {
+	vals := []any{
+		"summary_date", sum.Date,
+		"plays", sum.Plays,
+		"tracks", sum.Tracks,
+		"tracks_new", sum.NewTracks,
+		"listened", sum.Listened,
+	}
+	if sum.LongestSession != nil {
+		vals = append(vals, "session_longest", sum.LongestSession.Duration)
+	}
+	return vals
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSummaryPosts(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &postRecorder{}
+	s := newTestServer(t, store, n, nil)
+
+	rw := serve(s, http.MethodPost, "/summary?user=alice", "", nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rw.Code, rw.Body)
+	}
+	posts := n.texts()
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, want 1", len(posts))
+	}
+	for _, want := range []string{"2024-03-14", "6 plays", "3 tracks", "22m listened"} {
+		if !strings.Contains(posts[0], want) {
+			t.Errorf("post missing %q:\n%s", want, posts[0])
+		}
+	}
+	if store.reads == 0 {
+		t.Error("store never read")
+	}
+}
+
+func TestSummaryErrors(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		post   error
+		code   int
+		msg    string
+	}{
+		{"get", http.MethodGet, "/summary?user=alice", "", nil, http.StatusMethodNotAllowed, "invalid method"},
+		{"no user", http.MethodPost, "/summary", `{}`, nil, http.StatusBadRequest, "unmarshal body"},
+		{"missing store", http.MethodPost, "/summary?user=bob", "", nil, http.StatusNotFound, "no data for user"},
+		{"bad option", http.MethodPost, "/summary?user=alice&maxList=x", "", nil, http.StatusBadRequest, "invalid options"},
+		{"post fails", http.MethodPost, "/summary?user=alice", "", errors.New("chat down"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &postRecorder{err: tt.post}
+			s := newTestServer(t, store, n, nil)
+			rw := serve(s, tt.method, tt.target, tt.body, nil)
+			if rw.Code != tt.code {
+				t.Fatalf("got %d: %s, want %d", rw.Code, rw.Body, tt.code)
+			}
+			if got := strings.TrimSpace(rw.Body.String()); tt.msg != "" && got != tt.msg {
+				t.Errorf("got message %q, want %q", got, tt.msg)
+			}
+			if tt.post == nil && len(n.texts()) != 0 {
+				t.Errorf("posted on failure: %q", n.texts())
+			}
+		})
+	}
+}
+
+func TestSummaryPostingDisabled(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	n := &postRecorder{}
+	s := newTestServer(t, store, n, map[string]string{"earbug.posting.enabled": "false"})
+
+	rw := serve(s, http.MethodPost, "/summary?user=alice", "", nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rw.Code, rw.Body)
+	}
+	if len(n.texts()) != 0 {
+		t.Errorf("posted with posting disabled")
+	}
+	if !strings.Contains(rw.Body.String(), "Alpha") {
+		t.Errorf("response missing the summary:\n%s", rw.Body)
+	}
+}
+
+func TestSummaryExcludedTrack(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	s := newTestServer(t, store, nil, map[string]string{
+		"earbug.posting.enabled": "false",
+		"earbug.alltime.enabled": "true",
+		"earbug.exclude.tracks":  "t1, t9",
+	})
+	for _, format := range []string{"text", "markdown", "json"} {
+		target := "/summary?user=alice&fields=plays,tracks,time,top,alltime,discovery,daytops,session"
+		header := http.Header{}
+		if format == "json" {
+			header.Set("accept", "application/json")
+		} else {
+			target += "&format=" + format
+		}
+		rw := serve(s, http.MethodPost, target, "", header)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", format, rw.Code, rw.Body)
+		}
+		out := rw.Body.String()
+		if strings.Contains(out, "Alpha") || strings.Contains(out, `"t1"`) {
+			t.Errorf("%s: excluded track in summary:\n%s", format, out)
+		}
+		if format == "text" && (!strings.Contains(out, "3 plays") || !strings.Contains(out, "13m listened")) {
+			t.Errorf("excluded plays counted:\n%s", out)
+		}
+	}
+}
+
+func TestSummaryFormatOverAccept(t *testing.T) {
+	store := &memStore{}
+	store.putStore(t, "alice", testStore(yesterdayPlays))
+	s := newTestServer(t, store, nil, map[string]string{"earbug.posting.enabled": "false"})
+	tests := []struct {
+		query, accept, want string
+	}{
+		{"", "application/json", "application/json"},
+		{"&format=markdown", "application/json", "text/markdown; charset=utf-8"},
+		{"&format=text", contentTypeProtobuf, "text/plain; charset=utf-8"},
+		{"&schema=" + schemaDigestV1, contentTypeProtobuf, "application/json"},
+	}
+	for _, tt := range tests {
+		rw := serve(s, http.MethodPost, "/summary?user=alice"+tt.query, "", http.Header{"Accept": {tt.accept}})
+		if got := rw.Header().Get("content-type"); rw.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%q with accept %s: got %d %s, want %s", tt.query, tt.accept, rw.Code, got, tt.want)
+		}
+	}
+}
+
+// eventSpan is a span keeping the names and attributes of its events.
+type eventSpan struct {
+	trace.Span
+	events []string
+}
+
+func (e *eventSpan) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+	for _, kv := range cfg.Attributes() {
+		name += " " + string(kv.Key) + "=" + kv.Value.Emit()
+	}
+	e.events = append(e.events, name)
+}
+
+func TestTraceWarning(t *testing.T) {
+	s := newTestServer(t, &memStore{}, nil, map[string]string{
+		"earbug.posting.enabled": "false",
+		"earbug.trace.warnings":  "true",
+	})
+	span := &eventSpan{Span: trace.SpanFromContext(context.Background())}
+	s.traceWarning(span, "alice", []string{"first", "second"})
+	want := []string{"data quality warning warning=first", "data quality warning warning=second"}
+	if strings.Join(span.events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events %q, want %q", span.events, want)
+	}
+}
+
+func TestSummaryTodayNotRecorded(t *testing.T) {
+	for _, tt := range []struct {
+		period string
+		want   bool
+	}{
+		{"yesterday", true},
+		{"today", false},
+	} {
+		store := &memStore{}
+		store.putStore(t, "alice", testStore(map[string][]string{
+			"t1": {"2024-03-14T08:00:00Z", "2024-03-15T08:00:00Z"},
+		}))
+		s := newTestServer(t, store, &postRecorder{}, map[string]string{
+			"earbug.anomaly.enabled": "true",
+			"earbug.catchup.days":    "3",
+		})
+		rw := serve(s, http.MethodPost, "/summary?user=alice&period="+tt.period, "", nil)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.period, rw.Code, rw.Body)
+		}
+		for _, name := range []string{statePrefix + "alice.json", postedPrefix + "alice.json"} {
+			if _, ok := store.objects[name]; ok != tt.want {
+				t.Errorf("%s: wrote %s %v, want %v", tt.period, name, ok, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const contentTypeProtobuf = "application/x-protobuf"
+
+// wantsProtobuf reports whether the client asked for a protobuf response.
+func wantsProtobuf(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("accept"), contentTypeProtobuf)
+}
+
+// marshalSummary encodes sum as the Summary message in summary.proto.
+func marshalSummary(sum *Summary) []byte {
+	var b []byte
+	b = appendString(b, 1, sum.User)
+	b = appendString(b, 2, sum.Date)
+	b = appendInt(b, 3, sum.Plays)
+	b = appendInt(b, 4, sum.Tracks)
+	b = appendInt(b, 5, sum.NewTracks)
+	b = appendMessage(b, 6, marshalDuration(sum.Listened))
+	for _, d := range sum.Days {
+		var db []byte
+		db = appendString(db, 1, d.Date)
+		db = appendInt(db, 2, d.Plays)
+		if d.Top != nil {
+			db = appendMessage(db, 3, marshalTrackCount(*d.Top))
+		}
+		b = appendMessage(b, 7, db)
+	}
+	if ls := sum.LongestSession; ls != nil {
+		var sb []byte
+		sb = appendMessage(sb, 1, marshalTimestamp(ls.Start))
+		sb = appendMessage(sb, 2, marshalDuration(ls.Duration))
+		sb = appendInt(sb, 3, ls.Tracks)
+		b = appendMessage(b, 8, sb)
+	}
+	for _, t := range sum.Top {
+		b = appendMessage(b, 9, marshalTrackCount(t))
+	}
+	for _, t := range sum.AllTime {
+		b = appendMessage(b, 10, marshalTrackCount(t))
+	}
+	b = appendInt(b, 11, sum.OneOffs)
+	return b
+}
+
+func marshalTrackCount(t TrackCount) []byte {
+	var b []byte
+	b = appendString(b, 1, t.ID)
+	b = appendString(b, 2, t.Name)
+	for _, a := range t.Artists {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, a)
+	}
+	b = appendInt(b, 4, t.Plays)
+	return b
+}
+
+// marshalDuration encodes d as a google.protobuf.Duration.
+func marshalDuration(d time.Duration) []byte {
+	var b []byte
+	b = appendInt(b, 1, int(d/time.Second))
+	b = appendInt(b, 2, int(d%time.Second))
+	return b
+}
+
+// marshalTimestamp encodes t as a google.protobuf.Timestamp.
+func marshalTimestamp(t time.Time) []byte {
+	var b []byte
+	b = appendInt(b, 1, int(t.Unix()))
+	b = appendInt(b, 2, t.Nanosecond())
+	return b
+}
+
+// appendString appends a string field, omitting the proto3 default.
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendInt appends an int64 field, omitting the proto3 default.
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(v)))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
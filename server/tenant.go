@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// tenantConfig is the file read from earbug.tenants.
+type tenantConfig struct {
+	Tenants []tenant `json:"tenants"`
+}
+
+// tenant is an independent setup selected with the X-Tenant header,
+// with its own bucket and default webhook.
+type tenant struct {
+	Name string `json:"name"`
+	// Bucket to read user data from, or a comma separated list of replicas
+	Bucket string `json:"bucket"`
+	// Webhook posted to when a request doesn't override it
+	Webhook string `json:"webhook"`
+
+	storeMu  sync.Mutex
+	store    ObjectStore
+	breaker  breaker
+	metadata metadataCache
+}
+
+// keepStores gives tenants the stores of old tenants of the same name and bucket.
+func keepStores(byName, old map[string]*tenant) {
+	for name, tn := range byName {
+		if prev, ok := old[name]; ok && prev.Bucket == tn.Bucket {
+			prev.storeMu.Lock()
+			tn.store = prev.store
+			prev.storeMu.Unlock()
+		}
+	}
+}
+
+// loadTenants reads and validates the tenants in earbug.tenants.
+func (s *Server) loadTenants() (map[string]*tenant, error) {
+	b, err := os.ReadFile(s.tenantsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read tenants: %w", err)
+	}
+	var c tenantConfig
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal tenants: %w", err)
+	}
+	byName := make(map[string]*tenant, len(c.Tenants))
+	for i := range c.Tenants {
+		t := &c.Tenants[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("tenant entry %d: no name", i)
+		} else if _, ok := byName[t.Name]; ok {
+			return nil, fmt.Errorf("tenant entry %d: duplicate name %q", i, t.Name)
+		} else if t.Bucket == "" {
+			return nil, fmt.Errorf("tenant %s: no bucket", t.Name)
+		}
+		if t.Webhook != "" {
+			t.Webhook, err = s.parseWebhook(t.Webhook)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: invalid webhook: %w", t.Name, err)
+			}
+		}
+		byName[t.Name] = t
+	}
+	return byName, nil
+}
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant selected for a request, nil for the default setup.
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey{}).(*tenant)
+	return t
+}
+
+// breakerFor is the circuit breaker for bucket reads of the tenant from ctx.
+func (s *Server) breakerFor(ctx context.Context) *breaker {
+	if t := tenantFrom(ctx); t != nil {
+		return &t.breaker
+	}
+	return &s.breaker
+}
+
+// metadataFor is the shared metadata cache of the tenant from ctx,
+// each tenant's metadata object is in its own bucket.
+func (s *Server) metadataFor(ctx context.Context) *metadataCache {
+	if t := tenantFrom(ctx); t != nil {
+		return &t.metadata
+	}
+	return s.reloaded.Load().metadata
+}
+
+// tenantScoped qualifies a per user key with the tenant from ctx,
+// so caches don't mix users of the same name across tenants.
+func tenantScoped(ctx context.Context, key string) string {
+	if t := tenantFrom(ctx); t != nil {
+		return t.Name + "/" + key
+	}
+	return key
+}
+
+// withTenant selects the tenant named in the X-Tenant header,
+// requests without it use the buckets and webhooks from flags.
+func (s *Server) withTenant(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get("X-Tenant")
+		if name == "" {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		t, ok := s.reloaded.Load().tenants[name]
+		if !ok {
+			msg := "unknown tenant"
+			http.Error(rw, msg, http.StatusBadRequest)
+			s.log.Error(errors.New("tenant not in earbug.tenants"), msg, "tenant", name, "ctx", r.Context(), "http_request", r)
+			return
+		}
+		h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	})
+}
+
+// objects returns the tenant's object store,
+// creating it on first use like Server.objects.
+func (t *tenant) objects(ctx context.Context) (ObjectStore, error) {
+	t.storeMu.Lock()
+	defer t.storeMu.Unlock()
+	if t.store != nil {
+		return t.store, nil
+	}
+	store, err := openBuckets(ctx, t.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	t.store = store
+	return t.store, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.seankhliao.com/gchat"
+	chat "google.golang.org/api/chat/v1"
+)
+
+// threadedCards returns client as a poster of cards into threads,
+// false if it, or the notifier a file sink wraps, can't do both.
+func threadedCards(client Notifier) (cardPoster, bool) {
+	inner := client
+	if t, ok := client.(teeNotifier); ok {
+		inner = t.Notifier
+	}
+	_, threaded := inner.(threadPoster)
+	_, carded := inner.(cardPoster)
+	if !threaded || !carded {
+		return nil, false
+	}
+	cp, ok := client.(cardPoster)
+	return cp, ok
+}
+
+// headerCard is a card titling the message it's attached to.
+func headerCard(id, title, subtitle string) []*chat.CardWithId {
+	return []*chat.CardWithId{{
+		CardId: id,
+		Card: &chat.GoogleAppsCardV1Card{
+			Header: &chat.GoogleAppsCardV1CardHeader{Title: title, Subtitle: subtitle},
+		},
+	}}
+}
+
+// postThreadedDaily posts the summary of a multi day window as a card,
+// then each of its days in order as a reply card in the same thread.
+// Posting stops at the first failure, the returned message lists what posted.
+func (s *Server) postThreadedDaily(ctx context.Context, client Notifier, loc *time.Location, win window, data *loadedStore, opts summaryOptions, timing *serverTiming) (string, int, error) {
+	ctx, span := s.trace.Start(ctx, "post-threaded-daily")
+	defer span.End()
+
+	cp, ok := threadedCards(client)
+	if !ok {
+		return "threadedDaily requires posting to a thread capable sink", http.StatusBadRequest, errors.New("notifier can't post cards into threads")
+	}
+	if win.single() {
+		return "invalid window", http.StatusUnprocessableEntity, errors.New("threadedDaily needs a window of more than one day")
+	}
+
+	// rendered without a client, posted here as cards
+	sum, msg, code, err := s.postSummary(ctx, nil, loc, win, data, opts, timing)
+	if err != nil || code != http.StatusOK {
+		return msg, code, err
+	}
+	title := formatDate(win.from, opts.dateFormat) + ".." + formatDate(win.to, opts.dateFormat)
+	threadKey := "earbug-" + data.user + "-" + win.from + "-" + win.to
+	err = cp.PostCards(ctx, gchat.WebhookPayload{Text: s.decorate(msg)}, headerCard("earbug-week", title, formatCount(sum.Plays, opts.thousands)+" plays"), threadKey)
+	if err != nil {
+		return "post header", http.StatusInternalServerError, err
+	}
+
+	// every day shows, quiet ones included, gating applies to the whole window
+	dayOpts := opts
+	dayOpts.emptyStatus = http.StatusOK
+	dayOpts.thresholds = nil
+	dayOpts.persist = false
+	lines := []string{"posted " + title}
+	for _, date := range win.days() {
+		sum, msg, _, err := s.postSummary(ctx, nil, loc, dayWindow(date), data, dayOpts, timing)
+		if err == nil {
+			err = cp.PostCards(ctx, gchat.WebhookPayload{Text: s.decorate(msg)}, headerCard("earbug-day", formatDate(date, opts.dateFormat), formatCount(sum.Plays, opts.thousands)+" plays"), threadKey)
+			msg = "post day"
+		}
+		if err != nil {
+			lines = append(lines, date+": failed: "+msg)
+			out := strings.Join(lines, "\n")
+			return out, http.StatusInternalServerError, fmt.Errorf("%d of %d days posted, %s: %s: %w", len(lines)-2, len(win.days()), date, msg, err)
+		}
+		lines = append(lines, date+": posted")
+	}
+	return strings.Join(lines, "\n"), http.StatusOK, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	thresholdAll = "all"
+	thresholdAny = "any"
+)
+
+// thresholdMetrics are the summary counts a post can be gated on,
+// as ?postIf<Metric>Gte=N.
+var thresholdMetrics = map[string]func(*Summary) int{
+	"Plays":     func(sum *Summary) int { return sum.Plays },
+	"Tracks":    func(sum *Summary) int { return sum.Tracks },
+	"NewTracks": func(sum *Summary) int { return sum.NewTracks },
+}
+
+// threshold is a minimum count a metric has to reach to post.
+type threshold struct {
+	metric string
+	min    int
+}
+
+// parseThresholds reads the ?postIf<Metric>Gte= parameters in metric order.
+func parseThresholds(q url.Values) ([]threshold, error) {
+	var ts []threshold
+	for _, metric := range sortedKeys(thresholdMetrics) {
+		param := "postIf" + metric + "Gte"
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", param, err)
+		}
+		ts = append(ts, threshold{metric, n})
+	}
+	return ts, nil
+}
+
+// thresholdsMet reports whether sum should be posted.
+// With mode thresholdAll every threshold has to be reached,
+// with thresholdAny at least one. No thresholds always posts.
+func thresholdsMet(sum *Summary, ts []threshold, mode string) bool {
+	if len(ts) == 0 {
+		return true
+	}
+	for _, t := range ts {
+		met := thresholdMetrics[t.metric](sum) >= t.min
+		if met && mode == thresholdAny {
+			return true
+		} else if !met && mode == thresholdAll {
+			return false
+		}
+	}
+	return mode == thresholdAll
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serverTiming collects stage durations for a Server-Timing header.
+type serverTiming struct {
+	stages []string
+}
+
+func (t *serverTiming) add(name string, start time.Time) {
+	dur := float64(time.Since(start).Microseconds()) / 1000
+	t.stages = append(t.stages, fmt.Sprintf("%s;dur=%.1f", name, dur))
+}
+
+func (t *serverTiming) String() string {
+	return strings.Join(t.stages, ", ")
+}
+
+// setTiming writes the Server-Timing header when debug timing is enabled,
+// it must be called before the response is written.
+func (s *Server) setTiming(rw http.ResponseWriter, t *serverTiming) {
+	if !s.debugTiming || len(t.stages) == 0 {
+		return
+	}
+	rw.Header().Set("Server-Timing", t.String())
+}
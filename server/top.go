@@ -0,0 +1,266 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+// TrackCount is a track with its number of plays.
+type TrackCount struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists,omitempty"`
+	Plays   int      `json:"plays"`
+	// PriorRank is the position in the previous window's ranking,
+	// 0 if it wasn't played, nil if not compared
+	PriorRank *int `json:"priorRank,omitempty"`
+	// Completion is the average share of the track listened per play, 0-1,
+	// nil if unknown
+	Completion *float64 `json:"completion,omitempty"`
+}
+
+const (
+	rankPlays    = "plays"
+	rankAffinity = "affinity"
+)
+
+// affinity weights a track's plays by its average completion,
+// so 3 full plays (3) rank above 5 plays skipped a third in (5 × 0.33 ≈ 1.7).
+// Without a known completion every play counts in full,
+// leaving tracks without duration data ranked by plays.
+func affinity(t TrackCount) float64 {
+	if t.Completion == nil {
+		return float64(t.Plays)
+	}
+	return float64(t.Plays) * *t.Completion
+}
+
+// rankByAffinity reorders ranked by affinity, then plays, then id.
+func rankByAffinity(ranked []TrackCount) {
+	sort.Slice(ranked, func(i, j int) bool {
+		if a, b := affinity(ranked[i]), affinity(ranked[j]); a != b {
+			return a > b
+		}
+		if ranked[i].Plays != ranked[j].Plays {
+			return ranked[i].Plays > ranked[j].Plays
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+}
+
+// setPriorRanks sets the PriorRank of each of top from its place in prior.
+func setPriorRanks(top, prior []TrackCount) {
+	ranks := make(map[string]int, len(prior))
+	for i, t := range prior {
+		ranks[t.ID] = i + 1
+	}
+	for i := range top {
+		rank := ranks[top[i].ID]
+		top[i].PriorRank = &rank
+	}
+}
+
+// topTracks ranks the tracks in counts by plays,
+// breaking ties by id, keeping at most n.
+func topTracks(data *earbugv3.Store, counts map[string]int, n int) []TrackCount {
+	ranked := make([]TrackCount, 0, len(counts))
+	for id, plays := range counts {
+		tc := TrackCount{
+			ID:    id,
+			Name:  id,
+			Plays: plays,
+		}
+		if track, ok := data.Tracks[id]; ok {
+			if track.Name != "" {
+				tc.Name = track.Name
+			}
+			for _, a := range track.Artists {
+				tc.Artists = append(tc.Artists, a.Name)
+			}
+		}
+		ranked = append(ranked, tc)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Plays != ranked[j].Plays {
+			return ranked[i].Plays > ranked[j].Plays
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// trackName is the name of a track, falling back to its id.
+func trackName(data *earbugv3.Store, id string) string {
+	if name := data.Tracks[id].GetName(); name != "" {
+		return name
+	}
+	return id
+}
+
+const (
+	missingID          = "id"
+	missingSkip        = "skip"
+	missingPlaceholder = "placeholder"
+
+	unknownTrack = "Unknown track"
+)
+
+// applyMissing shows tracks without a name in the lists of sum
+// as their id, not at all, or as unknownTrack, per earbug.metadata.missing.
+// Counts always include them.
+func applyMissing(sum *Summary, data *earbugv3.Store, policy string) {
+	if policy == missingID {
+		return
+	}
+	named := func(id string) bool {
+		return data.Tracks[id].GetName() != ""
+	}
+	list := func(tracks []TrackCount) []TrackCount {
+		// not in place, all time lists are shared with the cache
+		kept := make([]TrackCount, 0, len(tracks))
+		for _, t := range tracks {
+			if !named(t.ID) {
+				if policy == missingSkip {
+					continue
+				}
+				t.Name = unknownTrack
+			}
+			kept = append(kept, t)
+		}
+		return kept
+	}
+	sum.Top = list(sum.Top)
+	sum.AllTime = list(sum.AllTime)
+	sum.NewTrackList = list(sum.NewTrackList)
+	for i, d := range sum.Days {
+		if d.Top != nil && !named(d.Top.ID) {
+			if policy == missingSkip {
+				sum.Days[i].Top = nil
+			} else {
+				sum.Days[i].Top.Name = unknownTrack
+			}
+		}
+	}
+	rising := sum.Rising[:0]
+	for _, t := range sum.Rising {
+		if !named(t.ID) {
+			if policy == missingSkip {
+				continue
+			}
+			t.Name = unknownTrack
+		}
+		rising = append(rising, t)
+	}
+	sum.Rising = rising
+	if d := sum.FirstDiscovery; d != nil && !named(d.ID) {
+		if policy == missingSkip {
+			sum.FirstDiscovery = nil
+		} else {
+			d.Name = unknownTrack
+		}
+	}
+	if otd := sum.OnThisDay; otd != nil && !named(otd.Track.ID) {
+		if policy == missingSkip {
+			sum.OnThisDay = nil
+		} else {
+			otd.Track.Name = unknownTrack
+		}
+	}
+}
+
+// dailyTopTracks returns the most played track on each date with plays,
+// breaking ties by id.
+func dailyTopTracks(data *earbugv3.Store, plays []playback) map[string]TrackCount {
+	perDay := make(map[string]map[string]int)
+	for _, p := range plays {
+		day := p.ts.Format(dateLayout)
+		if perDay[day] == nil {
+			perDay[day] = make(map[string]int)
+		}
+		perDay[day][p.trackID]++
+	}
+	tops := make(map[string]TrackCount, len(perDay))
+	for day, counts := range perDay {
+		tops[day] = topTracks(data, counts, 1)[0]
+	}
+	return tops
+}
+
+// tracksWithPlays returns the ranked tracks with at least min plays.
+func tracksWithPlays(ranked []TrackCount, min int) []TrackCount {
+	for i, t := range ranked {
+		if t.Plays < min {
+			return ranked[:i]
+		}
+	}
+	return ranked
+}
+
+const (
+	topN        = 10
+	allTimeTopN = 10
+)
+
+// allTimeCache caches all time top tracks per user and store generation.
+type allTimeCache struct {
+	mu      sync.Mutex
+	entries map[string]allTimeEntry
+}
+
+type allTimeEntry struct {
+	generation int64
+	top        []TrackCount
+	stored     time.Time
+}
+
+// lookup returns the cached top tracks for the generation of data,
+// stored no longer than maxAge ago if positive.
+func (c *allTimeCache) lookup(data *loadedStore, maxAge time.Duration) ([]TrackCount, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[data.bucket+"/"+data.user]
+	if !ok || data.generation == 0 || e.generation != data.generation {
+		return nil, false
+	} else if maxAge > 0 && time.Since(e.stored) >= maxAge {
+		return nil, false
+	}
+	return e.top, true
+}
+
+// evict drops entries stored before cutoff, returning how many.
+func (c *allTimeCache) evict(cutoff time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for key, e := range c.entries {
+		if e.stored.Before(cutoff) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// put caches top for the generation of data,
+// stores of unknown generation aren't cached.
+func (c *allTimeCache) put(data *loadedStore, top []TrackCount) {
+	if data.generation == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]allTimeEntry)
+	}
+	c.entries[data.bucket+"/"+data.user] = allTimeEntry{
+		generation: data.generation,
+		top:        top,
+		stored:     time.Now(),
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	earbugv3 "go.seankhliao.com/earbug/v3/pb/earbug/v3"
+)
+
+func TestApplyMissing(t *testing.T) {
+	data := &earbugv3.Store{Tracks: map[string]*earbugv3.Track{
+		"t1": testTrack("t1", "Alpha", 3*time.Minute, "Ann"),
+	}}
+	newSum := func() *Summary {
+		return &Summary{
+			Plays:          7,
+			Top:            []TrackCount{{ID: "t1", Name: "Alpha", Plays: 4}, {ID: "x", Name: "x", Plays: 3}},
+			Days:           []DayCount{{Date: "2024-03-14", Plays: 7, Top: &TrackCount{ID: "x", Name: "x"}}},
+			Rising:         []RisingTrack{{ID: "x", Name: "x"}},
+			FirstDiscovery: &Discovery{ID: "x", Name: "x"},
+		}
+	}
+	tests := []struct {
+		policy string
+		top    []string
+		name   string
+	}{
+		{missingID, []string{"Alpha", "x"}, "x"},
+		{missingPlaceholder, []string{"Alpha", unknownTrack}, unknownTrack},
+		{missingSkip, []string{"Alpha"}, ""},
+	}
+	for _, tt := range tests {
+		sum := newSum()
+		applyMissing(sum, data, tt.policy)
+		var top []string
+		for _, tc := range sum.Top {
+			top = append(top, tc.Name)
+		}
+		if strings.Join(top, ",") != strings.Join(tt.top, ",") {
+			t.Errorf("%s: top %v, want %v", tt.policy, top, tt.top)
+		}
+		if sum.Plays != 7 || sum.Days[0].Plays != 7 {
+			t.Errorf("%s: counts changed", tt.policy)
+		}
+		var dayTop, rising, discovery string
+		if sum.Days[0].Top != nil {
+			dayTop = sum.Days[0].Top.Name
+		}
+		if len(sum.Rising) > 0 {
+			rising = sum.Rising[0].Name
+		}
+		if sum.FirstDiscovery != nil {
+			discovery = sum.FirstDiscovery.Name
+		}
+		if dayTop != tt.name || rising != tt.name || discovery != tt.name {
+			t.Errorf("%s: day top %q, rising %q, discovery %q, want %q", tt.policy, dayTop, rising, discovery, tt.name)
+		}
+	}
+}
+
+func TestMetadataMissingModes(t *testing.T) {
+	data := testStore(yesterdayPlays)
+	// played, but with no metadata
+	data.Playbacks["2024-03-14T14:00:00Z"] = &earbugv3.Playback{TrackId: "t9"}
+	data.Playbacks["2024-03-14T14:10:00Z"] = &earbugv3.Playback{TrackId: "t9"}
+	store := &memStore{}
+	store.putStore(t, "alice", data)
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{missingID, "top: 1. Alpha — Ann (3), 2. Beta — Bob (2), 3. t9 (2), 4. Gamma — Ann, Cat (1)"},
+		{missingPlaceholder, "top: 1. Alpha — Ann (3), 2. Beta — Bob (2), 3. Unknown track (2), 4. Gamma — Ann, Cat (1)"},
+		{missingSkip, "top: 1. Alpha — Ann (3), 2. Beta — Bob (2), 3. Gamma — Ann, Cat (1)"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, store, nil, map[string]string{
+			"earbug.posting.enabled":  "false",
+			"earbug.metadata.missing": tt.mode,
+		})
+		rw := serve(s, http.MethodPost, "/summary?user=alice&fields=plays,top&warnings=false", "", nil)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.mode, rw.Code, rw.Body)
+		}
+		// totals count the track whatever the mode
+		if want := "2024-03-14 | 8 plays | " + tt.want; rw.Body.String() != want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.mode, rw.Body, want)
+		}
+	}
+}
+
+func TestMetadataMissingUnknownMode(t *testing.T) {
+	s := New(&http.Server{})
+	s.Register(nil)
+	s.missingMetadata = "hide"
+	if err := s.setup(context.Background()); err == nil || !strings.Contains(err.Error(), "earbug.metadata.missing") {
+		t.Errorf("got %v, want an earbug.metadata.missing error", err)
+	}
+}
+
+func TestRankByAffinity(t *testing.T) {
+	// Beta skipped a minute into each of 5 plays, Alpha played through 3 times,
+	// Gamma played last with nothing after it to time it by
+	plays := map[string][]string{
+		"t2": {"2024-03-14T10:00:00Z", "2024-03-14T10:01:00Z", "2024-03-14T10:02:00Z", "2024-03-14T10:03:00Z", "2024-03-14T10:04:00Z"},
+		"t1": {"2024-03-14T10:05:00Z", "2024-03-14T10:08:00Z", "2024-03-14T10:11:00Z"},
+		"t3": {"2024-03-14T10:14:00Z"},
+	}
+	ranked := func(data *earbugv3.Store, rankBy string) string {
+		cfg := summaryConfig{loc: time.UTC, sessionGap: 30 * time.Minute, podcasts: podcastsExclude, rankBy: rankBy}
+		var ids []string
+		for _, tc := range aggregate(data, "user", dayWindow("2024-03-14"), cfg).Top {
+			ids = append(ids, tc.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	data := testStore(plays)
+	if got, want := ranked(data, rankPlays), "t2,t1,t3"; got != want {
+		t.Errorf("by plays: got %s, want %s", got, want)
+	}
+	// Alpha 3 × 1, Beta 5 × 0.25, Gamma 1 play without a completion
+	if got, want := ranked(data, rankAffinity), "t1,t2,t3"; got != want {
+		t.Errorf("by affinity: got %s, want %s", got, want)
+	}
+
+	// without durations, counted as earbug.plays.zeroduration allows,
+	// there's no completion and affinity is the play count
+	for _, track := range data.Tracks {
+		track.Duration = nil
+	}
+	if got, want := ranked(data, rankAffinity), "t2,t1,t3"; got != want {
+		t.Errorf("by affinity without durations: got %s, want %s", got, want)
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	full, third := 1.0, 1.0/3
+	tests := []struct {
+		tc   TrackCount
+		want float64
+	}{
+		{TrackCount{Plays: 3, Completion: &full}, 3},
+		{TrackCount{Plays: 6, Completion: &third}, 2},
+		{TrackCount{Plays: 4}, 4},
+	}
+	for _, tt := range tests {
+		if got := affinity(tt.tc); got != tt.want {
+			t.Errorf("affinity(%d plays) = %v, want %v", tt.tc.Plays, got, tt.want)
+		}
+	}
+}
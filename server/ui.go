@@ -0,0 +1,29 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed templates/index.html
+var templatesFS embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(templatesFS, "templates/index.html"))
+
+// index serves a form for triggering summaries from a browser.
+func (s *Server) index(rw http.ResponseWriter, r *http.Request) {
+	if !s.ui || r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(rw, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("content-type", "text/html; charset=utf-8")
+	err := indexTemplate.Execute(rw, struct{ SummaryPath string }{"/summary"})
+	if err != nil {
+		s.log.Error(err, "render index", "ctx", r.Context(), "http_request", r)
+	}
+}
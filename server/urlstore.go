@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// parseStoreURLHosts parses the comma separated earbug.storeurl.hosts.
+func parseStoreURLHosts(raw string) map[string]struct{} {
+	hosts := make(map[string]struct{})
+	for _, h := range strings.Split(raw, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts[h] = struct{}{}
+		}
+	}
+	return hosts
+}
+
+// parseStoreURL validates a presigned store url from a request body,
+// only https urls to hosts in earbug.storeurl.hosts are accepted.
+func (s *Server) parseStoreURL(raw string) (*url.URL, error) {
+	if len(s.storeURLHosts) == 0 {
+		return nil, errors.New("storeURL disabled, set earbug.storeurl.hosts")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkStoreURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Server) checkStoreURL(u *url.URL) error {
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("storeURL must be an absolute https url")
+	}
+	if _, ok := s.storeURLHosts[u.Host]; !ok {
+		return fmt.Errorf("storeURL host %q not in earbug.storeurl.hosts", u.Host)
+	}
+	return nil
+}
+
+// newStoreURLClient is the client for store urls,
+// bounded by earbug.storeurl.timeout and only redirected to earbug.storeurl.hosts.
+// It's not the traced client, spans would record the url's signature.
+func (s *Server) newStoreURLClient() *http.Client {
+	return &http.Client{
+		Timeout: s.storeURLTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return s.checkStoreURL(req.URL)
+		},
+	}
+}
+
+// urlStore reads a single store object from a presigned url,
+// whatever name is asked for.
+type urlStore struct {
+	client *http.Client
+	u      *url.URL
+}
+
+func (u urlStore) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := u.client.Do(req)
+	if err != nil {
+		// the error includes the url and its signature
+		return nil, fmt.Errorf("get storeURL from %s: %w", u.u.Host, errors.Unwrap(err))
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, fmt.Errorf("get storeURL: %w", storage.ErrObjectNotExist)
+	case res.StatusCode != http.StatusOK:
+		res.Body.Close()
+		return nil, fmt.Errorf("get storeURL: unexpected status %s", res.Status)
+	}
+	return res.Body, nil
+}
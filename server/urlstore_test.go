@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreURLClient(t *testing.T) {
+	other := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("other"))
+	}))
+	defer other.Close()
+	allowed := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(rw, r, other.URL+"/store", http.StatusFound)
+		case "/moved":
+			http.Redirect(rw, r, "/store", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			rw.Write([]byte("store"))
+		}
+	}))
+	defer allowed.Close()
+	u, _ := url.Parse(allowed.URL)
+
+	s := newTestServer(t, &memStore{}, nil, map[string]string{
+		"earbug.posting.enabled":  "false",
+		"earbug.storeurl.hosts":   u.Host,
+		"earbug.storeurl.timeout": "50ms",
+	})
+	// both test servers share a certificate
+	s.storeURLClient.Transport = allowed.Client().Transport
+
+	read := func(path string) (string, error) {
+		u, err := s.parseStoreURL(allowed.URL + path)
+		if err != nil {
+			return "", err
+		}
+		or, err := urlStore{s.storeURLClient, u}.NewReader(context.Background(), "")
+		if err != nil {
+			return "", err
+		}
+		defer or.Close()
+		b, err := io.ReadAll(or)
+		return string(b), err
+	}
+	if got, err := read("/moved"); err != nil || got != "store" {
+		t.Errorf("redirect within hosts: got %q, %v", got, err)
+	}
+	if got, err := read("/away"); err == nil || !strings.Contains(err.Error(), "not in earbug.storeurl.hosts") {
+		t.Errorf("redirect to another host: got %q, %v, want rejected", got, err)
+	}
+	if got, err := read("/slow"); err == nil {
+		t.Errorf("slow read: got %q, want timeout", got)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// window is an inclusive range of dates (2006-01-02)
+// in the summary time zone.
+type window struct {
+	label string
+	from  string
+	to    string
+	// lastN limits the window to its most recent plays, if set
+	lastN int
+	// after limits the window to plays after it, if set
+	after time.Time
+	// partial windows end today and are still collecting plays
+	partial bool
+}
+
+func dayWindow(date string) window {
+	return window{
+		label: date,
+		from:  date,
+		to:    date,
+	}
+}
+
+func (w window) contains(day string) bool {
+	return day >= w.from && day <= w.to
+}
+
+func (w window) single() bool {
+	return w.from == w.to
+}
+
+// days returns every date in the window in order.
+func (w window) days() []string {
+	from, err := time.Parse(dateLayout, w.from)
+	if err != nil {
+		return nil
+	}
+	var days []string
+	for d := from; d.Format(dateLayout) <= w.to; d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(dateLayout))
+	}
+	return days
+}
+
+// before ends the window on the day before date.
+// The window is empty (from after to) if it starts on or after date.
+func (w window) before(date string) window {
+	if w.to < date {
+		return w
+	}
+	d, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return w
+	}
+	w.to = d.AddDate(0, 0, -1).Format(dateLayout)
+	w.partial = false
+	return w
+}
+
+func (w window) empty() bool {
+	return w.from > w.to
+}
+
+// lastPlays is the most recent n plays up to and including today.
+func lastPlays(n int, now time.Time, loc *time.Location) window {
+	return window{
+		label:   fmt.Sprintf("last %d plays", n),
+		to:      now.In(loc).Format(dateLayout),
+		lastN:   n,
+		partial: true,
+	}
+}
+
+// windowExplanation describes a window for debugging time zone issues.
+type windowExplanation struct {
+	Now      time.Time `json:"now"`
+	Timezone string    `json:"timezone"`
+	Label    string    `json:"label"`
+	// Start is the first instant in the window, End the first after it
+	Start *time.Time `json:"start,omitempty"`
+	End   time.Time  `json:"end"`
+	Days  []string   `json:"days,omitempty"`
+	LastN int        `json:"lastN,omitempty"`
+}
+
+func (w window) explain(now time.Time, loc *time.Location) windowExplanation {
+	ex := windowExplanation{
+		Now:      now.In(loc),
+		Timezone: loc.String(),
+		Label:    w.label,
+		Days:     w.days(),
+		LastN:    w.lastN,
+	}
+	if from, err := time.ParseInLocation(dateLayout, w.from, loc); err == nil {
+		ex.Start = &from
+	}
+	if to, err := time.ParseInLocation(dateLayout, w.to, loc); err == nil {
+		ex.End = to.AddDate(0, 0, 1)
+	}
+	return ex
+}
+
+// windowFunc picks the window to summarize for a request.
+type windowFunc func(r *http.Request, now time.Time, loc *time.Location) (window, error)
+
+// yesterday is the single previous day.
+func yesterday(r *http.Request, now time.Time, loc *time.Location) (window, error) {
+	return dayWindow(now.In(loc).AddDate(0, 0, -1).Format(dateLayout)), nil
+}
+
+// weekdayIndex is the position of day in its week, 0 for first.
+func weekdayIndex(day time.Weekday, first time.Weekday) int {
+	return (int(day) - int(first) + 7) % 7
+}
+
+// weekStart returns the first day of the week containing day,
+// for weeks starting on first.
+func weekStart(day time.Time, first time.Weekday) time.Time {
+	return day.AddDate(0, 0, -weekdayIndex(day.Weekday(), first))
+}
+
+// isoWeek is the current ISO week, or the one given as ?week=2024-W03.
+// With earbug.weekstart=sunday, weeks run from the Sunday before the ISO Monday.
+func (s *Server) isoWeek(r *http.Request, now time.Time, loc *time.Location) (window, error) {
+	y, m, d := now.In(loc).Date()
+	current := weekStart(time.Date(y, m, d, 0, 0, 0, 0, time.UTC), s.weekStart)
+	// the week is named by the ISO week of its Monday
+	year, week := current.AddDate(0, 0, weekdayIndex(time.Monday, s.weekStart)).ISOWeek()
+	if v := r.URL.Query().Get("week"); v != "" {
+		_, err := fmt.Sscanf(v, "%d-W%d", &year, &week)
+		if err != nil {
+			return window{}, fmt.Errorf("parse week %q: %w", v, err)
+		}
+		if y, w := isoWeekStart(year, week).ISOWeek(); week < 1 || y != year || w != week {
+			return window{}, invalidf("no week %d in %d", week, year)
+		}
+	}
+	start := weekStart(isoWeekStart(year, week), s.weekStart)
+	return window{
+		label: fmt.Sprintf("%d-W%02d", year, week),
+		from:  start.Format(dateLayout),
+		to:    start.AddDate(0, 0, 6).Format(dateLayout),
+	}, nil
+}
+
+// isoWeekStart returns the Monday starting the ISO week,
+// the first week being the one containing January 4.
+func isoWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	return weekStart(jan4, time.Monday).AddDate(0, 0, (week-1)*7)
+}
+
+// resolvePeriod returns the first and last dates of the named preset period
+// containing now in loc, weeks starting on first.
+// Periods starting with this end today.
+func resolvePeriod(name string, now time.Time, loc *time.Location, first time.Weekday) (from, to string, err error) {
+	// calendar arithmetic in UTC, AddDate on local midnights can shift across DST
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	week := weekStart(today, first)
+	var start, end time.Time
+	switch name {
+	case "today":
+		start, end = today, today
+	case "yesterday":
+		start = today.AddDate(0, 0, -1)
+		end = start
+	case "thisweek":
+		start, end = week, today
+	case "lastweek":
+		start, end = week.AddDate(0, 0, -7), week.AddDate(0, 0, -1)
+	case "thismonth":
+		start, end = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC), today
+	case "lastmonth":
+		// day 0 is the last day of the previous month
+		start, end = time.Date(y, m-1, 1, 0, 0, 0, 0, time.UTC), time.Date(y, m, 0, 0, 0, 0, 0, time.UTC)
+	case "ytd":
+		start, end = time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC), today
+	default:
+		return "", "", invalidf("unknown period %q, expected today, yesterday, thisweek, lastweek, thismonth, lastmonth, or ytd", name)
+	}
+	return start.Format(dateLayout), end.Format(dateLayout), nil
+}
+
+// periodWindow is the window for a preset period from resolvePeriod.
+func periodWindow(name string, now time.Time, loc *time.Location, first time.Weekday) (window, error) {
+	from, to, err := resolvePeriod(name, now, loc, first)
+	if err != nil {
+		return window{}, err
+	}
+	w := window{label: name, from: from, to: to}
+	w.partial = to == now.In(loc).Format(dateLayout)
+	if w.single() {
+		w.label = from
+	}
+	return w, nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResolvePeriod(t *testing.T) {
+	// a Thursday
+	now := time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		now      time.Time
+		loc      *time.Location
+		first    time.Weekday
+		from, to string
+	}{
+		{"today", now, time.UTC, time.Monday, "2024-03-14", "2024-03-14"},
+		{"yesterday", now, time.UTC, time.Monday, "2024-03-13", "2024-03-13"},
+		{"thisweek", now, time.UTC, time.Monday, "2024-03-11", "2024-03-14"},
+		{"thisweek", now, time.UTC, time.Sunday, "2024-03-10", "2024-03-14"},
+		{"lastweek", now, time.UTC, time.Monday, "2024-03-04", "2024-03-10"},
+		{"lastweek", now, time.UTC, time.Sunday, "2024-03-03", "2024-03-09"},
+		{"thismonth", now, time.UTC, time.Monday, "2024-03-01", "2024-03-14"},
+		{"lastmonth", now, time.UTC, time.Monday, "2024-02-01", "2024-02-29"},
+		{"ytd", now, time.UTC, time.Monday, "2024-01-01", "2024-03-14"},
+
+		// month boundaries
+		{"yesterday", time.Date(2024, time.March, 1, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2024-02-29", "2024-02-29"},
+		{"lastmonth", time.Date(2024, time.March, 31, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2024-02-01", "2024-02-29"},
+		{"lastmonth", time.Date(2024, time.January, 15, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2023-12-01", "2023-12-31"},
+		{"lastmonth", time.Date(2024, time.May, 31, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2024-04-01", "2024-04-30"},
+		{"thismonth", time.Date(2024, time.April, 1, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2024-04-01", "2024-04-01"},
+		{"lastweek", time.Date(2024, time.January, 3, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2023-12-25", "2023-12-31"},
+		{"ytd", time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2024-01-01", "2024-01-01"},
+
+		// leap years, and the years that aren't
+		{"lastmonth", time.Date(2023, time.March, 5, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2023-02-01", "2023-02-28"},
+		{"lastmonth", time.Date(2000, time.March, 5, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2000-02-01", "2000-02-29"},
+		{"lastmonth", time.Date(2100, time.March, 5, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2100-02-01", "2100-02-28"},
+		{"yesterday", time.Date(2023, time.March, 1, 8, 0, 0, 0, time.UTC), time.UTC, time.Monday, "2023-02-28", "2023-02-28"},
+
+		// the date is the one in loc, a day ahead of UTC here
+		{"thismonth", time.Date(2024, time.March, 31, 23, 0, 0, 0, time.UTC), time.FixedZone("+02", 2*60*60), time.Monday, "2024-04-01", "2024-04-01"},
+		{"lastmonth", time.Date(2024, time.March, 31, 23, 0, 0, 0, time.UTC), time.FixedZone("+02", 2*60*60), time.Monday, "2024-03-01", "2024-03-31"},
+	}
+	for _, tt := range tests {
+		from, to, err := resolvePeriod(tt.name, tt.now, tt.loc, tt.first)
+		if err != nil {
+			t.Errorf("%s at %v: %v", tt.name, tt.now, err)
+			continue
+		}
+		if from != tt.from || to != tt.to {
+			t.Errorf("%s at %v in %v from %v: got %s..%s, want %s..%s", tt.name, tt.now, tt.loc, tt.first, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestResolvePeriodDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// clocks moved forward on 2024-03-31
+	now := time.Date(2024, time.April, 2, 10, 0, 0, 0, loc)
+	from, to, err := resolvePeriod("lastmonth", now, loc, time.Monday)
+	if err != nil || from != "2024-03-01" || to != "2024-03-31" {
+		t.Errorf("got %s..%s, %v", from, to, err)
+	}
+}
+
+func TestResolvePeriodUnknown(t *testing.T) {
+	_, _, err := resolvePeriod("fortnight", time.Now(), time.UTC, time.Monday)
+	if err == nil {
+		t.Fatal("accepted unknown period")
+	}
+	// well formed but invalid, answered with 422 rather than 400
+	if code := optionsStatus(err); code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d", code)
+	}
+}
+
+func TestPeriodWindowLabel(t *testing.T) {
+	now := time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)
+	w, err := periodWindow("yesterday", now, time.UTC, time.Monday)
+	if err != nil || w.label != "2024-03-13" {
+		t.Errorf("single day label %q, %v", w.label, err)
+	}
+	w, err = periodWindow("lastweek", now, time.UTC, time.Monday)
+	if err != nil || w.label != "lastweek" {
+		t.Errorf("multi day label %q, %v", w.label, err)
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	thu := time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC)
+	sun := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		day   time.Time
+		first time.Weekday
+		want  string
+	}{
+		{thu, time.Monday, "2024-03-11"},
+		{thu, time.Sunday, "2024-03-10"},
+		// Sunday ends a Monday week and starts a Sunday one
+		{sun, time.Monday, "2024-03-04"},
+		{sun, time.Sunday, "2024-03-10"},
+	}
+	for _, tt := range tests {
+		if got := weekStart(tt.day, tt.first).Format(dateLayout); got != tt.want {
+			t.Errorf("weekStart(%s, %v) = %s, want %s", tt.day.Format(dateLayout), tt.first, got, tt.want)
+		}
+	}
+	if i := weekdayIndex(time.Sunday, time.Monday); i != 6 {
+		t.Errorf("Sunday in a Monday week at %d", i)
+	}
+	if i := weekdayIndex(time.Sunday, time.Sunday); i != 0 {
+		t.Errorf("Sunday in a Sunday week at %d", i)
+	}
+}
+
+func TestWeekStartDayOrder(t *testing.T) {
+	store := &memStore{}
+	plays := make(map[string][]string)
+	// a play every day from Sunday 2024-03-03 to Sunday 2024-03-17
+	for d := 3; d <= 17; d++ {
+		plays["t1"] = append(plays["t1"], time.Date(2024, time.March, d, 12, 0, 0, 0, time.UTC).Format(time.RFC3339))
+	}
+	store.putStore(t, "alice", testStore(plays))
+	tests := []struct {
+		weekstart string
+		days      string
+		first     string
+	}{
+		{"monday", "Mon 1, Tue 1, Wed 1, Thu 1, Fri 1, Sat 1, Sun 1", "2024-03-11"},
+		{"sunday", "Sun 1, Mon 1, Tue 1, Wed 1, Thu 1, Fri 1, Sat 1", "2024-03-10"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, store, nil, map[string]string{
+			"earbug.posting.enabled": "false",
+			"earbug.weekstart":       tt.weekstart,
+		})
+		rw := serve(s, http.MethodPost, "/summary/isoweek?user=alice&week=2024-W11&fields=days&warnings=false", "", nil)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.weekstart, rw.Code, rw.Body)
+		}
+		if got := rw.Body.String(); got != "2024-W11 | "+tt.days {
+			t.Errorf("%s: got %q, want days %s", tt.weekstart, got, tt.days)
+		}
+
+		rw = serve(s, http.MethodPost, "/summary/isoweek?user=alice&week=2024-W11", "", http.Header{"Accept": {"application/json"}})
+		var sum Summary
+		if err := json.NewDecoder(rw.Body).Decode(&sum); err != nil {
+			t.Fatal(err)
+		}
+		if len(sum.Days) != 7 || sum.Days[0].Date != tt.first {
+			t.Errorf("%s: got days %v, want 7 from %s", tt.weekstart, sum.Days, tt.first)
+		}
+	}
+}